@@ -0,0 +1,312 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/evoila/osb-backup-agent/errorlog"
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/evoila/osb-backup-agent/mutex"
+	"github.com/evoila/osb-backup-agent/storage"
+)
+
+// Type : Name of the destination type handled by this backend
+const Type = "AZURE"
+
+// blockSize : Size of the blocks a large backup is split into, the service allows up to 50000 blocks per blob
+const blockSize = 8 * 1024 * 1024
+
+// blockConcurrency : Number of blocks that are uploaded at the same time
+const blockConcurrency = 4
+
+// metadataHeaderPrefix : Prefix of the headers holding the metadata of a blob, in the canonical format of http headers
+const metadataHeaderPrefix = "X-Ms-Meta-"
+
+// Backend stores backups as block blobs in a container of an Azure storage account.
+type Backend struct{}
+
+func (b Backend) Validate(destination httpBodies.DestinationInformation, fileCanBeMissing bool) string {
+	missingFields := ""
+	if destination.Account_name == "" {
+		missingFields += " account_name"
+	}
+	if destination.Container_name == "" {
+		missingFields += " container_name"
+	}
+	if destination.Account_key == "" && destination.Sas_token == "" {
+		missingFields += " account_key/sas_token"
+	}
+	if destination.Filename == "" && !fileCanBeMissing {
+		missingFields += " filename"
+	}
+	return missingFields
+}
+
+// Redact returns the fields of the Azure destination with its credentials replaced.
+func (b Backend) Redact(destination httpBodies.DestinationInformation) httpBodies.DestinationInformation {
+	return httpBodies.DestinationInformation{
+		Type:           destination.Type,
+		Account_name:   destination.Account_name,
+		Container_name: destination.Container_name,
+		Filename:       destination.Filename,
+		Account_key:    httpBodies.GetRedactedOrEmptyPasswordString(destination.Account_key),
+		Sas_token:      httpBodies.GetRedactedOrEmptyPasswordString(destination.Sas_token),
+		Endpoint:       destination.Endpoint,
+		Metadata:       destination.Metadata,
+	}
+}
+
+// Upload stores small backups with a single request and splits larger ones into blocks, which are uploaded in parallel.
+func (b Backend) Upload(ctx context.Context, destination httpBodies.DestinationInformation, name string, reader io.Reader, size int64) (storage.ObjectInfo, error) {
+	c, err := newClient(destination)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+
+	log.Println("Uploading", name, "to container", destination.Container_name, "of", destination.Account_name)
+	if size >= 0 && size <= blockSize {
+		err = c.putBlob(ctx, name, reader, size, destination.Metadata)
+	} else {
+		err = c.putBlocks(ctx, name, reader, destination.Metadata)
+	}
+	if err != nil {
+		return storage.ObjectInfo{}, errorlog.LogError("Failed to upload to azure due to '", err.Error(), "'")
+	}
+	log.Printf("Successfully uploaded %q to %q\n", name, destination.Container_name)
+
+	return b.Stat(ctx, destination, name)
+}
+
+func (b Backend) Download(ctx context.Context, destination httpBodies.DestinationInformation, name string, writer io.Writer) (int64, error) {
+	c, err := newClient(destination)
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := c.do(ctx, http.MethodGet, name, nil, nil, nil, 0, http.StatusOK)
+	if err != nil {
+		return 0, errorlog.LogError("Failed to download the file ", name, " due to '", err.Error(), "'")
+	}
+	defer response.Body.Close()
+
+	written, err := io.Copy(writer, response.Body)
+	if err != nil {
+		return written, errorlog.LogError("Failed to download the file ", name, " due to '", err.Error(), "'")
+	}
+	return written, nil
+}
+
+func (b Backend) Stat(ctx context.Context, destination httpBodies.DestinationInformation, name string) (storage.ObjectInfo, error) {
+	c, err := newClient(destination)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+
+	response, err := c.do(ctx, http.MethodHead, name, nil, nil, nil, 0, http.StatusOK)
+	if err != nil {
+		return storage.ObjectInfo{}, errorlog.LogError("Failed to get the stats of ", name, " due to '", err.Error(), "'")
+	}
+	response.Body.Close()
+
+	lastModified, _ := http.ParseTime(response.Header.Get("Last-Modified"))
+	var metadata = make(map[string]string)
+	for key := range response.Header {
+		if strings.HasPrefix(key, metadataHeaderPrefix) {
+			metadata[strings.TrimPrefix(key, metadataHeaderPrefix)] = response.Header.Get(key)
+		}
+	}
+	return storage.ObjectInfo{Name: name, Size: response.ContentLength, LastModified: lastModified, Metadata: storage.GetMetadataWithLowerCaseKeys(metadata)}, nil
+}
+
+func (b Backend) List(ctx context.Context, destination httpBodies.DestinationInformation, prefix string) ([]storage.ObjectInfo, error) {
+	c, err := newClient(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []storage.ObjectInfo
+	var marker string
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		response, err := c.do(ctx, http.MethodGet, "", query, nil, nil, 0, http.StatusOK)
+		if err != nil {
+			return nil, errorlog.LogError("Failed to list the blobs of container ", destination.Container_name, " due to '", err.Error(), "'")
+		}
+		var result blobList
+		err = xml.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+		if err != nil {
+			return nil, errorlog.LogError("Failed to parse the blob list of container ", destination.Container_name, " due to '", err.Error(), "'")
+		}
+
+		for _, blob := range result.Blobs {
+			lastModified, _ := http.ParseTime(blob.LastModified)
+			objects = append(objects, storage.ObjectInfo{Name: blob.Name, Size: blob.ContentLength, LastModified: lastModified})
+		}
+		if result.NextMarker == "" {
+			return objects, nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+func (b Backend) Delete(ctx context.Context, destination httpBodies.DestinationInformation, name string) error {
+	c, err := newClient(destination)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.do(ctx, http.MethodDelete, name, nil, nil, nil, 0, http.StatusAccepted)
+	if err != nil {
+		return errorlog.LogError("Failed to delete ", name, " due to '", err.Error(), "'")
+	}
+	response.Body.Close()
+	return nil
+}
+
+type blobList struct {
+	Blobs []struct {
+		Name          string `xml:"Name"`
+		ContentLength int64  `xml:"Properties>Content-Length"`
+		LastModified  string `xml:"Properties>Last-Modified"`
+	} `xml:"Blobs>Blob"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+func (c *client) putBlob(ctx context.Context, name string, reader io.Reader, size int64, metadata map[string]string) error {
+	header := getMetadataHeader(metadata)
+	header.Set("X-Ms-Blob-Type", "BlockBlob")
+	// A reader with a size of 0 would otherwise be sent with chunked encoding, which the service rejects
+	if size == 0 {
+		reader = http.NoBody
+	}
+	response, err := c.do(ctx, http.MethodPut, name, nil, header, reader, size, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+// putBlocks reads the reader block by block, uploads up to blockConcurrency blocks at the same time and commits them afterwards.
+// Uncommitted blocks of a failed upload are discarded by the service after a week.
+func (c *client) putBlocks(ctx context.Context, name string, reader io.Reader, metadata map[string]string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var blockIds []string
+	var wait sync.WaitGroup
+	var errMutex = make(mutex.Mutex, 1)
+	errMutex.Release()
+	var firstErr error
+	slots := make(chan []byte, blockConcurrency)
+	for i := 0; i < blockConcurrency; i++ {
+		slots <- make([]byte, blockSize)
+	}
+
+	setErr := func(err error) {
+		errMutex.Acquire()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		errMutex.Release()
+	}
+
+	for index := 0; ; index++ {
+		var buffer []byte
+		select {
+		case buffer = <-slots:
+		case <-ctx.Done():
+		}
+		if buffer == nil {
+			break
+		}
+
+		n, err := io.ReadFull(reader, buffer)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		if err != nil {
+			setErr(err)
+			break
+		}
+		if n == 0 && index > 0 {
+			break
+		}
+
+		blockId := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%08d", index)))
+		blockIds = append(blockIds, blockId)
+
+		wait.Add(1)
+		go func(buffer []byte, n int) {
+			defer wait.Done()
+			defer func() { slots <- buffer }()
+			query := url.Values{"comp": {"block"}, "blockid": {blockId}}
+			response, err := c.do(ctx, http.MethodPut, name, query, nil, bytes.NewReader(buffer[:n]), int64(n), http.StatusCreated)
+			if err != nil {
+				setErr(err)
+				return
+			}
+			response.Body.Close()
+		}(buffer, n)
+
+		if n < blockSize {
+			break
+		}
+	}
+	wait.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	log.Println("Uploaded", len(blockIds), "blocks of", name, "-> committing the block list")
+	return c.putBlockList(ctx, name, blockIds, metadata)
+}
+
+// putBlockList commits the blocks as the content of the blob. The metadata is set by the commit, not by the blocks.
+func (c *client) putBlockList(ctx context.Context, name string, blockIds []string, metadata map[string]string) error {
+	var body strings.Builder
+	body.WriteString(xml.Header + "<BlockList>")
+	for _, blockId := range blockIds {
+		body.WriteString("<Latest>" + blockId + "</Latest>")
+	}
+	body.WriteString("</BlockList>")
+
+	header := getMetadataHeader(metadata)
+	header.Set("Content-Type", "application/xml")
+	response, err := c.do(ctx, http.MethodPut, name, url.Values{"comp": {"blocklist"}}, header, strings.NewReader(body.String()), int64(body.Len()), http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+// getMetadataHeader returns a header, which stores the metadata with the blob.
+func getMetadataHeader(metadata map[string]string) http.Header {
+	header := http.Header{}
+	for key, value := range metadata {
+		header.Set(metadataHeaderPrefix+key, value)
+	}
+	return header
+}
@@ -0,0 +1,360 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/httpBodies"
+)
+
+// The well known account of Azurite and the storage emulator
+const testAccountName = "devstoreaccount1"
+const testAccountKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IqsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+const testContainer = "backups"
+
+// fakeBlobService implements the parts of the Blob service REST API the backend uses, with paged listings of two blobs per page.
+// It verifies the shared key signature of every request.
+type fakeBlobService struct {
+	mutex    sync.Mutex
+	blobs    map[string]fakeBlob
+	blocks   map[string][]byte
+	requests []*http.Request
+}
+
+type fakeBlob struct {
+	content  []byte
+	metadata http.Header
+	modified time.Time
+}
+
+func newFakeBlobService() *fakeBlobService {
+	return &fakeBlobService{blobs: make(map[string]fakeBlob), blocks: make(map[string][]byte)}
+}
+
+func (s *fakeBlobService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = append(s.requests, r)
+
+	if r.URL.Query().Get("sig") == "" && !hasValidSignature(r) {
+		writeError(w, http.StatusForbidden, "AuthenticationFailed")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/"+testAccountName+"/"+testContainer)
+	name := strings.TrimPrefix(path, "/")
+	query := r.URL.Query()
+	body, _ := ioutil.ReadAll(r.Body)
+
+	switch {
+	case r.Method == http.MethodGet && name == "" && query.Get("comp") == "list":
+		s.list(w, query.Get("prefix"), query.Get("marker"))
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		s.blocks[name+"/"+query.Get("blockid")] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string `xml:"Latest"`
+		}
+		if err := xml.Unmarshal(body, &list); err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidXmlDocument")
+			return
+		}
+		var content []byte
+		for _, blockId := range list.Latest {
+			block, exists := s.blocks[name+"/"+blockId]
+			if !exists {
+				writeError(w, http.StatusBadRequest, "InvalidBlockList")
+				return
+			}
+			content = append(content, block...)
+		}
+		s.blobs[name] = fakeBlob{content: content, metadata: getMetadata(r.Header), modified: time.Now()}
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut:
+		if r.Header.Get("X-Ms-Blob-Type") != "BlockBlob" {
+			writeError(w, http.StatusBadRequest, "MissingRequiredHeader")
+			return
+		}
+		s.blobs[name] = fakeBlob{content: body, metadata: getMetadata(r.Header), modified: time.Now()}
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		blob, exists := s.blobs[name]
+		if !exists {
+			writeError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		for key, values := range blob.metadata {
+			w.Header()[key] = values
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(blob.content)))
+		w.Header().Set("Last-Modified", blob.modified.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(blob.content)
+		}
+	case r.Method == http.MethodDelete:
+		if _, exists := s.blobs[name]; !exists {
+			writeError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		delete(s.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		writeError(w, http.StatusBadRequest, "UnsupportedHttpVerb")
+	}
+}
+
+func (s *fakeBlobService) list(w http.ResponseWriter, prefix, marker string) {
+	var names []string
+	for name := range s.blobs {
+		if strings.HasPrefix(name, prefix) && name > marker {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var result strings.Builder
+	result.WriteString(xml.Header + "<EnumerationResults><Blobs>")
+	for i, name := range names {
+		if i == 2 {
+			break
+		}
+		blob := s.blobs[name]
+		result.WriteString("<Blob><Name>" + name + "</Name><Properties><Content-Length>" + strconv.Itoa(len(blob.content)) +
+			"</Content-Length><Last-Modified>" + blob.modified.UTC().Format(http.TimeFormat) + "</Last-Modified></Properties></Blob>")
+	}
+	result.WriteString("</Blobs><NextMarker>")
+	if len(names) > 2 {
+		result.WriteString(names[1])
+	}
+	result.WriteString("</NextMarker></EnumerationResults>")
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(result.String()))
+}
+
+func getMetadata(header http.Header) http.Header {
+	metadata := http.Header{}
+	for key, values := range header {
+		if strings.HasPrefix(key, metadataHeaderPrefix) {
+			metadata[key] = values
+		}
+	}
+	return metadata
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("x-ms-error-code", code)
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header + "<Error><Code>" + code + "</Code><Message>" + code + "\nRequestId:1</Message></Error>"))
+}
+
+// hasValidSignature checks the shared key signature as described in the documentation of the service.
+func hasValidSignature(r *http.Request) bool {
+	var headerNames []string
+	for name := range r.Header {
+		if strings.HasPrefix(strings.ToLower(name), "x-ms-") {
+			headerNames = append(headerNames, strings.ToLower(name))
+		}
+	}
+	sort.Strings(headerNames)
+	var canonicalizedHeaders string
+	for _, name := range headerNames {
+		canonicalizedHeaders += name + ":" + r.Header.Get(name) + "\n"
+	}
+
+	canonicalizedResource := "/" + testAccountName + r.URL.EscapedPath()
+	var queryNames []string
+	for name := range r.URL.Query() {
+		queryNames = append(queryNames, name)
+	}
+	sort.Strings(queryNames)
+	for _, name := range queryNames {
+		canonicalizedResource += "\n" + name + ":" + strings.Join(r.URL.Query()[name], ",")
+	}
+
+	contentLength := ""
+	if r.ContentLength > 0 {
+		contentLength = strconv.FormatInt(r.ContentLength, 10)
+	}
+	stringToSign := r.Method + "\n" + r.Header.Get("Content-Encoding") + "\n" + r.Header.Get("Content-Language") + "\n" + contentLength + "\n" +
+		r.Header.Get("Content-MD5") + "\n" + r.Header.Get("Content-Type") + "\n\n" + r.Header.Get("If-Modified-Since") + "\n" +
+		r.Header.Get("If-Match") + "\n" + r.Header.Get("If-None-Match") + "\n" + r.Header.Get("If-Unmodified-Since") + "\n" +
+		r.Header.Get("Range") + "\n" + canonicalizedHeaders + canonicalizedResource
+
+	key, _ := base64.StdEncoding.DecodeString(testAccountKey)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	expected := "SharedKey " + testAccountName + ":" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(r.Header.Get("Authorization")), []byte(expected))
+}
+
+// getTestDestination returns a destination of the fake service or, if AZURITE_BLOB_ENDPOINT is set,
+// of Azurite, for example "http://127.0.0.1:10000/devstoreaccount1".
+func getTestDestination(t *testing.T) (httpBodies.DestinationInformation, func()) {
+	destination := httpBodies.DestinationInformation{
+		Type:           Type,
+		Account_name:   testAccountName,
+		Account_key:    testAccountKey,
+		Container_name: testContainer,
+	}
+	if endpoint := os.Getenv("AZURITE_BLOB_ENDPOINT"); endpoint != "" {
+		destination.Container_name = "test" + strconv.FormatInt(time.Now().UnixNano(), 10)
+		destination.Endpoint = endpoint
+		c, err := newClient(destination)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := c.do(context.Background(), http.MethodPut, "", url.Values{"restype": {"container"}}, nil, nil, 0, http.StatusCreated)
+		if err != nil {
+			t.Fatalf("Creating the container failed: %v", err)
+		}
+		response.Body.Close()
+		return destination, func() {
+			c.do(context.Background(), http.MethodDelete, "", url.Values{"restype": {"container"}}, nil, nil, 0, http.StatusAccepted)
+		}
+	}
+
+	server := httptest.NewServer(newFakeBlobService())
+	destination.Endpoint = server.URL + "/" + testAccountName
+	return destination, server.Close
+}
+
+func getTestData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7 % 251)
+	}
+	return data
+}
+
+func testUploadAndDownload(t *testing.T, size int) {
+	destination, done := getTestDestination(t)
+	defer done()
+	destination.Metadata = map[string]string{"Compression": "gzip"}
+	data := getTestData(size)
+
+	info, err := Backend{}.Upload(context.Background(), destination, "db.tar.gz", bytes.NewReader(data), int64(size))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if info.Size != int64(size) || info.Metadata["compression"] != "gzip" {
+		t.Errorf("Unexpected stats of the upload: %+v", info)
+	}
+
+	var downloaded bytes.Buffer
+	n, err := Backend{}.Download(context.Background(), destination, "db.tar.gz", &downloaded)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if n != int64(size) || !bytes.Equal(downloaded.Bytes(), data) {
+		t.Errorf("The downloaded data differs from the uploaded data, got %d bytes", n)
+	}
+}
+
+func TestUploadAndDownloadOfASingleBlob(t *testing.T) {
+	testUploadAndDownload(t, 1000)
+}
+
+func TestUploadAndDownloadOfAnEmptyBlob(t *testing.T) {
+	testUploadAndDownload(t, 0)
+}
+
+func TestUploadAndDownloadInBlocks(t *testing.T) {
+	testUploadAndDownload(t, 2*blockSize+1000)
+}
+
+func TestUploadOfAStreamOfUnknownSize(t *testing.T) {
+	destination, done := getTestDestination(t)
+	defer done()
+	data := getTestData(blockSize + 1)
+
+	info, err := Backend{}.Upload(context.Background(), destination, "db.tar", ioutil.NopCloser(bytes.NewReader(data)), -1)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if info.Size != int64(len(data)) {
+		t.Errorf("Expected a blob of %d bytes, got %d", len(data), info.Size)
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	destination, done := getTestDestination(t)
+	defer done()
+	for _, name := range []string{"db_1.tar", "db_2.tar", "db_3.tar", "other.tar"} {
+		if _, err := (Backend{}).Upload(context.Background(), destination, name, strings.NewReader(name), int64(len(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objects, err := Backend{}.List(context.Background(), destination, "db_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 3 || objects[2].Name != "db_3.tar" || objects[2].Size != int64(len("db_3.tar")) {
+		t.Errorf("Expected the three blobs with the prefix over all pages, got %+v", objects)
+	}
+
+	if err = (Backend{}).Delete(context.Background(), destination, "db_1.tar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = (Backend{}).Stat(context.Background(), destination, "db_1.tar"); err == nil {
+		t.Error("The deleted blob still exists")
+	}
+	if err = (Backend{}).Delete(context.Background(), destination, "db_1.tar"); err == nil {
+		t.Error("Deleting a missing blob succeeded")
+	}
+}
+
+func TestWrongAccountKeyIsRejected(t *testing.T) {
+	destination, done := getTestDestination(t)
+	defer done()
+	destination.Account_key = base64.StdEncoding.EncodeToString([]byte("wrong key"))
+
+	_, err := Backend{}.Upload(context.Background(), destination, "db.tar", strings.NewReader("data"), 4)
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected the upload to be rejected with status 403, got %v", err)
+	}
+}
+
+func TestSasTokenIsSentInsteadOfASignature(t *testing.T) {
+	service := newFakeBlobService()
+	server := httptest.NewServer(service)
+	defer server.Close()
+	destination := httpBodies.DestinationInformation{Type: Type, Account_name: testAccountName, Container_name: testContainer,
+		Sas_token: "?sv=2020-10-02&sp=rwdl&sig=c2lnbmF0dXJl", Endpoint: server.URL + "/" + testAccountName}
+
+	if _, err := (Backend{}).Upload(context.Background(), destination, "db.tar", strings.NewReader("data"), 4); err != nil {
+		t.Fatalf("Upload with the SAS token failed: %v", err)
+	}
+	for _, request := range service.requests {
+		if request.Header.Get("Authorization") != "" || request.URL.Query().Get("sig") != "c2lnbmF0dXJl" {
+			t.Errorf("The request %s %s was not authorized by the SAS token alone", request.Method, request.URL)
+		}
+	}
+}
+
+func TestCancelledUploadFails(t *testing.T) {
+	destination, done := getTestDestination(t)
+	defer done()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := (Backend{}).Upload(ctx, destination, "db.tar", bytes.NewReader(getTestData(blockSize+1)), blockSize+1); err == nil {
+		t.Fatal("The cancelled upload succeeded")
+	}
+}
@@ -0,0 +1,192 @@
+package azure
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/errorlog"
+	"github.com/evoila/osb-backup-agent/httpBodies"
+)
+
+// apiVersion : Version of the Blob service REST API used for all requests
+const apiVersion = "2020-10-02"
+
+var httpClient = &http.Client{}
+
+// client sends authenticated requests to the Blob service of a single storage account.
+type client struct {
+	accountName string
+	accountKey  []byte
+	sasToken    url.Values
+	endpoint    *url.URL
+	container   string
+}
+
+// responseError is returned for responses with an unexpected status code.
+type responseError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *responseError) Error() string {
+	return errorlog.Concat([]string{"azure responded with status ", strconv.Itoa(e.StatusCode), " ", e.Code, " ", e.Message}, "")
+}
+
+func newClient(destination httpBodies.DestinationInformation) (*client, error) {
+	c := &client{accountName: destination.Account_name, container: destination.Container_name}
+
+	endpoint := destination.Endpoint
+	if endpoint == "" {
+		endpoint = "https://" + destination.Account_name + ".blob.core.windows.net"
+	}
+	var err error
+	if c.endpoint, err = url.Parse(strings.TrimSuffix(endpoint, "/")); err != nil {
+		return nil, errorlog.LogError("Parsing the endpoint ", endpoint, " failed due to '", err.Error(), "'")
+	}
+
+	if destination.Sas_token != "" {
+		if c.sasToken, err = url.ParseQuery(strings.TrimPrefix(destination.Sas_token, "?")); err != nil {
+			return nil, errorlog.LogError("Parsing the SAS token failed due to '", err.Error(), "'")
+		}
+	} else if c.accountKey, err = base64.StdEncoding.DecodeString(destination.Account_key); err != nil {
+		return nil, errorlog.LogError("Decoding the account key failed due to '", err.Error(), "'")
+	}
+	return c, nil
+}
+
+// getURL returns the url of the blob with the given name, or of the container if the name is empty.
+func (c *client) getURL(blob string, query url.Values) *url.URL {
+	u := *c.endpoint
+	u.Path = u.Path + "/" + c.container
+	if blob != "" {
+		u.Path = u.Path + "/" + blob
+	}
+	u.RawPath = ""
+
+	values := url.Values{}
+	for key, value := range c.sasToken {
+		values[key] = value
+	}
+	for key, value := range query {
+		values[key] = value
+	}
+	u.RawQuery = values.Encode()
+	return &u
+}
+
+// do sends the request and returns an error for every status code that is not expected.
+// The body of the returned response has to be closed by the caller.
+func (c *client) do(ctx context.Context, method, blob string, query url.Values, header http.Header, body io.Reader, contentLength int64, expectedStatus ...int) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, c.getURL(blob, query).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	request.ContentLength = contentLength
+	request.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	request.Header.Set("x-ms-version", apiVersion)
+	if c.sasToken == nil {
+		request.Header.Set("Authorization", "SharedKey "+c.accountName+":"+c.sign(request))
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range expectedStatus {
+		if response.StatusCode == status {
+			return response, nil
+		}
+	}
+	defer response.Body.Close()
+	return nil, readResponseError(response)
+}
+
+// sign returns the shared key signature of the request.
+// See https://docs.microsoft.com/rest/api/storageservices/authorize-with-shared-key
+func (c *client) sign(request *http.Request) string {
+	contentLength := ""
+	if request.ContentLength > 0 {
+		contentLength = strconv.FormatInt(request.ContentLength, 10)
+	}
+
+	stringToSign := strings.Join([]string{
+		request.Method,
+		request.Header.Get("Content-Encoding"),
+		request.Header.Get("Content-Language"),
+		contentLength,
+		request.Header.Get("Content-MD5"),
+		request.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		request.Header.Get("If-Modified-Since"),
+		request.Header.Get("If-Match"),
+		request.Header.Get("If-None-Match"),
+		request.Header.Get("If-Unmodified-Since"),
+		request.Header.Get("Range"),
+		getCanonicalizedHeaders(request.Header) + getCanonicalizedResource(c.accountName, request.URL),
+	}, "\n")
+
+	mac := hmac.New(sha256.New, c.accountKey)
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func getCanonicalizedHeaders(header http.Header) string {
+	var names []string
+	for name := range header {
+		if lowerName := strings.ToLower(name); strings.HasPrefix(lowerName, "x-ms-") {
+			names = append(names, lowerName)
+		}
+	}
+	sort.Strings(names)
+
+	var canonicalized strings.Builder
+	for _, name := range names {
+		canonicalized.WriteString(name + ":" + strings.TrimSpace(header.Get(name)) + "\n")
+	}
+	return canonicalized.String()
+}
+
+func getCanonicalizedResource(accountName string, u *url.URL) string {
+	canonicalized := "/" + accountName + u.EscapedPath()
+
+	query := u.Query()
+	var names []string
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := query[name]
+		sort.Strings(values)
+		canonicalized += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+	return canonicalized
+}
+
+func readResponseError(response *http.Response) error {
+	var details struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 64*1024))
+	xml.Unmarshal(body, &details)
+	if details.Code == "" {
+		details.Code = response.Header.Get("x-ms-error-code")
+	}
+	return &responseError{StatusCode: response.StatusCode, Code: details.Code, Message: strings.TrimSpace(strings.SplitN(details.Message, "\n", 2)[0])}
+}
@@ -1,22 +1,28 @@
 package backup
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"time"
 
+	"github.com/evoila/osb-backup-agent/compression"
 	"github.com/evoila/osb-backup-agent/configuration"
+	"github.com/evoila/osb-backup-agent/encryption"
 	"github.com/evoila/osb-backup-agent/errorlog"
 	"github.com/evoila/osb-backup-agent/httpBodies"
 	"github.com/evoila/osb-backup-agent/jobs"
-	"github.com/evoila/osb-backup-agent/s3"
+	"github.com/evoila/osb-backup-agent/logstream"
+	"github.com/evoila/osb-backup-agent/pipeline"
 	"github.com/evoila/osb-backup-agent/security"
 	"github.com/evoila/osb-backup-agent/shell"
-	"github.com/evoila/osb-backup-agent/swift"
+	"github.com/evoila/osb-backup-agent/storage"
 	"github.com/evoila/osb-backup-agent/timeutil"
 	"github.com/evoila/osb-backup-agent/utils"
 	"github.com/gorilla/mux"
@@ -31,12 +37,96 @@ const NamePreBackupCheck = "pre-backup-check"
 // NameBackup : Name of the script to call for the backup stage
 const NameBackup = "backup"
 
+// NameUpload : Name of the stage that uploads the backup file to the destination
+const NameUpload = "upload"
+
 // NameBackupCleanup :  Name of the script to call for the backup-cleanup stage
 const NameBackupCleanup = "backup-cleanup"
 
 // NamePostBackupUnlock : Name of the script to call for the post-backup-unlock stage
 const NamePostBackupUnlock = "post-backup-unlock"
 
+func HandleCancel(w http.ResponseWriter, r *http.Request) {
+	handleCancel(w, r, getResponseV1)
+}
+
+func HandleCancelV2(w http.ResponseWriter, r *http.Request) {
+	handleCancel(w, r, httpBodies.GetBackupResponseV2)
+}
+
+func handleCancel(w http.ResponseWriter, r *http.Request, view func(*httpBodies.BackupResponse) interface{}) {
+	log.Println("-- Backup cancel request received. --")
+
+	if !security.BasicAuth(w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	Id, exists := vars["id"]
+	if !exists {
+		w.WriteHeader(400)
+		return
+	}
+
+	job, existingJob := jobs.GetBackupJob(Id)
+	if !existingJob {
+		w.WriteHeader(404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if jobs.CancelBackupJob(Id) {
+		w.WriteHeader(202)
+	} else {
+		log.Println("Job is not running -> showing current result.")
+		w.WriteHeader(409)
+	}
+	json.NewEncoder(w).Encode(view(job))
+	log.Println("-- Backup cancel request completed. --")
+}
+
+func HandleLogs(w http.ResponseWriter, r *http.Request) {
+	log.Println("-- Backup log request received. --")
+
+	if !security.BasicAuth(w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	Id, exists := vars["id"]
+	if !exists {
+		w.WriteHeader(400)
+		return
+	}
+
+	if _, existingJob := jobs.GetBackupJob(Id); !existingJob {
+		w.WriteHeader(404)
+		return
+	}
+
+	follow, valid := utils.ParseFollowParameter(w, r)
+	if !valid {
+		return
+	}
+
+	logstream.ServeEvents(w, r, logstream.BackupJobKey(Id), follow, func() []httpBodies.StageResult {
+		job, _ := jobs.GetBackupJob(Id)
+		if job == nil {
+			return nil
+		}
+		return job.Stages
+	}, func() string {
+		job, _ := jobs.GetBackupJob(Id)
+		if job == nil {
+			return ""
+		}
+		return job.Status
+	})
+	log.Println("-- Backup log request completed. --")
+}
+
 func RemoveJob(w http.ResponseWriter, r *http.Request) {
 	log.Println("-- Backup job deletion request received. --")
 
@@ -58,7 +148,53 @@ func RemoveJob(w http.ResponseWriter, r *http.Request) {
 	log.Println("-- Backup job deletion request completed. --")
 }
 
+func HandleListing(w http.ResponseWriter, r *http.Request) {
+	handleListing(w, r, getResponseV1)
+}
+
+func HandleListingV2(w http.ResponseWriter, r *http.Request) {
+	handleListing(w, r, httpBodies.GetBackupResponseV2)
+}
+
+func handleListing(w http.ResponseWriter, r *http.Request, view func(*httpBodies.BackupResponse) interface{}) {
+	log.Println("-- Backup listing request received. --")
+
+	if !security.BasicAuth(w, r) {
+		return
+	}
+
+	query, valid := utils.ParseJobListQuery(w, r)
+	if !valid {
+		return
+	}
+
+	entries := jobs.FindBackupJobs(query.Filter)
+	start, end := query.GetPageBounds(len(entries))
+
+	var response = httpBodies.JobListResponse{Total: len(entries), Offset: query.Offset, Limit: query.Limit, Jobs: []httpBodies.JobListEntry{}}
+	for _, entry := range entries[start:end] {
+		job := entry.Job
+		if !query.Logs {
+			job = httpBodies.GetBackupResponseWithoutLogs(job)
+		}
+		response.Jobs = append(response.Jobs, httpBodies.JobListEntry{Id: entry.Id, Job: view(job)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(response)
+	log.Println("-- Backup listing request completed. --")
+}
+
 func HandlePolling(w http.ResponseWriter, r *http.Request) {
+	handlePolling(w, r, getResponseV1)
+}
+
+func HandlePollingV2(w http.ResponseWriter, r *http.Request) {
+	handlePolling(w, r, httpBodies.GetBackupResponseV2)
+}
+
+func handlePolling(w http.ResponseWriter, r *http.Request, view func(*httpBodies.BackupResponse) interface{}) {
 	log.Println("-- Backup status request received. --")
 
 	if !security.BasicAuth(w, r) {
@@ -81,11 +217,19 @@ func HandlePolling(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(job)
+	json.NewEncoder(w).Encode(view(job))
 	log.Println("-- Backup status request completed. --")
 }
 
 func HandleAsyncRequest(w http.ResponseWriter, r *http.Request) {
+	handleAsyncRequest(w, r, getResponseV1)
+}
+
+func HandleAsyncRequestV2(w http.ResponseWriter, r *http.Request) {
+	handleAsyncRequest(w, r, httpBodies.GetBackupResponseV2)
+}
+
+func handleAsyncRequest(w http.ResponseWriter, r *http.Request, view func(*httpBodies.BackupResponse) interface{}) {
 	log.Println("-- Async Backup request received. --")
 
 	if !security.BasicAuth(w, r) {
@@ -106,7 +250,7 @@ func HandleAsyncRequest(w http.ResponseWriter, r *http.Request) {
 		log.Println("Job does exist -> showing current result.")
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(409)
-		json.NewEncoder(w).Encode(job)
+		json.NewEncoder(w).Encode(view(job))
 	} else {
 		// No job exists yet -> create new one
 		log.Println("Job does not exist yet -> creating a new one.")
@@ -115,6 +259,10 @@ func HandleAsyncRequest(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if !utils.AreTimeoutsValid(w, r, body.Timeouts, "Backup") {
+			return
+		}
+
 		allFieldsExist, missingFields := httpBodies.CheckForMissingFieldsInBackupBody(body)
 		if !allFieldsExist {
 			err = errors.New("body is missing essential fields:" + missingFields)
@@ -125,7 +273,7 @@ func HandleAsyncRequest(w http.ResponseWriter, r *http.Request) {
 
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(400)
-			json.NewEncoder(w).Encode(response)
+			json.NewEncoder(w).Encode(view(&response))
 			return
 		}
 
@@ -142,7 +290,7 @@ func HandleAsyncRequest(w http.ResponseWriter, r *http.Request) {
 			jobs.DecreaseCurrentJobCount()
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(409)
-			json.NewEncoder(w).Encode(response)
+			json.NewEncoder(w).Encode(view(&response))
 			return
 		}
 
@@ -161,10 +309,25 @@ func Backup(body httpBodies.BackupBody, job *httpBodies.BackupResponse) *httpBod
 	log.Println("Database", body.Backup.Database, "is supposed to get a new backup.")
 	httpBodies.PrintOutBackupBody(body)
 
+	ctx := jobs.StartBackupJobContext(body.Id)
+	defer jobs.FinishBackupJobContext(body.Id)
+
+	ctx = logstream.NewContext(ctx, logstream.Start(logstream.BackupJobKey(body.Id)))
+	defer logstream.Finish(logstream.BackupJobKey(body.Id))
+
+	var jobTimeout = utils.GetJobTimeout(body.Timeouts)
+	if jobTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, jobTimeout)
+		defer cancel()
+	}
+
 	response, _ := jobs.GetBackupJob(body.Id)
 	response.Message = "backup is running"
 	response.Type = body.Destination.Type
 	response.Compression = body.Compression
+	response.CompressionAlgorithm = body.Compression_algorithm
+	response.EncryptionAlgorithm = body.Encryption_algorithm
 	response.Status = httpBodies.Status_running
 	response.Bucket = body.Destination.Bucket
 	response.Region = body.Destination.Region
@@ -172,13 +335,10 @@ func Backup(body httpBodies.BackupBody, job *httpBodies.BackupResponse) *httpBod
 	response.Domain = body.Destination.Domain
 	response.ContainerName = body.Destination.Container_name
 	response.ProjectName = body.Destination.Project_name
+	response.Database = body.Backup.Database
 
 	jobs.UpdateBackupJob(body.Id, response)
 
-	// Set up variables for filling response bodies later on
-	var fileSize int64
-	var err error
-
 	// Get environment parameters from request body
 	var envParameters = httpBodies.GetParametersAsEnvVarStringSlice(body.Backup.Parameters)
 
@@ -190,77 +350,13 @@ func Backup(body httpBodies.BackupBody, job *httpBodies.BackupResponse) *httpBod
 	response.StartTime = startTime
 	jobs.UpdateBackupJob(body.Id, response)
 
-	// Start execution of scripts
-	var status = true
-	if status {
-		response.State = NamePreBackupLock
-		jobs.UpdateBackupJob(body.Id, response)
-
-		log.Println("> Starting", response.State, "stage.")
-		status, response.PreBackupLockLog, response.PreBackupLockErrorLog, err = shell.ExecuteScriptForStage(NamePreBackupLock, envParameters, body.Backup.Database)
-		jobs.UpdateBackupJob(body.Id, response)
-		log.Println("> Finishing", response.State, "stage.")
-	}
-	if status {
-		response.State = NamePreBackupCheck
-		jobs.UpdateBackupJob(body.Id, response)
-
-		log.Println("> Starting", response.State, "stage.")
-		status, response.PreBackupCheckLog, response.PreBackupCheckErrorLog, err = shell.ExecuteScriptForStage(NamePreBackupCheck, envParameters, body.Backup.Database)
-		jobs.UpdateBackupJob(body.Id, response)
-		log.Println("> Finishing", response.State, "stage.")
-	}
-	if status {
-		response.State = NameBackup
-		jobs.UpdateBackupJob(body.Id, response)
-
-		log.Println("> Starting", response.State, "stage.")
-		var filename = GetBackupFilename(body.Backup.Host, body.Backup.Database)
-		status, response.BackupLog, response.BackupErrorLog, err = shell.ExecuteScriptForStage(NameBackup, envParameters,
-			body.Backup.Host, body.Backup.Username, body.Backup.Password, body.Backup.Database, filename, body.Id, strconv.FormatBool(body.Compression), body.Encryption_key)
-		jobs.UpdateBackupJob(body.Id, response)
-		if err != nil {
-			status = false
-			err = errorlog.LogError("Executing the shell script failed due to '", err.Error(), "'")
-		} else {
-			if body.Destination.Type == "S3" {
-				response.FileName, fileSize, err = upload(body, body.Destination.Type)
-				jobs.UpdateBackupJob(body.Id, response)
-			} else if body.Destination.Type == "SWIFT" {
-				response.FileName, fileSize, err = upload(body, body.Destination.Type)
-			} else {
-				status = false
-				err = errors.New("type is not supported")
-			}
-
-			if err != nil {
-				status = false
-				err = errorlog.LogError("Uploading to "+body.Destination.Type+" failed due to '", err.Error(), "'")
-			}
-			response.FileSize = httpBodies.FileSize{Size: fileSize, Unit: "byte"}
-			jobs.UpdateBackupJob(body.Id, response)
-		}
-
-		log.Println("> Finishing", response.State, "stage.")
-	}
-	if status {
-		response.State = NameBackupCleanup
-		jobs.UpdateBackupJob(body.Id, response)
-
-		log.Println("> Starting", response.State, "stage.")
-		status, response.BackupCleanupLog, response.BackupCleanupErrorLog, err = shell.ExecuteScriptForStage(NameBackupCleanup, envParameters, body.Backup.Database, body.Id)
-		jobs.UpdateBackupJob(body.Id, response)
-		log.Println("> Finishing", response.State, "stage.")
-	}
-	if status {
-		response.State = NamePostBackupUnlock
-		jobs.UpdateBackupJob(body.Id, response)
-
-		log.Println("> Starting", response.State, "stage.")
-		status, response.PostBackupUnlockLog, response.PostBackupUnlockErrorLog, err = shell.ExecuteScriptForStage(NamePostBackupUnlock, envParameters, body.Backup.Database)
-		jobs.UpdateBackupJob(body.Id, response)
-		log.Println("> Finishing", response.State, "stage.")
-	}
+	// Start execution of the stages
+	var outcome = newPipeline(body, response).Run(ctx, envParameters)
+	var err = outcome.Err
+	var interruptedStage = outcome.InterruptedStage
+	var cancelled = outcome.Cancelled
+	var timedOut = outcome.TimedOut
+	var status = err == nil
 
 	// Set end time and calculate execution time
 	currentTime = time.Now()
@@ -273,7 +369,29 @@ func Backup(body httpBodies.BackupBody, job *httpBodies.BackupResponse) *httpBod
 	jobs.UpdateBackupJob(body.Id, response)
 
 	// Write standard or error response according to status
-	if status {
+	if cancelled {
+		err = errorlog.LogError("Backup was cancelled during stage ", interruptedStage)
+
+		response.Status = httpBodies.Status_cancelled
+		response.Message = "backup cancelled"
+		response.ErrorMessage = err.Error()
+
+		log.Println("Updating backup job", body.Id, "with a cancellation response.")
+		jobs.UpdateBackupJob(body.Id, response)
+	} else if timedOut || pipeline.IsTimeoutError(err) {
+		if timedOut {
+			err = errorlog.LogError("Backup exceeded the job timeout of ", jobTimeout.String(), " during stage ", interruptedStage)
+		} else {
+			err = errorlog.LogError("Backup timed out due to '", err.Error(), "'")
+		}
+
+		response.Status = httpBodies.Status_failed
+		response.Message = "backup timed out"
+		response.ErrorMessage = err.Error()
+
+		log.Println("Updating backup job", body.Id, "with a timeout response.")
+		jobs.UpdateBackupJob(body.Id, response)
+	} else if status {
 		response.Status = httpBodies.Status_success
 		response.Message = "backup successfully carried out"
 		log.Println("Backup successfully created")
@@ -302,32 +420,179 @@ func Backup(body httpBodies.BackupBody, job *httpBodies.BackupResponse) *httpBod
 	return response
 }
 
-func upload(body httpBodies.BackupBody, uploadType string) (string, int64, error) {
+// newPipeline defines the stages of a backup job, including the custom stages of the configuration.
+// The progress of the stages is written into the given response.
+func newPipeline(body httpBodies.BackupBody, response *httpBodies.BackupResponse) *pipeline.Pipeline {
+	var getTimeout = func(name string) time.Duration {
+		return utils.GetStageTimeout(body.Timeouts, name)
+	}
+	var filename = GetBackupFilename(body.Backup.Host, body.Backup.Database)
+
+	var stages = []pipeline.Stage{
+		pipeline.ScriptStage(NamePreBackupLock, true, false, getTimeout(NamePreBackupLock), body.Backup.Database),
+		pipeline.ScriptStage(NamePreBackupCheck, true, false, getTimeout(NamePreBackupCheck), body.Backup.Database),
+	}
+	var backupParams = getScriptParams(body, filename)
+	if body.Streaming == "" {
+		stages = append(stages,
+			pipeline.ScriptStage(NameBackup, true, false, getTimeout(NameBackup), backupParams...),
+			pipeline.Stage{
+				Name:     NameUpload,
+				Required: true,
+				Timeout:  getTimeout(NameUpload),
+				Run: func(ctx context.Context, env []string) pipeline.Result {
+					fileName, info, err := upload(ctx, body, body.Destination.Type)
+					if err != nil {
+						err = errorlog.LogError("Uploading to "+body.Destination.Type+" failed due to '", err.Error(), "'")
+					}
+					setUploadResult(response, fileName, info)
+					return pipeline.Result{Err: err}
+				},
+			})
+	} else {
+		// The backup script's data is uploaded while the script writes it, so there is no separate upload stage
+		var pipePath string
+		if body.Streaming == httpBodies.Streaming_pipe {
+			pipePath = configuration.GetBackupDirectory() + "/" + body.Id + "/" + filename
+		}
+		stages = append(stages, pipeline.OutputStreamStage(NameBackup, getTimeout(NameBackup), pipePath, func(ctx context.Context, reader io.Reader) error {
+			log.Println("Using", body.Destination.Type, "as destination.")
+			name, info, err := uploadStream(ctx, body, filename, reader)
+			if err != nil {
+				return errorlog.LogError("Uploading to "+body.Destination.Type+" failed due to '", err.Error(), "'")
+			}
+			setUploadResult(response, name, info)
+			return nil
+		}, backupParams...))
+	}
+	stages = append(stages,
+		pipeline.ScriptStage(NameBackupCleanup, true, true, getTimeout(NameBackupCleanup), body.Backup.Database, body.Id),
+		pipeline.ScriptStage(NamePostBackupUnlock, true, true, getTimeout(NamePostBackupUnlock), body.Backup.Database),
+	)
+	stages = pipeline.AddCustomStages(stages, "backup", getTimeout, body.Backup.Database, body.Id)
+
+	return &pipeline.Pipeline{
+		Stages: stages,
+		OnStageStarted: func(stage pipeline.Stage) {
+			response.State = stage.Name
+			response.Stages = httpBodies.SetStageResult(response.Stages, pipeline.GetRunningStageResult(stage.Name))
+			jobs.UpdateBackupJob(body.Id, response)
+		},
+		OnStageFinished: func(result pipeline.Result) {
+			response.Stages = httpBodies.SetStageResult(response.Stages, pipeline.GetStageResult(result))
+			jobs.UpdateBackupJob(body.Id, response)
+		},
+	}
+}
+
+// getResponseV1 returns the job in the shape of the v1 api. Its log fields are filled from the stages of the job,
+// so the logs are only stored once, and the upload is reported as part of the backup stage like before it had its own stage.
+func getResponseV1(job *httpBodies.BackupResponse) interface{} {
+	response := *job
+	for _, stage := range job.Stages {
+		switch stage.Name {
+		case NamePreBackupLock:
+			response.PreBackupLockLog, response.PreBackupLockErrorLog = stage.Stdout, stage.Stderr
+		case NamePreBackupCheck:
+			response.PreBackupCheckLog, response.PreBackupCheckErrorLog = stage.Stdout, stage.Stderr
+		case NameBackup:
+			response.BackupLog, response.BackupErrorLog = stage.Stdout, stage.Stderr
+		case NameBackupCleanup:
+			response.BackupCleanupLog, response.BackupCleanupErrorLog = stage.Stdout, stage.Stderr
+		case NamePostBackupUnlock:
+			response.PostBackupUnlockLog, response.PostBackupUnlockErrorLog = stage.Stdout, stage.Stderr
+		}
+	}
+	if response.State == NameUpload {
+		response.State = NameBackup
+	}
+	return httpBodies.GetBackupResponseV1(&response)
+}
+
+// getScriptParams returns the parameters of the backup script. The agent encrypts the backup itself, if the request contains an algorithm,
+// so the key is only passed to the script otherwise, as every user of the machine can see it in the process list.
+func getScriptParams(body httpBodies.BackupBody, filename string) []string {
+	var scriptEncryptionKey = body.Encryption_key
+	if body.Encryption_algorithm != "" {
+		scriptEncryptionKey = ""
+	}
+	return []string{body.Backup.Host, body.Backup.Username, body.Backup.Password, body.Backup.Database, filename, body.Id, strconv.FormatBool(body.Compression), scriptEncryptionKey}
+}
+
+// setUploadResult adds the name and the information of the uploaded object to the response.
+func setUploadResult(response *httpBodies.BackupResponse, fileName string, info storage.ObjectInfo) {
+	response.FileName = fileName
+	response.FileSize = httpBodies.FileSize{Size: info.Size, Unit: "byte"}
+	response.Version = info.Version
+	if info.Lock != nil {
+		response.ObjectLock = &httpBodies.ObjectLock{Mode: info.Lock.Mode, LegalHold: info.Lock.LegalHold}
+		if !info.Lock.RetainUntil.IsZero() {
+			response.ObjectLock.RetainUntil = timeutil.GetTimestamp(&info.Lock.RetainUntil)
+		}
+	}
+}
+
+func upload(ctx context.Context, body httpBodies.BackupBody, uploadType string) (string, storage.ObjectInfo, error) {
 	var fileName = GetBackupFilename(body.Backup.Host, body.Backup.Database)
 	var backupDirectory = configuration.GetBackupDirectory() + "/" + body.Id
 
 	// Get the first file in the directory
 	fileName, err := shell.GetCompleteFileName(backupDirectory, "")
 	if err != nil {
-		return fileName, 0, errorlog.LogError("Getting path to backup file failed due to '", err.Error(), "'")
+		return fileName, storage.ObjectInfo{}, errorlog.LogError("Getting path to backup file failed due to '", err.Error(), "'")
 	}
 
 	path := backupDirectory + "/" + fileName
 	log.Println("Using file at", path)
 	size, err := shell.GetFileSize(path)
 	if err != nil {
-		return fileName, 0, errorlog.LogError("Reading file size failed due to '", err.Error(), "'")
+		return fileName, storage.ObjectInfo{}, errorlog.LogError("Reading file size failed due to '", err.Error(), "'")
 	}
 
-	if uploadType == "S3" {
-		log.Println("Using S3 as destination.")
-		err = s3.UploadFile(fileName, path, body)
-	} else {
-		log.Println("Using swift as destination.")
-		err = swift.UploadFile(fileName, path, body)
+	if err = ctx.Err(); err != nil {
+		return fileName, storage.ObjectInfo{Size: size}, err
+	}
+
+	log.Println("Using", uploadType, "as destination.")
+	if body.Compression_algorithm != "" || body.Encryption_algorithm != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return fileName, storage.ObjectInfo{}, errorlog.LogError("Failed to open file ", path, " due to '", err.Error(), "'")
+		}
+		defer file.Close()
+		return uploadStream(ctx, body, fileName, file)
+	}
+	info, err := storage.UploadFile(ctx, body.Destination, fileName, path)
+	// Not every backend reports the size of the stored object
+	if info.Size <= 0 {
+		info.Size = size
 	}
+	return fileName, info, err
+}
 
-	return fileName, size, err
+// uploadStream uploads the data of the reader. If the request asks for compression or encryption, the data is compressed
+// and then encrypted while it is uploaded, the name gets the extensions of the algorithms and the algorithms are recorded
+// in the metadata of the object. It returns the name of the stored object.
+func uploadStream(ctx context.Context, body httpBodies.BackupBody, name string, reader io.Reader) (string, storage.ObjectInfo, error) {
+	var destination = body.Destination
+	if body.Compression_algorithm != "" {
+		log.Println("Compressing the backup with", body.Compression_algorithm)
+		compressed := compression.NewCompressingReader(reader, body.Compression_algorithm, body.Compression_level)
+		defer compressed.Close()
+		reader = compressed
+		name += compression.GetFileExtension(body.Compression_algorithm)
+		destination.Metadata = storage.AddToMetadata(destination.Metadata, compression.MetadataKey, body.Compression_algorithm)
+	}
+	if body.Encryption_algorithm != "" {
+		log.Println("Encrypting the backup with", body.Encryption_algorithm)
+		encrypted := encryption.NewEncryptingReader(reader, body.Encryption_key, body.Encryption_public_key)
+		defer encrypted.Close()
+		reader = encrypted
+		name += encryption.FileExtension
+		destination.Metadata = storage.AddToMetadata(destination.Metadata, encryption.MetadataKey, body.Encryption_algorithm)
+	}
+	info, err := storage.UploadStream(ctx, destination, name, reader)
+	return name, info, err
 }
 
 // GetBackupPathWithoutType returns a string holding the path to the backup file without file type.
@@ -0,0 +1,128 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/evoila/osb-backup-agent/errorlog"
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/klauspost/compress/zstd"
+)
+
+// MetadataKey : Key of the object metadata that records the algorithm the agent compressed the backup with
+const MetadataKey = "compression"
+
+// Magic bytes at the start of the data of the algorithms
+var gzipMagic = []byte{0x1f, 0x8b}
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// Detect returns the algorithm the data starting with the header was compressed with, judging by its magic bytes,
+// or an empty string if the data was not compressed with a supported algorithm. The header needs at least the first 4 bytes.
+func Detect(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return httpBodies.Compression_gzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return httpBodies.Compression_zstd
+	}
+	return ""
+}
+
+// GetFileExtension returns the extension the agent appends to the name of a backup compressed with the given algorithm.
+func GetFileExtension(algorithm string) string {
+	switch algorithm {
+	case httpBodies.Compression_gzip:
+		return ".gz"
+	case httpBodies.Compression_zstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// NewCompressingReader returns a reader, which reads the data of the given reader compressed with the algorithm.
+// A level of 0 uses the default level of the algorithm. The returned reader has to be closed, once it is not read anymore.
+func NewCompressingReader(reader io.Reader, algorithm string, level int) io.ReadCloser {
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		compressor, err := newCompressor(pipeWriter, algorithm, level)
+		if err == nil {
+			_, err = io.Copy(compressor, reader)
+			if closeErr := compressor.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+	return pipeReader
+}
+
+// NewDecompressingWriter returns a writer, which decompresses the data written to it with the algorithm and writes the result into the given writer.
+// Close returns an error if the data was not complete or not compressed with the algorithm.
+func NewDecompressingWriter(writer io.Writer, algorithm string) io.WriteCloser {
+	pipeReader, pipeWriter := io.Pipe()
+	w := &decompressingWriter{pipe: pipeWriter, done: make(chan error, 1)}
+	go func() {
+		err := decompress(writer, pipeReader, algorithm)
+		// Unblocks the writer, if the decompression stopped before the end of the data
+		pipeReader.CloseWithError(err)
+		w.done <- err
+	}()
+	return w
+}
+
+type decompressingWriter struct {
+	pipe *io.PipeWriter
+	done chan error
+}
+
+func (w *decompressingWriter) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+func (w *decompressingWriter) Close() error {
+	w.pipe.Close()
+	return <-w.done
+}
+
+func newCompressor(writer io.Writer, algorithm string, level int) (io.WriteCloser, error) {
+	switch algorithm {
+	case httpBodies.Compression_gzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(writer, level)
+	case httpBodies.Compression_zstd:
+		if level == 0 {
+			return zstd.NewWriter(writer)
+		}
+		return zstd.NewWriter(writer, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	return nil, errorlog.LogError("Unsupported compression algorithm ", algorithm)
+}
+
+func decompress(writer io.Writer, reader io.Reader, algorithm string) error {
+	switch algorithm {
+	case httpBodies.Compression_gzip:
+		decompressor, err := gzip.NewReader(reader)
+		if err != nil {
+			return errorlog.LogError("Failed to decompress the backup with gzip due to '", err.Error(), "'")
+		}
+		defer decompressor.Close()
+		if _, err = io.Copy(writer, decompressor); err != nil {
+			return errorlog.LogError("Failed to decompress the backup with gzip due to '", err.Error(), "'")
+		}
+		return nil
+	case httpBodies.Compression_zstd:
+		decompressor, err := zstd.NewReader(reader)
+		if err != nil {
+			return errorlog.LogError("Failed to decompress the backup with zstd due to '", err.Error(), "'")
+		}
+		defer decompressor.Close()
+		if _, err = io.Copy(writer, decompressor); err != nil {
+			return errorlog.LogError("Failed to decompress the backup with zstd due to '", err.Error(), "'")
+		}
+		return nil
+	}
+	return errorlog.LogError("Unsupported compression algorithm ", algorithm)
+}
@@ -0,0 +1,157 @@
+package compression
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/evoila/osb-backup-agent/httpBodies"
+)
+
+// getTestData returns compressible data, which still differs from line to line.
+func getTestData() []byte {
+	var data bytes.Buffer
+	for i := 0; data.Len() < 256*1024; i++ {
+		data.WriteString("INSERT INTO backups VALUES (")
+		data.WriteString(strings.Repeat("x", i%17))
+		data.WriteString(");\n")
+	}
+	return data.Bytes()
+}
+
+func compress(t *testing.T, data []byte, algorithm string, level int) []byte {
+	reader := NewCompressingReader(bytes.NewReader(data), algorithm, level)
+	defer reader.Close()
+	compressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Compressing with %s at level %d failed: %v", algorithm, level, err)
+	}
+	return compressed
+}
+
+func decompressAll(data []byte, algorithm string) ([]byte, error) {
+	var decompressed bytes.Buffer
+	writer := NewDecompressingWriter(&decompressed, algorithm)
+	_, err := writer.Write(data)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	return decompressed.Bytes(), err
+}
+
+func TestRoundTripAtAllowedLevels(t *testing.T) {
+	data := getTestData()
+	for _, algorithm := range []string{httpBodies.Compression_gzip, httpBodies.Compression_zstd} {
+		for level := -1; level <= 23; level++ {
+			if !httpBodies.IsValidCompression(algorithm, level) {
+				continue
+			}
+			compressed := compress(t, data, algorithm, level)
+			if len(compressed) >= len(data) {
+				t.Errorf("%s at level %d did not compress the data: %d bytes", algorithm, level, len(compressed))
+			}
+			decompressed, err := decompressAll(compressed, algorithm)
+			if err != nil {
+				t.Errorf("Decompressing %s at level %d failed: %v", algorithm, level, err)
+			} else if !bytes.Equal(decompressed, data) {
+				t.Errorf("The data changed during the round trip of %s at level %d", algorithm, level)
+			}
+		}
+	}
+}
+
+func TestLevelsOutsideTheRangeAreInvalid(t *testing.T) {
+	invalid := map[string][]int{httpBodies.Compression_gzip: {-1, 10}, httpBodies.Compression_zstd: {-1, 23}, "": {1}, "lz4": {0}}
+	for algorithm, levels := range invalid {
+		for _, level := range levels {
+			if httpBodies.IsValidCompression(algorithm, level) {
+				t.Errorf("The level %d of %q is accepted", level, algorithm)
+			}
+		}
+	}
+}
+
+func TestRoundTripOfEmptyData(t *testing.T) {
+	for _, algorithm := range []string{httpBodies.Compression_gzip, httpBodies.Compression_zstd} {
+		decompressed, err := decompressAll(compress(t, nil, algorithm, 0), algorithm)
+		if err != nil || len(decompressed) != 0 {
+			t.Errorf("The round trip of empty data with %s failed: %d bytes, %v", algorithm, len(decompressed), err)
+		}
+	}
+}
+
+func TestTruncatedDataIsRejected(t *testing.T) {
+	data := getTestData()
+	for _, algorithm := range []string{httpBodies.Compression_gzip, httpBodies.Compression_zstd} {
+		compressed := compress(t, data, algorithm, 0)
+		if _, err := decompressAll(compressed[:len(compressed)-10], algorithm); err == nil {
+			t.Errorf("The truncated %s data was accepted", algorithm)
+		}
+	}
+}
+
+func TestCorruptedDataIsRejected(t *testing.T) {
+	data := getTestData()
+	for _, algorithm := range []string{httpBodies.Compression_gzip, httpBodies.Compression_zstd} {
+		compressed := compress(t, data, algorithm, 0)
+		compressed[len(compressed)/2] ^= 0xff
+		// Both formats contain a checksum of the data
+		if _, err := decompressAll(compressed, algorithm); err == nil {
+			t.Errorf("The corrupted %s data was accepted", algorithm)
+		}
+	}
+}
+
+func TestDataOfAnotherAlgorithmIsRejected(t *testing.T) {
+	compressed := compress(t, getTestData(), httpBodies.Compression_gzip, 0)
+	if _, err := decompressAll(compressed, httpBodies.Compression_zstd); err == nil {
+		t.Error("The gzip data was accepted by zstd")
+	}
+}
+
+func TestWriterDoesNotBlockAfterAFailure(t *testing.T) {
+	writer := NewDecompressingWriter(ioutil.Discard, httpBodies.Compression_gzip)
+	// The decompression stops at the header, the remaining data must not block the writer
+	garbage := bytes.Repeat([]byte("not gzip"), 128*1024)
+	var err error
+	for i := 0; i < 4 && err == nil; i++ {
+		_, err = writer.Write(garbage)
+	}
+	if err == nil {
+		t.Error("Writing the data succeeded although the decompression failed")
+	}
+	if err = writer.Close(); err == nil {
+		t.Error("Close did not report the failed decompression")
+	}
+}
+
+func TestUnsupportedAlgorithm(t *testing.T) {
+	reader := NewCompressingReader(strings.NewReader("data"), "lz4", 0)
+	defer reader.Close()
+	if _, err := ioutil.ReadAll(reader); err == nil {
+		t.Error("Compressing with an unsupported algorithm succeeded")
+	}
+	if _, err := decompressAll([]byte("data"), "lz4"); err == nil {
+		t.Error("Decompressing with an unsupported algorithm succeeded")
+	}
+}
+
+func TestFileExtension(t *testing.T) {
+	if GetFileExtension(httpBodies.Compression_gzip) != ".gz" || GetFileExtension(httpBodies.Compression_zstd) != ".zst" || GetFileExtension("") != "" {
+		t.Error("Unexpected file extensions")
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for _, algorithm := range []string{httpBodies.Compression_gzip, httpBodies.Compression_zstd} {
+		if detected := Detect(compress(t, getTestData(), algorithm, 0)[:4]); detected != algorithm {
+			t.Errorf("Expected to detect %s from the start of the data, got %q", algorithm, detected)
+		}
+	}
+	for _, header := range []string{"", "\x1f", "INSERT INTO", "OSBAENC1"} {
+		if detected := Detect([]byte(header)); detected != "" {
+			t.Errorf("Detected %s in the uncompressed data %q", detected, header)
+		}
+	}
+}
@@ -1,10 +1,15 @@
 package configuration
 
 import (
+	"encoding/json"
 	"errors"
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/mutex"
 )
 
 func GetUsername() string {
@@ -37,6 +42,18 @@ func GetRestoreDirectory() string {
 	return getStringEnvVariable("directory_restore")
 }
 
+// GetLocalDestinationDirectory returns the base directory of the LOCAL destination type, for example a mounted NFS share.
+// The LOCAL destination type can not be used if it is not set.
+func GetLocalDestinationDirectory() string {
+	return os.Getenv("directory_local_destination")
+}
+
+// GetGCSCredentialsFile returns the path of a service account json file, which the GCS destination type uses
+// if a request does not contain credentials itself.
+func GetGCSCredentialsFile() string {
+	return os.Getenv("gcs_credentials_file")
+}
+
 func IsAllowedToDeleteFiles() bool {
 	stringedValue := getStringEnvVariableWithDefault("allowed_to_delete_files", "false")
 	value, err := parseBool(stringedValue)
@@ -57,6 +74,167 @@ func GetMaxJobNumber() int {
 	return value
 }
 
+// GetJobStoreType returns the type of store that holds the jobs: "memory" or "file".
+func GetJobStoreType() string {
+	return getStringEnvVariableWithDefault("job_store", "memory")
+}
+
+// GetJobDirectory returns the directory used by the file based job store.
+func GetJobDirectory() string {
+	return getStringEnvVariableWithDefault("directory_jobs", "/var/vcap/store/backup-agent/jobs")
+}
+
+// RecoveryPolicyNone : Jobs that were running when the agent stopped are left untouched
+const RecoveryPolicyNone = "none"
+
+// RecoveryPolicyMarkFailed : Jobs that were running when the agent stopped are marked as failed
+const RecoveryPolicyMarkFailed = "mark-failed"
+
+// RecoveryPolicyMarkFailedAndUnlock : Jobs that were running when the agent stopped are marked as failed and their cleanup and unlock scripts are run
+const RecoveryPolicyMarkFailedAndUnlock = "mark-failed-and-unlock"
+
+// GetRecoveryPolicy returns how the agent treats jobs on startup that were still running when it stopped.
+func GetRecoveryPolicy() string {
+	value := getStringEnvVariableWithDefault("recovery_policy", RecoveryPolicyMarkFailed)
+	if value != RecoveryPolicyNone && value != RecoveryPolicyMarkFailed && value != RecoveryPolicyMarkFailedAndUnlock {
+		log.Println("[ERROR]", "Recovery policy '", value, "' is not supported -> setting to default '", RecoveryPolicyMarkFailed, "'")
+		value = RecoveryPolicyMarkFailed
+	}
+	return value
+}
+
+// GetJobTimeout returns the maximum duration of a whole backup or restore job. A value of 0 disables the timeout.
+func GetJobTimeout() time.Duration {
+	stringedValue := getStringEnvVariableWithDefault("job_timeout", "0")
+	value, err := time.ParseDuration(stringedValue)
+	if err != nil || value < 0 {
+		log.Println("[ERROR]", "Could not parse '", stringedValue, "' or the value is smaller than 0 -> setting to default '0'")
+		value = 0
+	}
+	return value
+}
+
+// GetStageTimeouts returns the maximum duration per stage, configured as a comma separated list like "backup=2h,pre-backup-lock=5m".
+// Stages without an entry have no timeout.
+func GetStageTimeouts() map[string]time.Duration {
+	timeouts := make(map[string]time.Duration)
+	// Read for every stage of every job, so a missing value is not logged as an error
+	stringedValue := os.Getenv("stage_timeouts")
+	if stringedValue == "" {
+		return timeouts
+	}
+
+	for _, entry := range strings.Split(stringedValue, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 {
+			log.Println("[ERROR]", "Could not parse stage timeout '", entry, "' -> ignoring it")
+			continue
+		}
+		value, err := time.ParseDuration(parts[1])
+		if err != nil || value < 0 {
+			log.Println("[ERROR]", "Could not parse stage timeout '", entry, "' or the value is smaller than 0 -> ignoring it")
+			continue
+		}
+		timeouts[parts[0]] = value
+	}
+	return timeouts
+}
+
+// GetJobRetentionTime returns how long finished jobs are kept before they are evicted. A value of 0 keeps them forever.
+func GetJobRetentionTime() time.Duration {
+	stringedValue := getStringEnvVariableWithDefault("job_retention_time", "0")
+	value, err := time.ParseDuration(stringedValue)
+	if err != nil || value < 0 {
+		log.Println("[ERROR]", "Could not parse '", stringedValue, "' or the value is smaller than 0 -> setting to default '0'")
+		value = 0
+	}
+	return value
+}
+
+// GetJobRetentionCount returns how many finished jobs are kept per job type. A value of 0 keeps all of them.
+func GetJobRetentionCount() int {
+	stringedValue := getStringEnvVariableWithDefault("job_retention_count", "0")
+	value := parseInt(stringedValue)
+	if value < 0 {
+		log.Println("[ERROR]", "Could not parse '", stringedValue, "' or the value is smaller than 0 -> setting to default '0'")
+		value = 0
+	}
+	return value
+}
+
+// GetJobEvictionInterval returns how often the agent looks for finished jobs to evict.
+func GetJobEvictionInterval() time.Duration {
+	stringedValue := getStringEnvVariableWithDefault("job_eviction_interval", "10m")
+	value, err := time.ParseDuration(stringedValue)
+	if err != nil || value <= 0 {
+		log.Println("[ERROR]", "Could not parse '", stringedValue, "' or the value is not greater than 0 -> setting to default '10m'")
+		value = 10 * time.Minute
+	}
+	return value
+}
+
+// CustomStage describes an additional script stage of a backup or restore job.
+type CustomStage struct {
+	// Job is the type of job the stage belongs to: "backup" or "restore"
+	Job  string `json:"job"`
+	Name string `json:"name"`
+	// After is the name of the stage after which the custom stage runs. If empty, it runs before the cleanup and unlock stages.
+	After     string `json:"after"`
+	Optional  bool   `json:"optional"`
+	AlwaysRun bool   `json:"always_run"`
+	Timeout   string `json:"timeout"`
+	// TimeoutDuration is the parsed Timeout. It is 0, if no or an invalid timeout is configured.
+	TimeoutDuration time.Duration `json:"-"`
+}
+
+var customStagesValue string
+var customStages []CustomStage
+var customStagesMutex = newReleasedMutex()
+
+func newReleasedMutex() mutex.Mutex {
+	m := make(mutex.Mutex, 1)
+	m.Release()
+	return m
+}
+
+// GetCustomStages returns the additional script stages configured as a json array in custom_stages.
+// The stages are needed for every job, so the value is only parsed and validated again, if it changed.
+func GetCustomStages() []CustomStage {
+	stringedValue := os.Getenv("custom_stages")
+
+	customStagesMutex.Acquire()
+	defer customStagesMutex.Release()
+	if customStages == nil || stringedValue != customStagesValue {
+		customStagesValue = stringedValue
+		customStages = parseCustomStages(stringedValue)
+	}
+	return customStages
+}
+
+func parseCustomStages(stringedValue string) []CustomStage {
+	stages := []CustomStage{}
+	if stringedValue == "" {
+		return stages
+	}
+
+	if err := json.Unmarshal([]byte(stringedValue), &stages); err != nil {
+		log.Println("[ERROR]", "Could not parse '", stringedValue, "' -> using no custom stages")
+		return []CustomStage{}
+	}
+	for i, stage := range stages {
+		if stage.Timeout == "" {
+			continue
+		}
+		value, err := time.ParseDuration(stage.Timeout)
+		if err != nil || value < 0 {
+			log.Println("[ERROR]", "Could not parse the timeout '", stage.Timeout, "' of custom stage", stage.Name, "or the value is smaller than 0 -> using no timeout")
+			continue
+		}
+		stages[i].TimeoutDuration = value
+	}
+	return stages
+}
+
 func getStringEnvVariable(variable string) string {
 	var output = os.Getenv(variable)
 	if output == "" {
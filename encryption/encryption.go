@@ -0,0 +1,263 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+
+	"github.com/evoila/osb-backup-agent/errorlog"
+	"golang.org/x/crypto/scrypt"
+)
+
+// MetadataKey : Key of the object metadata that records the algorithm the agent encrypted the backup with
+const MetadataKey = "encryption"
+
+// FileExtension : Extension the agent appends to the name of a backup it encrypted
+const FileExtension = ".enc"
+
+// chunkSize : Size of the plaintext of a chunk, every chunk is encrypted and authenticated on its own
+const chunkSize = 64 * 1024
+
+// magic : Start of every backup the agent encrypted, followed by the key type
+var magic = []byte("OSBAENC1")
+
+// Key types of the header. The key of a passphrase is derived from the encryption key of the request with scrypt,
+// the public key types wrap a random data key for the recipient, see recipient.go
+const keyTypePassphrase = 1
+const keyTypeX25519 = 2
+const keyTypeRSA = 3
+
+// keySize : Size of the AES-256 key the chunks are encrypted with
+const keySize = 32
+
+// damagedHeaderMessage : Error of data without a valid header
+const damagedHeaderMessage = "The backup was not encrypted by the agent or its header is damaged"
+
+// saltSize : Size of the random salt of the key derivation
+const saltSize = 16
+
+// Parameters of the key derivation, see https://pkg.go.dev/golang.org/x/crypto/scrypt
+const scryptN = 32768
+const scryptR = 8
+const scryptP = 1
+
+// NewEncryptingReader returns a reader, which reads the data of the given reader encrypted with AES-256-GCM.
+// If publicKey is empty, the key is derived from the passphrase and a random salt, which is stored in the header of the encrypted data.
+// Otherwise a random data key is used, which is stored in the header encrypted for the PEM encoded X25519 or RSA public key,
+// so only the owner of the private key can decrypt the data.
+// The data is split into chunks, whose nonces contain their index and mark the last chunk, so reordered, removed or
+// truncated chunks are detected. The returned reader has to be closed, once it is not read anymore.
+func NewEncryptingReader(reader io.Reader, passphrase string, publicKey string) io.ReadCloser {
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		pipeWriter.CloseWithError(encrypt(pipeWriter, reader, passphrase, publicKey))
+	}()
+	return pipeReader
+}
+
+// IsEncrypted returns true if the data starting with the header was encrypted by the agent. The header needs at least the first 8 bytes.
+func IsEncrypted(header []byte) bool {
+	return bytes.HasPrefix(header, magic)
+}
+
+// NewDecryptingWriter returns a writer, which decrypts the data written to it and writes the result into the given writer.
+// The key is the passphrase or, if the data was encrypted for a public key, the PEM encoded private key.
+// Only authenticated chunks are written. Close returns an error if the data was not complete, was modified or the key is wrong.
+func NewDecryptingWriter(writer io.Writer, key string) io.WriteCloser {
+	pipeReader, pipeWriter := io.Pipe()
+	w := &decryptingWriter{pipe: pipeWriter, done: make(chan error, 1)}
+	go func() {
+		err := decrypt(writer, pipeReader, key)
+		// Unblocks the writer, if the decryption stopped before the end of the data
+		pipeReader.CloseWithError(err)
+		w.done <- err
+	}()
+	return w
+}
+
+type decryptingWriter struct {
+	pipe *io.PipeWriter
+	done chan error
+}
+
+func (w *decryptingWriter) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+func (w *decryptingWriter) Close() error {
+	w.pipe.Close()
+	return <-w.done
+}
+
+func encrypt(writer io.Writer, reader io.Reader, passphrase string, publicKey string) error {
+	header, key, err := newHeader(passphrase, publicKey)
+	if err != nil {
+		return err
+	}
+	aead, err := newCipher(key)
+	if err != nil {
+		return err
+	}
+	if _, err = writer.Write(header); err != nil {
+		return err
+	}
+
+	buffered := bufio.NewReader(reader)
+	plaintext := make([]byte, chunkSize)
+	ciphertext := make([]byte, 0, chunkSize+aead.Overhead())
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(buffered, plaintext)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+		// A full chunk is the last one if nothing follows it
+		if !last {
+			if _, err = buffered.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		ciphertext = aead.Seal(ciphertext[:0], getNonce(index, last), plaintext[:n], header)
+		if _, err = writer.Write(ciphertext); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+func decrypt(writer io.Writer, reader io.Reader, key string) error {
+	buffered := bufio.NewReader(reader)
+	header, dataKey, err := readHeader(buffered, key)
+	if err != nil {
+		return err
+	}
+	aead, err := newCipher(dataKey)
+	if err != nil {
+		return err
+	}
+
+	ciphertext := make([]byte, chunkSize+aead.Overhead())
+	plaintext := make([]byte, 0, chunkSize)
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(buffered, ciphertext)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+		if !last {
+			if _, err = buffered.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		plaintext, err = aead.Open(plaintext[:0], getNonce(index, last), ciphertext[:n], header)
+		if err != nil {
+			return errorlog.LogError("Failed to decrypt the backup, either the encryption key is wrong or the backup was modified or truncated")
+		}
+		if _, err = writer.Write(plaintext); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// newHeader returns the header of newly encrypted data and the key its chunks are encrypted with.
+func newHeader(passphrase string, publicKey string) ([]byte, []byte, error) {
+	header := append([]byte{}, magic...)
+	if publicKey == "" {
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, nil, errorlog.LogError("Failed to create the salt of the encryption due to '", err.Error(), "'")
+		}
+		key, err := deriveKey(passphrase, salt)
+		return append(append(header, keyTypePassphrase), salt...), key, err
+	}
+
+	recipient, err := parsePublicKey(publicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := make([]byte, keySize)
+	if _, err = rand.Read(key); err != nil {
+		return nil, nil, errorlog.LogError("Failed to create the data key of the encryption due to '", err.Error(), "'")
+	}
+	wrappedKey, err := recipient.wrap(key)
+	if err != nil {
+		return nil, nil, errorlog.LogError("Failed to encrypt the data key for the public key due to '", err.Error(), "'")
+	}
+	return append(append(header, recipient.keyType()), wrappedKey...), key, nil
+}
+
+// readHeader reads the header of encrypted data and returns it together with the key its chunks are encrypted with.
+func readHeader(reader io.Reader, key string) ([]byte, []byte, error) {
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(reader, header); err != nil || !bytes.Equal(header[:len(magic)], magic) {
+		return nil, nil, errorlog.LogError(damagedHeaderMessage)
+	}
+
+	var keyType = header[len(magic)]
+	if keyType == keyTypePassphrase {
+		salt := make([]byte, saltSize)
+		if _, err := io.ReadFull(reader, salt); err != nil {
+			return nil, nil, errorlog.LogError(damagedHeaderMessage)
+		}
+		dataKey, err := deriveKey(key, salt)
+		return append(header, salt...), dataKey, err
+	}
+
+	wrappedKey, err := readWrappedKey(reader, keyType)
+	if err != nil {
+		return nil, nil, errorlog.LogError(damagedHeaderMessage)
+	}
+	identity, err := parsePrivateKey(key)
+	if err != nil {
+		return nil, nil, errorlog.LogError("The backup was encrypted for a public key, but the encryption key is not a valid private key: ", err.Error())
+	}
+	if identity.keyType() != keyType {
+		return nil, nil, errorlog.LogError("The backup was encrypted for a public key of another type than the private key of the encryption key")
+	}
+	dataKey, err := identity.unwrap(wrappedKey)
+	if err != nil {
+		return nil, nil, errorlog.LogError("Failed to decrypt the data key of the backup, the encryption key is not the private key the backup was encrypted for")
+	}
+	return append(header, wrappedKey...), dataKey, nil
+}
+
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keySize)
+	if err != nil {
+		return nil, errorlog.LogError("Failed to derive the key of the encryption due to '", err.Error(), "'")
+	}
+	return key, nil
+}
+
+func newCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// getNonce returns the nonce of the chunk with the given index. The last byte marks the last chunk.
+func getNonce(index uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
@@ -0,0 +1,169 @@
+package encryption
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const testPassphrase = "correct horse battery staple"
+
+// passphraseHeaderSize : Size of the magic, the key type and the salt
+const passphraseHeaderSize = 8 + 1 + saltSize
+
+// chunkCiphertextSize : Size of an encrypted full chunk with its tag
+const chunkCiphertextSize = chunkSize + 16
+
+func getTestData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7 % 251)
+	}
+	return data
+}
+
+func encryptAll(t *testing.T, data []byte, passphrase, publicKey string) []byte {
+	reader := NewEncryptingReader(bytes.NewReader(data), passphrase, publicKey)
+	defer reader.Close()
+	encrypted, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	return encrypted
+}
+
+func decryptAll(data []byte, key string) ([]byte, error) {
+	var decrypted bytes.Buffer
+	writer := NewDecryptingWriter(&decrypted, key)
+	_, err := writer.Write(data)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	return decrypted.Bytes(), err
+}
+
+func assertRejected(t *testing.T, encrypted []byte, key, description string) {
+	if _, err := decryptAll(encrypted, key); err == nil {
+		t.Errorf("The %s was decrypted", description)
+	}
+}
+
+func TestRoundTripWithPassphrase(t *testing.T) {
+	// Sizes around the chunk boundaries, a full last chunk must still be marked as the last one
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 100} {
+		data := getTestData(size)
+		encrypted := encryptAll(t, data, testPassphrase, "")
+		if !bytes.HasPrefix(encrypted, magic) {
+			t.Errorf("The encrypted data of %d bytes does not start with the magic", size)
+		}
+		if bytes.Contains(encrypted, []byte(testPassphrase)) {
+			t.Errorf("The encrypted data of %d bytes contains the passphrase", size)
+		}
+		decrypted, err := decryptAll(encrypted, testPassphrase)
+		if err != nil {
+			t.Errorf("Decrypting %d bytes failed: %v", size, err)
+		} else if !bytes.Equal(decrypted, data) {
+			t.Errorf("The data of %d bytes changed during the round trip", size)
+		}
+	}
+}
+
+func TestSaltMakesEveryEncryptionDifferent(t *testing.T) {
+	data := getTestData(100)
+	if bytes.Equal(encryptAll(t, data, testPassphrase, ""), encryptAll(t, data, testPassphrase, "")) {
+		t.Error("Encrypting the same data twice returned the same ciphertext")
+	}
+}
+
+func TestWrongPassphraseIsRejected(t *testing.T) {
+	encrypted := encryptAll(t, getTestData(100), testPassphrase, "")
+	assertRejected(t, encrypted, "wrong passphrase", "data with a wrong passphrase")
+}
+
+func TestTamperedDataIsRejected(t *testing.T) {
+	data := getTestData(3*chunkSize + 100)
+	encrypted := encryptAll(t, data, testPassphrase, "")
+
+	tampered := append([]byte{}, encrypted...)
+	tampered[passphraseHeaderSize+chunkCiphertextSize+10] ^= 1
+	decrypted, err := decryptAll(tampered, testPassphrase)
+	if err == nil {
+		t.Fatal("The tampered data was decrypted")
+	}
+	// Only the authenticated chunk in front of the tampered one was written
+	if !bytes.Equal(decrypted, data[:chunkSize]) {
+		t.Errorf("Expected only the first chunk to be written, got %d bytes", len(decrypted))
+	}
+
+	tampered = append([]byte{}, encrypted...)
+	tampered[len(tampered)-1] ^= 1
+	assertRejected(t, tampered, testPassphrase, "data with a tampered tag")
+
+	// The salt is authenticated as part of the header, besides deriving another key
+	tampered = append([]byte{}, encrypted...)
+	tampered[len(magic)+1] ^= 1
+	assertRejected(t, tampered, testPassphrase, "data with a tampered salt")
+}
+
+func TestTruncatedDataIsRejected(t *testing.T) {
+	encrypted := encryptAll(t, getTestData(3*chunkSize+100), testPassphrase, "")
+
+	assertRejected(t, encrypted[:len(encrypted)-1], testPassphrase, "data without its last byte")
+	// Without the last chunk the data ends at a chunk boundary, but the chunk in front is not marked as the last one
+	assertRejected(t, encrypted[:passphraseHeaderSize+3*chunkCiphertextSize], testPassphrase, "data without its last chunk")
+	assertRejected(t, encrypted[:passphraseHeaderSize], testPassphrase, "data without any chunk")
+	assertRejected(t, encrypted[:passphraseHeaderSize-1], testPassphrase, "data with a truncated header")
+}
+
+func TestReorderedChunksAreRejected(t *testing.T) {
+	encrypted := encryptAll(t, getTestData(3*chunkSize+100), testPassphrase, "")
+	header := encrypted[:passphraseHeaderSize]
+	chunks := make([][]byte, 0, 4)
+	for rest := encrypted[passphraseHeaderSize:]; len(rest) > 0; {
+		size := chunkCiphertextSize
+		if len(rest) < size {
+			size = len(rest)
+		}
+		chunks, rest = append(chunks, rest[:size]), rest[size:]
+	}
+
+	swapped := bytes.Join([][]byte{header, chunks[1], chunks[0], chunks[2], chunks[3]}, nil)
+	assertRejected(t, swapped, testPassphrase, "data with swapped chunks")
+	removed := bytes.Join([][]byte{header, chunks[0], chunks[2], chunks[3]}, nil)
+	assertRejected(t, removed, testPassphrase, "data without its second chunk")
+	duplicated := bytes.Join([][]byte{header, chunks[0], chunks[0], chunks[1], chunks[2], chunks[3]}, nil)
+	assertRejected(t, duplicated, testPassphrase, "data with a duplicated chunk")
+}
+
+func TestChunksOfAnotherBackupAreRejected(t *testing.T) {
+	first := encryptAll(t, getTestData(2*chunkSize), testPassphrase, "")
+	second := encryptAll(t, getTestData(2*chunkSize), testPassphrase, "")
+	mixed := append(append([]byte{}, first[:passphraseHeaderSize+chunkCiphertextSize]...), second[passphraseHeaderSize+chunkCiphertextSize:]...)
+	assertRejected(t, mixed, testPassphrase, "data with a chunk of another backup")
+}
+
+func TestDataWithoutHeaderIsRejected(t *testing.T) {
+	_, err := decryptAll([]byte("plain backup data, which the agent did not encrypt"), testPassphrase)
+	if err == nil || !strings.Contains(err.Error(), damagedHeaderMessage) {
+		t.Errorf("Expected the damaged header to be reported, got %v", err)
+	}
+
+	encrypted := encryptAll(t, getTestData(100), testPassphrase, "")
+	encrypted[len(magic)] = 42
+	_, err = decryptAll(encrypted, testPassphrase)
+	if err == nil || !strings.Contains(err.Error(), damagedHeaderMessage) {
+		t.Errorf("Expected the unknown key type to be reported as a damaged header, got %v", err)
+	}
+}
+
+func TestIsEncrypted(t *testing.T) {
+	if !IsEncrypted(encryptAll(t, getTestData(100), testPassphrase, "")[:len(magic)]) {
+		t.Error("The header of the encrypted data was not detected")
+	}
+	for _, header := range []string{"", "OSBAENC", "OSBAENC2", "plain backup data"} {
+		if IsEncrypted([]byte(header)) {
+			t.Errorf("Detected the header in the data %q", header)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// minRSABits : Minimum size of RSA public keys the agent encrypts data keys for
+const minRSABits = 2048
+
+// oidX25519 : Algorithm identifier of X25519 keys in PKIX and PKCS #8, see RFC 8410
+var oidX25519 = asn1.ObjectIdentifier{1, 3, 101, 110}
+
+// wrapLabel : Label of the RSA-OAEP encryption and info of the X25519 key derivation, which bind the wrapped key to this format
+var wrapLabel = []byte("osb-backup-agent data key")
+
+// x25519WrappedKeySize : Size of the ephemeral public key and the encrypted data key with its tag
+const x25519WrappedKeySize = curve25519.PointSize + keySize + 16
+
+// recipient encrypts the data key for the owner of a public key.
+type recipient interface {
+	keyType() byte
+	wrap(dataKey []byte) ([]byte, error)
+}
+
+// identity decrypts a data key that was encrypted for its public key.
+type identity interface {
+	keyType() byte
+	unwrap(wrappedKey []byte) ([]byte, error)
+}
+
+// ValidatePublicKey returns an error if the key is not a PEM encoded X25519 or RSA public key the agent can encrypt backups for.
+func ValidatePublicKey(publicKey string) error {
+	_, err := parsePublicKey(publicKey)
+	return err
+}
+
+// parsePublicKey parses a PEM encoded X25519 public key in PKIX format or an RSA public key in PKIX or PKCS #1 format.
+func parsePublicKey(publicKey string) (recipient, error) {
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil {
+		return nil, errors.New("the public key is not PEM encoded")
+	}
+	// The private key must never be handed to the backups
+	if strings.Contains(block.Type, "PRIVATE") {
+		return nil, errors.New("the public key is a private key")
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newRSARecipient(key)
+	}
+
+	var info struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(block.Bytes, &info); err == nil && info.Algorithm.Algorithm.Equal(oidX25519) {
+		if len(info.PublicKey.Bytes) != curve25519.PointSize {
+			return nil, errors.New("the X25519 public key has an invalid size")
+		}
+		return x25519Recipient{publicKey: info.PublicKey.Bytes}, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, isRSA := key.(*rsa.PublicKey)
+	if !isRSA {
+		return nil, errors.New("only X25519 and RSA public keys are supported")
+	}
+	return newRSARecipient(rsaKey)
+}
+
+// parsePrivateKey parses a PEM encoded X25519 private key in PKCS #8 format or an RSA private key in PKCS #8 or PKCS #1 format.
+func parsePrivateKey(privateKey string) (identity, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return nil, errors.New("the private key is not PEM encoded")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return rsaIdentity{privateKey: key}, nil
+	}
+
+	// Further fields of the PKCS #8 structure are optional and not needed
+	var info struct {
+		Version    int
+		Algorithm  pkix.AlgorithmIdentifier
+		PrivateKey []byte
+	}
+	if _, err := asn1.Unmarshal(block.Bytes, &info); err == nil && info.Algorithm.Algorithm.Equal(oidX25519) {
+		var scalar []byte
+		if _, err = asn1.Unmarshal(info.PrivateKey, &scalar); err != nil || len(scalar) != curve25519.ScalarSize {
+			return nil, errors.New("the X25519 private key is invalid")
+		}
+		return x25519Identity{privateKey: scalar}, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, isRSA := key.(*rsa.PrivateKey)
+	if !isRSA {
+		return nil, errors.New("only X25519 and RSA private keys are supported")
+	}
+	return rsaIdentity{privateKey: rsaKey}, nil
+}
+
+// readWrappedKey reads the wrapped data key of the given key type, which follows the key type in the header.
+func readWrappedKey(reader io.Reader, keyType byte) ([]byte, error) {
+	switch keyType {
+	case keyTypeX25519:
+		wrappedKey := make([]byte, x25519WrappedKeySize)
+		_, err := io.ReadFull(reader, wrappedKey)
+		return wrappedKey, err
+	case keyTypeRSA:
+		// The size of RSA-OAEP ciphertexts depends on the size of the key, so it is stored in front of them
+		wrappedKey := make([]byte, 2)
+		if _, err := io.ReadFull(reader, wrappedKey); err != nil {
+			return nil, err
+		}
+		wrappedKey = append(wrappedKey, make([]byte, binary.BigEndian.Uint16(wrappedKey))...)
+		_, err := io.ReadFull(reader, wrappedKey[2:])
+		return wrappedKey, err
+	}
+	return nil, errors.New("unknown key type")
+}
+
+// x25519Recipient encrypts the data key with a key derived from the X25519 key agreement between an ephemeral key and the public key.
+type x25519Recipient struct {
+	publicKey []byte
+}
+
+func (r x25519Recipient) keyType() byte {
+	return keyTypeX25519
+}
+
+func (r x25519Recipient) wrap(dataKey []byte) ([]byte, error) {
+	ephemeralKey := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeralKey); err != nil {
+		return nil, err
+	}
+	ephemeralPublicKey, err := curve25519.X25519(ephemeralKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	sharedSecret, err := curve25519.X25519(ephemeralKey, r.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newX25519WrapCipher(sharedSecret, ephemeralPublicKey, r.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	// The wrapping key is only used once, so the nonce can be constant
+	return aead.Seal(ephemeralPublicKey, make([]byte, aead.NonceSize()), dataKey, nil), nil
+}
+
+type x25519Identity struct {
+	privateKey []byte
+}
+
+func (i x25519Identity) keyType() byte {
+	return keyTypeX25519
+}
+
+func (i x25519Identity) unwrap(wrappedKey []byte) ([]byte, error) {
+	publicKey, err := curve25519.X25519(i.privateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPublicKey := wrappedKey[:curve25519.PointSize]
+	sharedSecret, err := curve25519.X25519(i.privateKey, ephemeralPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newX25519WrapCipher(sharedSecret, ephemeralPublicKey, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), wrappedKey[curve25519.PointSize:], nil)
+}
+
+// newX25519WrapCipher derives the key the data key is encrypted with from the shared secret and both public keys.
+func newX25519WrapCipher(sharedSecret, ephemeralPublicKey, publicKey []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephemeralPublicKey...), publicKey...)
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sharedSecret, salt, wrapLabel), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// rsaRecipient encrypts the data key with RSA-OAEP and SHA-256.
+type rsaRecipient struct {
+	publicKey *rsa.PublicKey
+}
+
+func newRSARecipient(publicKey *rsa.PublicKey) (recipient, error) {
+	if publicKey.N.BitLen() < minRSABits {
+		return nil, errors.New("RSA public keys need at least 2048 bits")
+	}
+	return rsaRecipient{publicKey: publicKey}, nil
+}
+
+func (r rsaRecipient) keyType() byte {
+	return keyTypeRSA
+}
+
+func (r rsaRecipient) wrap(dataKey []byte) ([]byte, error) {
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, r.publicKey, dataKey, wrapLabel)
+	if err != nil {
+		return nil, err
+	}
+	wrappedKey := make([]byte, 2, 2+len(ciphertext))
+	binary.BigEndian.PutUint16(wrappedKey, uint16(len(ciphertext)))
+	return append(wrappedKey, ciphertext...), nil
+}
+
+type rsaIdentity struct {
+	privateKey *rsa.PrivateKey
+}
+
+func (i rsaIdentity) keyType() byte {
+	return keyTypeRSA
+}
+
+func (i rsaIdentity) unwrap(wrappedKey []byte) ([]byte, error) {
+	return rsa.DecryptOAEP(sha256.New(), rand.Reader, i.privateKey, wrappedKey[2:], wrapLabel)
+}
@@ -0,0 +1,212 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// rsaKeys holds the generated RSA keys, which are shared by the tests as their generation is slow
+var rsaKeys = make(map[int][]*rsa.PrivateKey)
+
+func getRSAKey(t *testing.T, bits int, index int) *rsa.PrivateKey {
+	for len(rsaKeys[bits]) <= index {
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsaKeys[bits] = append(rsaKeys[bits], key)
+	}
+	return rsaKeys[bits][index]
+}
+
+func encodePEM(blockType string, der []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}))
+}
+
+func marshal(t *testing.T, value interface{}) []byte {
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// newX25519Key returns a new X25519 key pair as PEM encoded public key in PKIX format and private key in PKCS #8 format,
+// like "openssl genpkey -algorithm X25519" creates them.
+func newX25519Key(t *testing.T) (string, string) {
+	privateKey := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(privateKey); err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	algorithm := pkix.AlgorithmIdentifier{Algorithm: oidX25519}
+	publicInfo := marshal(t, struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{algorithm, asn1.BitString{Bytes: publicKey, BitLength: 8 * len(publicKey)}})
+	privateInfo := marshal(t, struct {
+		Version    int
+		Algorithm  pkix.AlgorithmIdentifier
+		PrivateKey []byte
+	}{0, algorithm, marshal(t, privateKey)})
+	return encodePEM("PUBLIC KEY", publicInfo), encodePEM("PRIVATE KEY", privateInfo)
+}
+
+// getRSAKeyFormats returns the PEM encoded public key in PKIX and PKCS #1 format and the private key in PKCS #8 and PKCS #1 format.
+func getRSAKeyFormats(t *testing.T, key *rsa.PrivateKey) ([]string, []string) {
+	publicInfo, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []string{encodePEM("PUBLIC KEY", publicInfo), encodePEM("RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&key.PublicKey))},
+		[]string{encodePEM("PRIVATE KEY", pkcs8), encodePEM("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))}
+}
+
+func assertRoundTrip(t *testing.T, publicKey, privateKey string) {
+	data := getTestData(2*chunkSize + 100)
+	encrypted := encryptAll(t, data, "", publicKey)
+	decrypted, err := decryptAll(encrypted, privateKey)
+	if err != nil {
+		t.Errorf("Decrypting with the private key failed: %v", err)
+	} else if !bytes.Equal(decrypted, data) {
+		t.Error("The data changed during the round trip")
+	}
+}
+
+func assertErrorContains(t *testing.T, err error, expected string) {
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected an error containing %q, got %v", expected, err)
+	}
+}
+
+func TestRoundTripWithX25519Key(t *testing.T) {
+	publicKey, privateKey := newX25519Key(t)
+	if err := ValidatePublicKey(publicKey); err != nil {
+		t.Fatalf("The X25519 public key is invalid: %v", err)
+	}
+	assertRoundTrip(t, publicKey, privateKey)
+
+	encrypted := encryptAll(t, getTestData(100), "", publicKey)
+	if encrypted[len(magic)] != keyTypeX25519 {
+		t.Errorf("Expected the key type %d in the header, got %d", keyTypeX25519, encrypted[len(magic)])
+	}
+}
+
+func TestRoundTripWithRSAKeyInAllFormats(t *testing.T) {
+	publicKeys, privateKeys := getRSAKeyFormats(t, getRSAKey(t, 2048, 0))
+	for _, publicKey := range publicKeys {
+		if err := ValidatePublicKey(publicKey); err != nil {
+			t.Fatalf("The RSA public key is invalid: %v", err)
+		}
+		for _, privateKey := range privateKeys {
+			assertRoundTrip(t, publicKey, privateKey)
+		}
+	}
+}
+
+func TestWrongPrivateKeyIsRejected(t *testing.T) {
+	publicKey, _ := newX25519Key(t)
+	_, otherPrivateKey := newX25519Key(t)
+	_, err := decryptAll(encryptAll(t, getTestData(100), "", publicKey), otherPrivateKey)
+	assertErrorContains(t, err, "not the private key the backup was encrypted for")
+
+	publicKeys, _ := getRSAKeyFormats(t, getRSAKey(t, 2048, 0))
+	_, otherPrivateKeys := getRSAKeyFormats(t, getRSAKey(t, 2048, 1))
+	_, err = decryptAll(encryptAll(t, getTestData(100), "", publicKeys[0]), otherPrivateKeys[0])
+	assertErrorContains(t, err, "not the private key the backup was encrypted for")
+}
+
+func TestKeyTypeMismatchIsRejected(t *testing.T) {
+	x25519PublicKey, x25519PrivateKey := newX25519Key(t)
+	rsaPublicKeys, rsaPrivateKeys := getRSAKeyFormats(t, getRSAKey(t, 2048, 0))
+
+	_, err := decryptAll(encryptAll(t, getTestData(100), "", x25519PublicKey), rsaPrivateKeys[0])
+	assertErrorContains(t, err, "another type")
+	_, err = decryptAll(encryptAll(t, getTestData(100), "", rsaPublicKeys[0]), x25519PrivateKey)
+	assertErrorContains(t, err, "another type")
+}
+
+func TestPassphraseDoesNotDecryptBackupsOfPublicKeys(t *testing.T) {
+	publicKey, _ := newX25519Key(t)
+	_, err := decryptAll(encryptAll(t, getTestData(100), testPassphrase, publicKey), testPassphrase)
+	assertErrorContains(t, err, "not a valid private key")
+}
+
+func TestTamperedWrappedKeyIsRejected(t *testing.T) {
+	publicKey, privateKey := newX25519Key(t)
+	encrypted := encryptAll(t, getTestData(100), "", publicKey)
+	encrypted[len(magic)+1+curve25519.PointSize+1] ^= 1
+	assertRejected(t, encrypted, privateKey, "data with a tampered data key")
+
+	publicKeys, privateKeys := getRSAKeyFormats(t, getRSAKey(t, 2048, 0))
+	encrypted = encryptAll(t, getTestData(100), "", publicKeys[0])
+	encrypted[len(magic)+1+2+10] ^= 1
+	assertRejected(t, encrypted, privateKeys[0], "data with a tampered data key")
+}
+
+func TestTamperedChunkOfPublicKeyBackupIsRejected(t *testing.T) {
+	publicKey, privateKey := newX25519Key(t)
+	encrypted := encryptAll(t, getTestData(2*chunkSize), "", publicKey)
+	encrypted[len(encrypted)-chunkCiphertextSize-1] ^= 1
+	assertRejected(t, encrypted, privateKey, "data with a tampered chunk")
+	assertRejected(t, encrypted[:len(encrypted)-chunkCiphertextSize], privateKey, "data without its last chunk")
+}
+
+func TestSmallRSAKeyIsRejected(t *testing.T) {
+	publicKeys, _ := getRSAKeyFormats(t, getRSAKey(t, 1024, 0))
+	for _, publicKey := range publicKeys {
+		assertErrorContains(t, ValidatePublicKey(publicKey), "at least 2048 bits")
+	}
+
+	reader := NewEncryptingReader(bytes.NewReader(getTestData(100)), "", publicKeys[0])
+	defer reader.Close()
+	if _, err := reader.Read(make([]byte, 100)); err == nil {
+		t.Error("The data was encrypted for the small RSA key")
+	}
+}
+
+func TestPrivateKeyAsPublicKeyIsRejected(t *testing.T) {
+	_, x25519PrivateKey := newX25519Key(t)
+	_, rsaPrivateKeys := getRSAKeyFormats(t, getRSAKey(t, 2048, 0))
+	for _, privateKey := range append(rsaPrivateKeys, x25519PrivateKey) {
+		assertErrorContains(t, ValidatePublicKey(privateKey), "is a private key")
+	}
+}
+
+func TestUnsupportedPublicKeysAreRejected(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicInfo, err := x509.MarshalPKIXPublicKey(&ecdsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertErrorContains(t, ValidatePublicKey(encodePEM("PUBLIC KEY", publicInfo)), "only X25519 and RSA")
+	assertErrorContains(t, ValidatePublicKey("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIA"), "not PEM encoded")
+
+	shortKey := marshal(t, struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{pkix.AlgorithmIdentifier{Algorithm: oidX25519}, asn1.BitString{Bytes: make([]byte, 31), BitLength: 8 * 31}})
+	assertErrorContains(t, ValidatePublicKey(encodePEM("PUBLIC KEY", shortKey)), "invalid size")
+}
@@ -0,0 +1,169 @@
+package gcs
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/configuration"
+	"github.com/evoila/osb-backup-agent/errorlog"
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/evoila/osb-backup-agent/mutex"
+)
+
+// scope : OAuth scope of the access tokens, which allows to read and write objects
+const scope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+const defaultTokenURI = "https://oauth2.googleapis.com/token"
+
+// tokenExpiryMargin : Tokens are renewed this long before they expire, so they do not expire during a request
+const tokenExpiryMargin = 5 * time.Minute
+
+// serviceAccount holds the fields of a service account json file, which are needed to request access tokens.
+type serviceAccount struct {
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyId string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+}
+
+type accessToken struct {
+	value  string
+	expiry time.Time
+}
+
+var tokens = make(map[string]accessToken)
+var tokensMutex = newReleasedMutex()
+
+func newReleasedMutex() mutex.Mutex {
+	m := make(mutex.Mutex, 1)
+	m.Release()
+	return m
+}
+
+// getServiceAccount returns the service account of the request, or the one of the agent's configuration.
+// It returns nil if neither is given.
+func getServiceAccount(destination httpBodies.DestinationInformation) (*serviceAccount, error) {
+	data := []byte(destination.Service_account_json)
+	if len(data) == 0 {
+		file := configuration.GetGCSCredentialsFile()
+		if file == "" {
+			return nil, nil
+		}
+		var err error
+		if data, err = ioutil.ReadFile(file); err != nil {
+			return nil, errorlog.LogError("Reading the service account file ", file, " failed due to '", err.Error(), "'")
+		}
+	}
+
+	var account serviceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, errorlog.LogError("Parsing the service account json failed due to '", err.Error(), "'")
+	}
+	if account.ClientEmail == "" || account.PrivateKey == "" {
+		return nil, errorlog.LogError("The service account json misses the client_email or the private_key")
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = defaultTokenURI
+	}
+	return &account, nil
+}
+
+// getAccessToken returns a cached access token of the service account or requests a new one.
+func (account *serviceAccount) getAccessToken(ctx context.Context) (string, error) {
+	cacheKey := account.ClientEmail + "|" + account.PrivateKeyId + "|" + account.TokenURI
+
+	tokensMutex.Acquire()
+	token, exists := tokens[cacheKey]
+	tokensMutex.Release()
+	if exists && time.Now().Add(tokenExpiryMargin).Before(token.expiry) {
+		return token.value, nil
+	}
+
+	token, err := account.requestAccessToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	tokensMutex.Acquire()
+	tokens[cacheKey] = token
+	tokensMutex.Release()
+	return token.value, nil
+}
+
+// requestAccessToken exchanges a signed JWT for an access token.
+// See https://developers.google.com/identity/protocols/oauth2/service-account#authorizingrequests
+func (account *serviceAccount) requestAccessToken(ctx context.Context) (accessToken, error) {
+	assertion, err := account.getSignedJWT(time.Now())
+	if err != nil {
+		return accessToken{}, err
+	}
+
+	form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return accessToken{}, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return accessToken{}, errorlog.LogError("Requesting an access token failed due to '", err.Error(), "'")
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return accessToken{}, errorlog.LogError("Requesting an access token failed due to '", readResponseError(response).Error(), "'")
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return accessToken{}, errorlog.LogError("Parsing the access token failed due to '", err.Error(), "'")
+	}
+	return accessToken{value: result.AccessToken, expiry: time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)}, nil
+}
+
+func (account *serviceAccount) getSignedJWT(now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return "", errorlog.LogError("The private key of the service account is not PEM encoded")
+	}
+	parsedKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		parsedKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, isRSA := parsedKey.(*rsa.PrivateKey)
+	if err != nil || !isRSA {
+		return "", errorlog.LogError("The private key of the service account is not a valid RSA key")
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": account.PrivateKeyId})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   account.ClientEmail,
+		"scope": scope,
+		"aud":   account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", errorlog.LogError("Signing the token request failed due to '", err.Error(), "'")
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,156 @@
+package gcs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/errorlog"
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/evoila/osb-backup-agent/storage"
+)
+
+// DefaultEndpoint : Endpoint of the JSON API used if the destination does not define one
+const DefaultEndpoint = "https://storage.googleapis.com"
+
+var httpClient = &http.Client{}
+
+// client sends authenticated requests to the JSON API for a single bucket.
+type client struct {
+	endpoint string
+	bucket   string
+	// account is nil for unauthenticated requests against an emulator
+	account *serviceAccount
+}
+
+// responseError is returned for responses with an unexpected status code.
+type responseError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *responseError) Error() string {
+	return errorlog.Concat([]string{"gcs responded with status ", strconv.Itoa(e.StatusCode), " ", e.Message}, "")
+}
+
+// object holds the fields of an object resource, which are reported back.
+// See https://cloud.google.com/storage/docs/json_api/v1/objects#resource
+type object struct {
+	Name       string            `json:"name"`
+	Size       string            `json:"size"`
+	Generation string            `json:"generation"`
+	Updated    string            `json:"updated"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+func (o object) toObjectInfo() storage.ObjectInfo {
+	size, _ := strconv.ParseInt(o.Size, 10, 64)
+	updated, _ := time.Parse(time.RFC3339Nano, o.Updated)
+	return storage.ObjectInfo{Name: o.Name, Size: size, LastModified: updated, Version: o.Generation, Metadata: storage.GetMetadataWithLowerCaseKeys(o.Metadata)}
+}
+
+func newClient(destination httpBodies.DestinationInformation) (*client, error) {
+	account, err := getServiceAccount(destination)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil && destination.Endpoint == "" {
+		return nil, errorlog.LogError("Neither the request nor the agent's configuration contain a service account for GCS")
+	}
+
+	endpoint := destination.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	if _, err = url.Parse(endpoint); err != nil {
+		return nil, errorlog.LogError("Parsing the endpoint ", endpoint, " failed due to '", err.Error(), "'")
+	}
+	return &client{endpoint: strings.TrimSuffix(endpoint, "/"), bucket: destination.Bucket, account: account}, nil
+}
+
+// getObjectURL returns the url of the object with the given name, or of the object collection if the name is empty.
+func (c *client) getObjectURL(name string, query url.Values) string {
+	u := c.endpoint + "/storage/v1/b/" + url.PathEscape(c.bucket) + "/o"
+	if name != "" {
+		u += "/" + url.PathEscape(name)
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// getUploadURL returns the url to create the object with the given name with the given upload type.
+func (c *client) getUploadURL(name, uploadType string) string {
+	query := url.Values{"uploadType": {uploadType}, "name": {name}}
+	return c.endpoint + "/upload/storage/v1/b/" + url.PathEscape(c.bucket) + "/o?" + query.Encode()
+}
+
+// do sends the request and returns an error for every status code that is not expected.
+// The body of the returned response has to be closed by the caller.
+func (c *client) do(ctx context.Context, method, rawURL string, header http.Header, body io.Reader, contentLength int64, expectedStatus ...int) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	request.ContentLength = contentLength
+	if c.account != nil {
+		token, err := c.account.getAccessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range expectedStatus {
+		if response.StatusCode == status {
+			return response, nil
+		}
+	}
+	defer response.Body.Close()
+	return nil, readResponseError(response)
+}
+
+// doForObject sends the request and decodes the object resource of the response.
+func (c *client) doForObject(ctx context.Context, method, rawURL string, header http.Header, body io.Reader, contentLength int64) (object, error) {
+	var result object
+	response, err := c.do(ctx, method, rawURL, header, body, contentLength, http.StatusOK, http.StatusCreated)
+	if err != nil {
+		return result, err
+	}
+	defer response.Body.Close()
+	err = json.NewDecoder(response.Body).Decode(&result)
+	return result, err
+}
+
+func readResponseError(response *http.Response) error {
+	var details struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 64*1024))
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &details) == nil {
+		if details.Error.Message != "" {
+			message = details.Error.Message
+		} else if details.ErrorDescription != "" {
+			message = details.ErrorDescription
+		}
+	}
+	return &responseError{StatusCode: response.StatusCode, Message: strings.SplitN(message, "\n", 2)[0]}
+}
@@ -0,0 +1,335 @@
+package gcs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/evoila/osb-backup-agent/configuration"
+	"github.com/evoila/osb-backup-agent/errorlog"
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/evoila/osb-backup-agent/storage"
+)
+
+// Type : Name of the destination type handled by this backend
+const Type = "GCS"
+
+// chunkSize : Size of the chunks of a resumable upload, the service requires a multiple of 256 KiB
+const chunkSize = 64 * 256 * 1024
+
+// maxChunkAttempts : Number of attempts to upload a chunk, later attempts continue at the last byte the service persisted
+const maxChunkAttempts = 3
+
+// statusResumeIncomplete : Status code of the service for a resumable upload that is not complete yet
+const statusResumeIncomplete = 308
+
+// Backend stores backups as objects in a Google Cloud Storage bucket.
+type Backend struct{}
+
+func (b Backend) Validate(destination httpBodies.DestinationInformation, fileCanBeMissing bool) string {
+	missingFields := ""
+	if destination.Bucket == "" {
+		missingFields += " bucket"
+	}
+	if destination.Service_account_json == "" && configuration.GetGCSCredentialsFile() == "" && destination.Endpoint == "" {
+		missingFields += " service_account_json"
+	}
+	if destination.Filename == "" && !fileCanBeMissing {
+		missingFields += " filename"
+	}
+	return missingFields
+}
+
+// Redact returns the fields of the GCS destination with its credentials replaced.
+func (b Backend) Redact(destination httpBodies.DestinationInformation) httpBodies.DestinationInformation {
+	return httpBodies.DestinationInformation{
+		Type:                 destination.Type,
+		Bucket:               destination.Bucket,
+		Filename:             destination.Filename,
+		Service_account_json: httpBodies.GetRedactedOrEmptyPasswordString(destination.Service_account_json),
+		Endpoint:             destination.Endpoint,
+		Metadata:             destination.Metadata,
+	}
+}
+
+// Upload stores small backups with a single request and uses a resumable upload for larger ones,
+// which sends the backup in chunks and retries failed chunks. Backups with metadata always use a resumable upload,
+// as a single request can not contain the metadata.
+func (b Backend) Upload(ctx context.Context, destination httpBodies.DestinationInformation, name string, reader io.Reader, size int64) (storage.ObjectInfo, error) {
+	c, err := newClient(destination)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+
+	log.Println("Uploading", name, "to bucket", destination.Bucket)
+	var result object
+	if size >= 0 && size <= chunkSize && len(destination.Metadata) == 0 {
+		result, err = c.uploadMedia(ctx, name, reader, size)
+	} else {
+		result, err = c.uploadResumable(ctx, name, reader, size, destination.Metadata)
+	}
+	if err != nil {
+		return storage.ObjectInfo{}, errorlog.LogError("Failed to upload to gcs due to '", err.Error(), "'")
+	}
+	log.Printf("Successfully uploaded %q to %q as generation %s\n", name, destination.Bucket, result.Generation)
+
+	return result.toObjectInfo(), nil
+}
+
+func (b Backend) Download(ctx context.Context, destination httpBodies.DestinationInformation, name string, writer io.Writer) (int64, error) {
+	c, err := newClient(destination)
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := c.do(ctx, http.MethodGet, c.getObjectURL(name, url.Values{"alt": {"media"}}), nil, nil, 0, http.StatusOK)
+	if err != nil {
+		return 0, errorlog.LogError("Failed to download the file ", name, " due to '", err.Error(), "'")
+	}
+	defer response.Body.Close()
+
+	written, err := io.Copy(writer, response.Body)
+	if err != nil {
+		return written, errorlog.LogError("Failed to download the file ", name, " due to '", err.Error(), "'")
+	}
+	return written, nil
+}
+
+func (b Backend) Stat(ctx context.Context, destination httpBodies.DestinationInformation, name string) (storage.ObjectInfo, error) {
+	c, err := newClient(destination)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+
+	result, err := c.doForObject(ctx, http.MethodGet, c.getObjectURL(name, nil), nil, nil, 0)
+	if err != nil {
+		return storage.ObjectInfo{}, errorlog.LogError("Failed to get the stats of ", name, " due to '", err.Error(), "'")
+	}
+	return result.toObjectInfo(), nil
+}
+
+func (b Backend) List(ctx context.Context, destination httpBodies.DestinationInformation, prefix string) ([]storage.ObjectInfo, error) {
+	c, err := newClient(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []storage.ObjectInfo
+	var pageToken string
+	for {
+		query := url.Values{}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		response, err := c.do(ctx, http.MethodGet, c.getObjectURL("", query), nil, nil, 0, http.StatusOK)
+		if err != nil {
+			return nil, errorlog.LogError("Failed to list the objects of bucket ", destination.Bucket, " due to '", err.Error(), "'")
+		}
+		var result struct {
+			Items         []object `json:"items"`
+			NextPageToken string   `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+		if err != nil {
+			return nil, errorlog.LogError("Failed to parse the object list of bucket ", destination.Bucket, " due to '", err.Error(), "'")
+		}
+
+		for _, item := range result.Items {
+			objects = append(objects, item.toObjectInfo())
+		}
+		if result.NextPageToken == "" {
+			return objects, nil
+		}
+		pageToken = result.NextPageToken
+	}
+}
+
+func (b Backend) Delete(ctx context.Context, destination httpBodies.DestinationInformation, name string) error {
+	c, err := newClient(destination)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.do(ctx, http.MethodDelete, c.getObjectURL(name, nil), nil, nil, 0, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return errorlog.LogError("Failed to delete ", name, " due to '", err.Error(), "'")
+	}
+	response.Body.Close()
+	return nil
+}
+
+func (c *client) uploadMedia(ctx context.Context, name string, reader io.Reader, size int64) (object, error) {
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	// A reader with a size of 0 would otherwise be sent with chunked encoding
+	if size == 0 {
+		reader = http.NoBody
+	}
+	return c.doForObject(ctx, http.MethodPost, c.getUploadURL(name, "media"), header, reader, size)
+}
+
+// uploadResumable starts an upload session and sends the content chunk by chunk. The size does not need to be known,
+// the last chunk tells the service the total size.
+// See https://cloud.google.com/storage/docs/performing-resumable-uploads
+func (c *client) uploadResumable(ctx context.Context, name string, reader io.Reader, size int64, metadata map[string]string) (object, error) {
+	sessionURL, err := c.startResumableUpload(ctx, name, size, metadata)
+	if err != nil {
+		return object{}, err
+	}
+
+	buffered := bufio.NewReader(reader)
+	buffer := make([]byte, chunkSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(buffered, buffer)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return object{}, err
+		}
+		// A full chunk is the last one if nothing follows it
+		if !last {
+			if _, err = buffered.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return object{}, err
+			}
+		}
+
+		result, err := c.putChunk(ctx, sessionURL, buffer[:n], offset, last)
+		if err != nil {
+			return object{}, err
+		}
+		offset += int64(n)
+		if last {
+			return result, nil
+		}
+	}
+}
+
+// startResumableUpload creates the upload session. The metadata of the object is part of the request that creates the session.
+func (c *client) startResumableUpload(ctx context.Context, name string, size int64, metadata map[string]string) (string, error) {
+	header := http.Header{"X-Upload-Content-Type": {"application/octet-stream"}}
+	if size >= 0 {
+		header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+	}
+	var body io.Reader = http.NoBody
+	var contentLength int64
+	if len(metadata) > 0 {
+		content, err := json.Marshal(struct {
+			Metadata map[string]string `json:"metadata"`
+		}{metadata})
+		if err != nil {
+			return "", err
+		}
+		header.Set("Content-Type", "application/json; charset=UTF-8")
+		body, contentLength = bytes.NewReader(content), int64(len(content))
+	}
+	response, err := c.do(ctx, http.MethodPost, c.getUploadURL(name, "resumable"), header, body, contentLength, http.StatusOK, http.StatusCreated)
+	if err != nil {
+		return "", err
+	}
+	response.Body.Close()
+
+	location, err := response.Location()
+	if err != nil {
+		return "", errorlog.LogError("The service did not return the url of the upload session")
+	}
+	return location.String(), nil
+}
+
+// putChunk sends the chunk that starts at the given offset of the upload. If the service persisted only a part of it,
+// the rest is sent again. Failed requests are retried after asking the service how much of the upload it received.
+func (c *client) putChunk(ctx context.Context, sessionURL string, chunk []byte, offset int64, last bool) (object, error) {
+	end := offset + int64(len(chunk))
+	total := int64(-1)
+	if last {
+		total = end
+	}
+
+	sent := offset
+	for attempt := 1; ; {
+		result, persisted, err := c.sendChunk(ctx, sessionURL, chunk[sent-offset:], sent, total)
+		if err == nil && persisted < 0 {
+			return result, nil
+		}
+		if err == nil && persisted >= end && !last {
+			return object{}, nil
+		}
+
+		if err == nil && persisted <= sent {
+			err = errorlog.LogError("The service did not persist any of the bytes starting at ", strconv.FormatInt(sent, 10))
+		}
+		if err != nil {
+			if ctx.Err() != nil || attempt >= maxChunkAttempts {
+				return object{}, err
+			}
+			attempt++
+			log.Println("Uploading the chunk at", sent, "bytes failed due to", err.Error(), "-> retrying")
+			// Querying the status is a request without content
+			if result, persisted, err = c.sendChunk(ctx, sessionURL, nil, -1, -1); err != nil {
+				return object{}, err
+			}
+			if persisted < 0 {
+				return result, nil
+			}
+		}
+
+		if persisted < offset || persisted > end {
+			return object{}, errorlog.LogError("The service persisted ", strconv.FormatInt(persisted, 10), " bytes, which is outside of the chunk at ", strconv.FormatInt(offset, 10))
+		}
+		sent = persisted
+	}
+}
+
+// sendChunk sends the data as the part of the upload that starts at start. A total of -1 means the total size is not known yet.
+// It returns the object if the upload is complete, otherwise the number of bytes the service persisted and -1 as the object is missing.
+// Without data and with a start of -1 the request only queries the status of the upload.
+func (c *client) sendChunk(ctx context.Context, sessionURL string, data []byte, start int64, total int64) (object, int64, error) {
+	totalString := "*"
+	if total >= 0 {
+		totalString = strconv.FormatInt(total, 10)
+	}
+	contentRange := "bytes */" + totalString
+	if len(data) > 0 {
+		contentRange = "bytes " + strconv.FormatInt(start, 10) + "-" + strconv.FormatInt(start+int64(len(data))-1, 10) + "/" + totalString
+	}
+
+	var body io.Reader = http.NoBody
+	if len(data) > 0 {
+		body = bytes.NewReader(data)
+	}
+	header := http.Header{"Content-Range": {contentRange}}
+	response, err := c.do(ctx, http.MethodPut, sessionURL, header, body, int64(len(data)), http.StatusOK, http.StatusCreated, statusResumeIncomplete)
+	if err != nil {
+		return object{}, 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != statusResumeIncomplete {
+		var result object
+		err = json.NewDecoder(response.Body).Decode(&result)
+		return result, -1, err
+	}
+
+	// The range header is missing if the service did not persist any bytes yet, otherwise it looks like "bytes=0-42"
+	rangeHeader := response.Header.Get("Range")
+	if rangeHeader == "" {
+		return object{}, 0, nil
+	}
+	lastByte, err := strconv.ParseInt(rangeHeader[strings.LastIndex(rangeHeader, "-")+1:], 10, 64)
+	if err != nil {
+		return object{}, 0, errorlog.LogError("The service returned the invalid range ", rangeHeader)
+	}
+	return object{}, lastByte + 1, nil
+}
@@ -0,0 +1,438 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/httpBodies"
+)
+
+const testBucket = "backups"
+
+// fakeStorage implements the parts of the JSON API the backend uses and the token endpoint of a service account.
+// Listings return two objects per page.
+type fakeStorage struct {
+	mutex     sync.Mutex
+	server    *httptest.Server
+	url       string
+	publicKey *rsa.PublicKey
+	objects   map[string]fakeObject
+	sessions  map[string]*fakeSession
+	// Number of token requests, chunk requests and status queries of resumable uploads
+	tokenRequests, chunkRequests, statusQueries int
+	// The next chunks of resumable uploads fail without persisting anything, or persist only their first half
+	failingChunks, partialChunks int
+}
+
+type fakeObject struct {
+	content  []byte
+	metadata map[string]string
+	updated  time.Time
+}
+
+type fakeSession struct {
+	name     string
+	metadata map[string]string
+	content  []byte
+}
+
+// newFakeStorage starts the fake storage, whose server has to be closed by the caller.
+func newFakeStorage(publicKey *rsa.PublicKey) *fakeStorage {
+	s := &fakeStorage{publicKey: publicKey, objects: make(map[string]fakeObject), sessions: make(map[string]*fakeSession)}
+	s.server = httptest.NewServer(s)
+	s.url = s.server.URL
+	return s
+}
+
+func (s *fakeStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if r.URL.Path == "/token" {
+		s.issueToken(w, r)
+		return
+	}
+	if s.publicKey != nil && !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token-") {
+		writeError(w, http.StatusUnauthorized, "Invalid Credentials")
+		return
+	}
+
+	objectsPath := "/storage/v1/b/" + testBucket + "/o"
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/upload"+objectsPath:
+		s.startUpload(w, r)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/upload/session/"):
+		s.putChunk(w, r, s.sessions[strings.TrimPrefix(r.URL.Path, "/upload/session/")])
+	case r.Method == http.MethodGet && r.URL.Path == objectsPath:
+		s.list(w, r.URL.Query().Get("prefix"), r.URL.Query().Get("pageToken"))
+	case strings.HasPrefix(r.URL.Path, objectsPath+"/"):
+		name := strings.TrimPrefix(r.URL.Path, objectsPath+"/")
+		stored, exists := s.objects[name]
+		if !exists {
+			writeError(w, http.StatusNotFound, "No such object: "+testBucket+"/"+name)
+		} else if r.Method == http.MethodDelete {
+			delete(s.objects, name)
+			w.WriteHeader(http.StatusNoContent)
+		} else if r.URL.Query().Get("alt") == "media" {
+			w.Write(stored.content)
+		} else {
+			json.NewEncoder(w).Encode(stored.toObject(name))
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "Unsupported request")
+	}
+}
+
+// issueToken verifies the signed JWT of the token request as the token endpoint of Google does.
+func (s *fakeStorage) issueToken(w http.ResponseWriter, r *http.Request) {
+	s.tokenRequests++
+	r.ParseForm()
+	parts := strings.Split(r.PostForm.Get("assertion"), ".")
+	if r.PostForm.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || len(parts) != 3 {
+		writeTokenError(w, "invalid_grant")
+		return
+	}
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(s.publicKey, crypto.SHA256, hash[:], signature) != nil {
+		writeTokenError(w, "Invalid JWT Signature.")
+		return
+	}
+	var claims struct {
+		Aud   string `json:"aud"`
+		Scope string `json:"scope"`
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	if json.Unmarshal(payload, &claims) != nil || claims.Aud != s.url+"/token" || claims.Scope != scope {
+		writeTokenError(w, "Invalid JWT claims.")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token-" + strconv.Itoa(s.tokenRequests), "expires_in": 3600})
+}
+
+func (s *fakeStorage) startUpload(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	body, _ := ioutil.ReadAll(r.Body)
+	if r.URL.Query().Get("uploadType") == "media" {
+		s.objects[name] = fakeObject{content: body, updated: time.Now()}
+		json.NewEncoder(w).Encode(s.objects[name].toObject(name))
+		return
+	}
+
+	var resource struct {
+		Metadata map[string]string `json:"metadata"`
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &resource); err != nil {
+			writeError(w, http.StatusBadRequest, "Parse Error")
+			return
+		}
+	}
+	id := strconv.Itoa(len(s.sessions) + 1)
+	s.sessions[id] = &fakeSession{name: name, metadata: resource.Metadata}
+	w.Header().Set("Location", s.url+"/upload/session/"+id)
+}
+
+func (s *fakeStorage) putChunk(w http.ResponseWriter, r *http.Request, session *fakeSession) {
+	if session == nil {
+		writeError(w, http.StatusNotFound, "No such upload session")
+		return
+	}
+	data, _ := ioutil.ReadAll(r.Body)
+	// The content range looks like "bytes 0-99/*", "bytes 0-99/100" or "bytes */100" for a status query
+	contentRange := strings.TrimPrefix(r.Header.Get("Content-Range"), "bytes ")
+	slash := strings.LastIndex(contentRange, "/")
+	total, err := strconv.Atoi(contentRange[slash+1:])
+	if err != nil {
+		total = -1
+	}
+
+	if len(data) == 0 {
+		s.statusQueries++
+	} else {
+		s.chunkRequests++
+		start, _ := strconv.Atoi(contentRange[:strings.Index(contentRange, "-")])
+		if start != len(session.content) {
+			writeError(w, http.StatusBadRequest, "Invalid request. The chunk does not start at the persisted size.")
+			return
+		}
+		if s.failingChunks > 0 {
+			s.failingChunks--
+			writeError(w, http.StatusServiceUnavailable, "Backend Error")
+			return
+		}
+		if s.partialChunks > 0 {
+			s.partialChunks--
+			data, total = data[:len(data)/2], -1
+		}
+		session.content = append(session.content, data...)
+	}
+
+	if total == len(session.content) {
+		s.objects[session.name] = fakeObject{content: session.content, metadata: session.metadata, updated: time.Now()}
+		json.NewEncoder(w).Encode(s.objects[session.name].toObject(session.name))
+		return
+	}
+	if len(session.content) > 0 {
+		w.Header().Set("Range", "bytes=0-"+strconv.Itoa(len(session.content)-1))
+	}
+	w.WriteHeader(statusResumeIncomplete)
+}
+
+func (s *fakeStorage) list(w http.ResponseWriter, prefix, pageToken string) {
+	var names []string
+	for name := range s.objects {
+		if strings.HasPrefix(name, prefix) && name > pageToken {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var result struct {
+		Items         []object `json:"items"`
+		NextPageToken string   `json:"nextPageToken,omitempty"`
+	}
+	for i, name := range names {
+		if i == 2 {
+			result.NextPageToken = names[1]
+			break
+		}
+		result.Items = append(result.Items, s.objects[name].toObject(name))
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func (o fakeObject) toObject(name string) object {
+	return object{Name: name, Size: strconv.Itoa(len(o.content)), Generation: strconv.FormatInt(o.updated.UnixNano(), 10),
+		Updated: o.updated.UTC().Format(time.RFC3339Nano), Metadata: o.metadata}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": status, "message": message}})
+}
+
+func writeTokenError(w http.ResponseWriter, description string) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": description})
+}
+
+// newTestDestination returns a destination with a service account of a generated key, whose tokens the fake storage issues.
+// The server of the fake storage has to be closed by the caller.
+func newTestDestination(t *testing.T) (httpBodies.DestinationInformation, *fakeStorage) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newFakeStorage(&key.PublicKey)
+	account, _ := json.Marshal(serviceAccount{
+		ClientEmail:  "agent@project.iam.gserviceaccount.com",
+		PrivateKeyId: "key-1",
+		PrivateKey:   string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})),
+		TokenURI:     s.url + "/token",
+	})
+	return httpBodies.DestinationInformation{Type: Type, Bucket: testBucket, Service_account_json: string(account), Endpoint: s.url}, s
+}
+
+func getTestData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7 % 251)
+	}
+	return data
+}
+
+func assertRoundTrip(t *testing.T, destination httpBodies.DestinationInformation, name string, data []byte, size int64) {
+	info, err := Backend{}.Upload(context.Background(), destination, name, bytes.NewReader(data), size)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if info.Name != name || info.Size != int64(len(data)) || info.Version == "" {
+		t.Errorf("Unexpected stats of the upload: %+v", info)
+	}
+
+	var downloaded bytes.Buffer
+	n, err := Backend{}.Download(context.Background(), destination, name, &downloaded)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if n != int64(len(data)) || !bytes.Equal(downloaded.Bytes(), data) {
+		t.Errorf("The downloaded data differs from the uploaded data, got %d bytes", n)
+	}
+}
+
+func TestSmallUploadUsesASingleRequest(t *testing.T) {
+	destination, s := newTestDestination(t)
+	defer s.server.Close()
+	assertRoundTrip(t, destination, "dir/db.tar.gz", getTestData(1000), 1000)
+	if len(s.sessions) != 0 {
+		t.Errorf("Expected a single request, got %d resumable uploads", len(s.sessions))
+	}
+	if s.tokenRequests != 1 {
+		t.Errorf("Expected the access token to be cached, got %d token requests", s.tokenRequests)
+	}
+}
+
+func TestEmptyUpload(t *testing.T) {
+	destination, s := newTestDestination(t)
+	defer s.server.Close()
+	assertRoundTrip(t, destination, "db.tar", nil, 0)
+}
+
+func TestResumableUploadInChunks(t *testing.T) {
+	destination, s := newTestDestination(t)
+	defer s.server.Close()
+	assertRoundTrip(t, destination, "db.tar", getTestData(2*chunkSize+1000), 2*chunkSize+1000)
+	if s.chunkRequests != 3 {
+		t.Errorf("Expected 3 chunks, got %d", s.chunkRequests)
+	}
+}
+
+func TestResumableUploadOfUnknownSize(t *testing.T) {
+	destination, s := newTestDestination(t)
+	defer s.server.Close()
+	// The last chunk is full, so the upload only ends when the reader is at its end
+	assertRoundTrip(t, destination, "db.tar", getTestData(2*chunkSize), -1)
+	if s.chunkRequests != 2 {
+		t.Errorf("Expected 2 chunks, got %d", s.chunkRequests)
+	}
+}
+
+func TestMetadataIsStoredWithTheObject(t *testing.T) {
+	destination, s := newTestDestination(t)
+	defer s.server.Close()
+	destination.Metadata = map[string]string{"Compression": "zstd"}
+
+	info, err := Backend{}.Upload(context.Background(), destination, "db.tar.zst", strings.NewReader("data"), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.sessions) != 1 {
+		t.Error("The metadata was not sent with a resumable upload")
+	}
+	if info.Metadata["compression"] != "zstd" {
+		t.Errorf("Expected the metadata in the stats of the upload, got %v", info.Metadata)
+	}
+	if info, err = (Backend{}).Stat(context.Background(), destination, "db.tar.zst"); err != nil || info.Metadata["compression"] != "zstd" {
+		t.Errorf("Expected the metadata in the stats of the object, got %v, %v", info.Metadata, err)
+	}
+}
+
+func TestFailedChunkIsResumed(t *testing.T) {
+	destination, s := newTestDestination(t)
+	defer s.server.Close()
+	s.failingChunks = 1
+	s.partialChunks = 1
+
+	assertRoundTrip(t, destination, "db.tar", getTestData(chunkSize+1000), chunkSize+1000)
+	if s.statusQueries != 1 {
+		t.Errorf("Expected the status of the upload to be queried once after the failed chunk, got %d queries", s.statusQueries)
+	}
+	// The failed first attempt, the partially persisted second one, the rest of the first chunk and the last chunk
+	if s.chunkRequests != 4 {
+		t.Errorf("Expected 4 chunk requests, got %d", s.chunkRequests)
+	}
+}
+
+func TestUploadFailsAfterTheLastAttempt(t *testing.T) {
+	destination, s := newTestDestination(t)
+	defer s.server.Close()
+	s.failingChunks = maxChunkAttempts
+
+	_, err := Backend{}.Upload(context.Background(), destination, "db.tar", bytes.NewReader(getTestData(chunkSize+1)), chunkSize+1)
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Expected the upload to fail with status 503, got %v", err)
+	}
+	if s.chunkRequests != maxChunkAttempts {
+		t.Errorf("Expected %d attempts, got %d", maxChunkAttempts, s.chunkRequests)
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	destination, s := newTestDestination(t)
+	defer s.server.Close()
+	for _, name := range []string{"db_1.tar", "db_2.tar", "db_3.tar", "other.tar"} {
+		if _, err := (Backend{}).Upload(context.Background(), destination, name, strings.NewReader(name), int64(len(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objects, err := Backend{}.List(context.Background(), destination, "db_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 3 || objects[2].Name != "db_3.tar" || objects[2].Size != int64(len("db_3.tar")) {
+		t.Errorf("Expected the three objects with the prefix over all pages, got %+v", objects)
+	}
+
+	if err = (Backend{}).Delete(context.Background(), destination, "db_1.tar"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Backend{}.Stat(context.Background(), destination, "db_1.tar")
+	if err == nil || !strings.Contains(err.Error(), "No such object") {
+		t.Errorf("Expected the deleted object to be missing, got %v", err)
+	}
+}
+
+func TestForeignServiceAccountIsRejected(t *testing.T) {
+	destination, s := newTestDestination(t)
+	defer s.server.Close()
+	other, otherStorage := newTestDestination(t)
+	otherStorage.server.Close()
+	// The account is signed with another key than the one the token endpoint knows
+	var account serviceAccount
+	json.Unmarshal([]byte(destination.Service_account_json), &account)
+	var otherAccount serviceAccount
+	json.Unmarshal([]byte(other.Service_account_json), &otherAccount)
+	account.PrivateKey = otherAccount.PrivateKey
+	account.PrivateKeyId = "key-2"
+	content, _ := json.Marshal(account)
+	destination.Service_account_json = string(content)
+
+	_, err := Backend{}.Upload(context.Background(), destination, "db.tar", strings.NewReader("data"), 4)
+	if err == nil || !strings.Contains(err.Error(), "Invalid JWT Signature") {
+		t.Errorf("Expected the token request to be rejected, got %v", err)
+	}
+}
+
+func TestEmulatorWithoutServiceAccount(t *testing.T) {
+	s := newFakeStorage(nil)
+	defer s.server.Close()
+	destination := httpBodies.DestinationInformation{Type: Type, Bucket: testBucket, Endpoint: s.url}
+
+	if missingFields := (Backend{}).Validate(destination, true); missingFields != "" {
+		t.Errorf("An emulator endpoint should not need a service account, missing%s", missingFields)
+	}
+	assertRoundTrip(t, destination, "db.tar", getTestData(100), 100)
+}
+
+func TestServiceAccountIsRequiredWithoutEndpoint(t *testing.T) {
+	destination := httpBodies.DestinationInformation{Type: Type, Bucket: testBucket}
+	if missingFields := (Backend{}).Validate(destination, false); missingFields != " service_account_json filename" {
+		t.Errorf("Unexpected missing fields%s", missingFields)
+	}
+	if _, err := newClient(destination); err == nil {
+		t.Error("A client without service account and endpoint was created")
+	}
+}
@@ -3,43 +3,73 @@ package httpBodies
 import (
 	"fmt"
 	"log"
+	"reflect"
 	"strconv"
 
+	"github.com/evoila/osb-backup-agent/encryption"
 	"github.com/evoila/osb-backup-agent/errorlog"
 )
 
 const Status_running = "RUNNING"
 const Status_success = "SUCCEEDED"
 const Status_failed = "FAILED"
+const Status_cancelled = "CANCELLED"
 
+// Streaming modes of backup and restore jobs, which transfer the data between the script and the storage without a local file
+const Streaming_stdout = "stdout"
+const Streaming_pipe = "pipe"
+
+// Algorithms the agent compresses backups with while uploading them
+const Compression_gzip = "gzip"
+const Compression_zstd = "zstd"
+
+// Algorithm the agent encrypts backups with while uploading them
+const Encryption_aes256gcm = "aes-256-gcm"
+
+// BackupResponse is a backup job. Its log fields are filled from the stages for the v1 api, only jobs stored by older versions
+// of the agent carry them themselves.
 type BackupResponse struct {
-	Status                   string   `json:"status"`
-	Message                  string   `json:"message"`
-	State                    string   `json:"state"`
-	ErrorMessage             string   `json:"error_message,omitempty"`
-	Type                     string   `json:"type"`
-	Compression              bool     `json:"compression"`
-	Region                   string   `json:"region,omitempty"`
-	Bucket                   string   `json:"bucket,omitempty"`
-	AuthUrl                  string   `json:"authUrl,omitempty"`
-	Domain                   string   `json:"domain,omitempty"`
-	ContainerName            string   `json:"container_name,omitempty"`
-	ProjectName              string   `json:"project_name,omitempty"`
-	FileName                 string   `json:"filename"`
-	FileSize                 FileSize `json:"filesize"`
-	StartTime                string   `json:"start_time"`
-	EndTime                  string   `json:"end_time"`
-	ExecutionTime            int64    `json:"execution_time_ms"`
-	PreBackupLockLog         string   `json:"pre_backup_lock_log"`
-	PreBackupLockErrorLog    string   `json:"pre_backup_lock_errorlog"`
-	PreBackupCheckLog        string   `json:"pre_backup_check_log"`
-	PreBackupCheckErrorLog   string   `json:"pre_backup_check_errorlog"`
-	BackupLog                string   `json:"backup_log"`
-	BackupErrorLog           string   `json:"backup_errorlog"`
-	BackupCleanupLog         string   `json:"backup_cleanup_log"`
-	BackupCleanupErrorLog    string   `json:"backup_cleanup_errorlog"`
-	PostBackupUnlockLog      string   `json:"post_backup_unlock_log"`
-	PostBackupUnlockErrorLog string   `json:"post_backup_unlock_errorlog"`
+	Status                   string      `json:"status"`
+	Message                  string      `json:"message"`
+	State                    string      `json:"state"`
+	ErrorMessage             string      `json:"error_message,omitempty"`
+	Type                     string      `json:"type"`
+	Compression              bool        `json:"compression"`
+	CompressionAlgorithm     string      `json:"compression_algorithm,omitempty"`
+	EncryptionAlgorithm      string      `json:"encryption_algorithm,omitempty"`
+	Region                   string      `json:"region,omitempty"`
+	Bucket                   string      `json:"bucket,omitempty"`
+	AuthUrl                  string      `json:"authUrl,omitempty"`
+	Domain                   string      `json:"domain,omitempty"`
+	ContainerName            string      `json:"container_name,omitempty"`
+	ProjectName              string      `json:"project_name,omitempty"`
+	Database                 string      `json:"database,omitempty"`
+	FileName                 string      `json:"filename"`
+	FileSize                 FileSize    `json:"filesize"`
+	Version                  string      `json:"version,omitempty"`
+	ObjectLock               *ObjectLock `json:"object_lock,omitempty"`
+	StartTime                string      `json:"start_time"`
+	EndTime                  string      `json:"end_time"`
+	ExecutionTime            int64       `json:"execution_time_ms"`
+	PreBackupLockLog         string      `json:"pre_backup_lock_log"`
+	PreBackupLockErrorLog    string      `json:"pre_backup_lock_errorlog"`
+	PreBackupCheckLog        string      `json:"pre_backup_check_log"`
+	PreBackupCheckErrorLog   string      `json:"pre_backup_check_errorlog"`
+	BackupLog                string      `json:"backup_log"`
+	BackupErrorLog           string      `json:"backup_errorlog"`
+	BackupCleanupLog         string      `json:"backup_cleanup_log"`
+	BackupCleanupErrorLog    string      `json:"backup_cleanup_errorlog"`
+	PostBackupUnlockLog      string      `json:"post_backup_unlock_log"`
+	PostBackupUnlockErrorLog string      `json:"post_backup_unlock_errorlog"`
+	// Stages are only part of the v2 api, the v1 api drops them via GetBackupResponseV1
+	Stages []StageResult `json:"stages,omitempty"`
+}
+
+// ObjectLock describes the retention of an uploaded backup, which can not be deleted or overwritten before retain_until.
+type ObjectLock struct {
+	Mode        string `json:"mode,omitempty"`
+	RetainUntil string `json:"retain_until,omitempty"`
+	LegalHold   bool   `json:"legal_hold"`
 }
 
 type FileSize struct {
@@ -47,6 +77,8 @@ type FileSize struct {
 	Unit string `json:"unit"`
 }
 
+// RestoreResponse is a restore job. Its log fields are filled from the stages for the v1 api, only jobs stored by older versions
+// of the agent carry them themselves.
 type RestoreResponse struct {
 	Status                    string `json:"status"`
 	Message                   string `json:"message"`
@@ -54,6 +86,9 @@ type RestoreResponse struct {
 	ErrorMessage              string `json:"error_message,omitempty"`
 	Type                      string `json:"type"`
 	Compression               bool   `json:"compression"`
+	CompressionAlgorithm      string `json:"compression_algorithm,omitempty"`
+	EncryptionAlgorithm       string `json:"encryption_algorithm,omitempty"`
+	Database                  string `json:"database,omitempty"`
 	StartTime                 string `json:"start_time"`
 	EndTime                   string `json:"end_time"`
 	ExecutionTime             int64  `json:"execution_time_ms"`
@@ -65,6 +100,200 @@ type RestoreResponse struct {
 	RestoreCleanupErrorLog    string `json:"restore_cleanup_errorlog"`
 	PostRestoreUnlockLog      string `json:"post_restore_unlock_log"`
 	PostRestoreUnlockErrorLog string `json:"post_restore_unlock_errorlog"`
+	// Stages are only part of the v2 api, the v1 api drops them via GetRestoreResponseV1
+	Stages []StageResult `json:"stages,omitempty"`
+}
+
+// StageResult describes a single stage of a job in the v2 api.
+type StageResult struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	StartTime string `json:"start_time,omitempty"`
+	EndTime   string `json:"end_time,omitempty"`
+	Duration  int64  `json:"duration_ms"`
+	ExitCode  *int   `json:"exit_code,omitempty"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+}
+
+// BackupResponseV2 is the backup job of the v2 api, which reports the stages as an array instead of dedicated log fields.
+type BackupResponseV2 struct {
+	Status               string        `json:"status"`
+	Message              string        `json:"message"`
+	State                string        `json:"state"`
+	ErrorMessage         string        `json:"error_message,omitempty"`
+	Type                 string        `json:"type"`
+	Compression          bool          `json:"compression"`
+	CompressionAlgorithm string        `json:"compression_algorithm,omitempty"`
+	EncryptionAlgorithm  string        `json:"encryption_algorithm,omitempty"`
+	Region               string        `json:"region,omitempty"`
+	Bucket               string        `json:"bucket,omitempty"`
+	AuthUrl              string        `json:"authUrl,omitempty"`
+	Domain               string        `json:"domain,omitempty"`
+	ContainerName        string        `json:"container_name,omitempty"`
+	ProjectName          string        `json:"project_name,omitempty"`
+	Database             string        `json:"database,omitempty"`
+	FileName             string        `json:"filename"`
+	FileSize             FileSize      `json:"filesize"`
+	Version              string        `json:"version,omitempty"`
+	ObjectLock           *ObjectLock   `json:"object_lock,omitempty"`
+	StartTime            string        `json:"start_time"`
+	EndTime              string        `json:"end_time"`
+	ExecutionTime        int64         `json:"execution_time_ms"`
+	Stages               []StageResult `json:"stages"`
+}
+
+// RestoreResponseV2 is the restore job of the v2 api, which reports the stages as an array instead of dedicated log fields.
+type RestoreResponseV2 struct {
+	Status               string        `json:"status"`
+	Message              string        `json:"message"`
+	State                string        `json:"state"`
+	ErrorMessage         string        `json:"error_message,omitempty"`
+	Type                 string        `json:"type"`
+	Compression          bool          `json:"compression"`
+	CompressionAlgorithm string        `json:"compression_algorithm,omitempty"`
+	EncryptionAlgorithm  string        `json:"encryption_algorithm,omitempty"`
+	Database             string        `json:"database,omitempty"`
+	StartTime            string        `json:"start_time"`
+	EndTime              string        `json:"end_time"`
+	ExecutionTime        int64         `json:"execution_time_ms"`
+	Stages               []StageResult `json:"stages"`
+}
+
+// GetBackupResponseV1 returns the job in the shape of the v1 api.
+func GetBackupResponseV1(job *BackupResponse) interface{} {
+	response := *job
+	response.Stages = nil
+	return response
+}
+
+// GetBackupResponseV2 returns the job in the shape of the v2 api.
+func GetBackupResponseV2(job *BackupResponse) interface{} {
+	stages := job.Stages
+	if stages == nil {
+		stages = []StageResult{}
+	}
+	return BackupResponseV2{
+		Status:               job.Status,
+		Message:              job.Message,
+		State:                job.State,
+		ErrorMessage:         job.ErrorMessage,
+		Type:                 job.Type,
+		Compression:          job.Compression,
+		CompressionAlgorithm: job.CompressionAlgorithm,
+		EncryptionAlgorithm:  job.EncryptionAlgorithm,
+		Region:               job.Region,
+		Bucket:               job.Bucket,
+		AuthUrl:              job.AuthUrl,
+		Domain:               job.Domain,
+		ContainerName:        job.ContainerName,
+		ProjectName:          job.ProjectName,
+		Database:             job.Database,
+		FileName:             job.FileName,
+		FileSize:             job.FileSize,
+		Version:              job.Version,
+		ObjectLock:           job.ObjectLock,
+		StartTime:            job.StartTime,
+		EndTime:              job.EndTime,
+		ExecutionTime:        job.ExecutionTime,
+		Stages:               stages,
+	}
+}
+
+// GetRestoreResponseV1 returns the job in the shape of the v1 api.
+func GetRestoreResponseV1(job *RestoreResponse) interface{} {
+	response := *job
+	response.Stages = nil
+	return response
+}
+
+// GetRestoreResponseV2 returns the job in the shape of the v2 api.
+func GetRestoreResponseV2(job *RestoreResponse) interface{} {
+	stages := job.Stages
+	if stages == nil {
+		stages = []StageResult{}
+	}
+	return RestoreResponseV2{
+		Status:               job.Status,
+		Message:              job.Message,
+		State:                job.State,
+		ErrorMessage:         job.ErrorMessage,
+		Type:                 job.Type,
+		Compression:          job.Compression,
+		CompressionAlgorithm: job.CompressionAlgorithm,
+		EncryptionAlgorithm:  job.EncryptionAlgorithm,
+		Database:             job.Database,
+		StartTime:            job.StartTime,
+		EndTime:              job.EndTime,
+		ExecutionTime:        job.ExecutionTime,
+		Stages:               stages,
+	}
+}
+
+// JobListResponse holds a page of the jobs that match the filters of a listing request.
+type JobListResponse struct {
+	Total  int            `json:"total"`
+	Offset int            `json:"offset"`
+	Limit  int            `json:"limit"`
+	Jobs   []JobListEntry `json:"jobs"`
+}
+
+// JobListEntry is a single job of a listing in the shape of the requested api version.
+type JobListEntry struct {
+	Id  string      `json:"id"`
+	Job interface{} `json:"job"`
+}
+
+// GetBackupResponseWithoutLogs returns a copy of the job without the logs of its scripts.
+func GetBackupResponseWithoutLogs(job *BackupResponse) *BackupResponse {
+	response := *job
+	response.PreBackupLockLog, response.PreBackupLockErrorLog = "", ""
+	response.PreBackupCheckLog, response.PreBackupCheckErrorLog = "", ""
+	response.BackupLog, response.BackupErrorLog = "", ""
+	response.BackupCleanupLog, response.BackupCleanupErrorLog = "", ""
+	response.PostBackupUnlockLog, response.PostBackupUnlockErrorLog = "", ""
+	response.Stages = getStagesWithoutLogs(job.Stages)
+	return &response
+}
+
+// GetRestoreResponseWithoutLogs returns a copy of the job without the logs of its scripts.
+func GetRestoreResponseWithoutLogs(job *RestoreResponse) *RestoreResponse {
+	response := *job
+	response.PreRestoreLockLog, response.PreRestoreLockErrorLog = "", ""
+	response.RestoreLog, response.RestoreErrorLog = "", ""
+	response.RestoreCleanupLog, response.RestoreCleanupErrorLog = "", ""
+	response.PostRestoreUnlockLog, response.PostRestoreUnlockErrorLog = "", ""
+	response.Stages = getStagesWithoutLogs(job.Stages)
+	return &response
+}
+
+func getStagesWithoutLogs(stages []StageResult) []StageResult {
+	if stages == nil {
+		return nil
+	}
+	withoutLogs := make([]StageResult, len(stages))
+	for i, stage := range stages {
+		stage.Stdout, stage.Stderr = "", ""
+		withoutLogs[i] = stage
+	}
+	return withoutLogs
+}
+
+// SetStageResult returns a copy of the stages, in which the result replaces the stage with the same name or is appended.
+// The given stages are not changed, as they may be read by requests for the job at the same time.
+func SetStageResult(stages []StageResult, result StageResult) []StageResult {
+	updated := make([]StageResult, 0, len(stages)+1)
+	replaced := false
+	for _, stage := range stages {
+		if stage.Name == result.Name {
+			stage, replaced = result, true
+		}
+		updated = append(updated, stage)
+	}
+	if !replaced {
+		updated = append(updated, result)
+	}
+	return updated
 }
 
 type ErrorResponse struct {
@@ -74,19 +303,36 @@ type ErrorResponse struct {
 }
 
 type BackupBody struct {
-	Id             string
-	Compression    bool
-	Encryption_key string
-	Destination    DestinationInformation
-	Backup         DbInformation
+	Id                    string
+	Compression           bool
+	Compression_algorithm string
+	Compression_level     int
+	Encryption_key        string
+	Encryption_algorithm  string
+	Encryption_public_key string
+	Streaming             string
+	Destination           DestinationInformation
+	Backup                DbInformation
+	Timeouts              TimeoutInformation
 }
 
 type RestoreBody struct {
-	Id             string
-	Compression    bool
-	Encryption_key string
-	Destination    DestinationInformation
-	Restore        DbInformation
+	Id                    string
+	Compression           bool
+	Compression_algorithm string
+	Encryption_key        string
+	Encryption_algorithm  string
+	Streaming             string
+	Skip_verification     bool
+	Destination           DestinationInformation
+	Restore               DbInformation
+	Timeouts              TimeoutInformation
+}
+
+// TimeoutInformation overrides the configured timeouts for a single job. Durations use the go duration format, for example "90m".
+type TimeoutInformation struct {
+	Job    string
+	Stages map[string]string
 }
 
 type DestinationInformation struct {
@@ -97,12 +343,69 @@ type DestinationInformation struct {
 	AuthSecret string
 	Filename   string
 
+	// Fields of temporary S3 credentials and roles, which are assumed with the access key or a web identity token
+	Session_token      string
+	Role_arn           string
+	Role_session_name  string
+	External_id        string
+	Web_identity_token string
+
+	// Fields of the S3 upload: server-side encryption (AES256 or aws:kms, a base64 encoded 256 bit customer key for SSE-C),
+	// storage class, tags and user metadata of the object
+	Sse              string
+	Kms_key_id       string
+	Sse_customer_key string
+	Storage_class    string
+	Tags             map[string]string
+	Metadata         map[string]string
+
+	// Fields of the S3 object lock: GOVERNANCE or COMPLIANCE mode, the retention as duration like "720h" and the legal hold
+	Object_lock_mode       string
+	Object_lock_retention  string
+	Object_lock_legal_hold bool
+
+	// Fields of S3-compatible endpoints like MinIO or Ceph RGW, which are given as Endpoint
+	Path_style           bool
+	Insecure_skip_verify bool
+	Ca_cert              string
+
 	AuthUrl        string
 	Domain         string
 	Container_name string
 	Project_name   string
 	Username       string
 	Password       string
+	// Swift authenticates with Username and Password, with an application credential or with a pre-issued token.
+	// With Storage_url, a pre-issued token is used as it is, otherwise it is exchanged for a token scoped to the project
+	Application_credential_id     string
+	Application_credential_secret string
+	Auth_token                    string
+	Storage_url                   string
+
+	// Swift uploads larger than Segment_size bytes are split into a Static Large Object, whose segments are stored in Segment_container
+	Segment_size      int64
+	Segment_container string
+
+	// Path is the subdirectory of the LOCAL destination below the configured base directory, for example the service instance id
+	Path string
+
+	// Fields of the SFTP destination, which also uses Username and Password
+	Host                 string
+	Port                 int
+	Private_key          string
+	Host_key_fingerprint string
+	Remote_directory     string
+
+	// Fields of the AZURE destination, which also uses Container_name
+	Account_name string
+	Account_key  string
+	Sas_token    string
+
+	// Service_account_json holds the credentials of the GCS destination, which also uses Bucket
+	Service_account_json string
+
+	// Endpoint replaces the default service endpoint of the destination type, for example to use an emulator
+	Endpoint string
 }
 
 type DbInformation struct {
@@ -114,27 +417,24 @@ type DbInformation struct {
 }
 
 func PrintOutBackupBody(body BackupBody) {
-	authSecret := GetRedactedOrEmptyPasswordString(body.Destination.AuthSecret)
-	swiftPassword := GetRedactedOrEmptyPasswordString(body.Destination.Password)
 	dbPassword := GetRedactedOrEmptyPasswordString(body.Backup.Password)
+	// The key of the agent's encryption is a secret, while scripts may use a public key
+	encryptionKey := body.Encryption_key
+	if body.Encryption_algorithm != "" {
+		encryptionKey = GetRedactedOrEmptyPasswordString(body.Encryption_key)
+	}
 
 	log.Println("Backup Request Body: {\n",
 		errorlog.Concat([]string{"    \"id\" : \"", body.Id, "\",\n"}, ""),
 		errorlog.Concat([]string{"    \"compression\" : \"", strconv.FormatBool(body.Compression), "\",\n"}, ""),
-		errorlog.Concat([]string{"    \"encryption_key\" : \"", body.Encryption_key, "\",\n"}, ""),
+		errorlog.Concat([]string{"    \"compression_algorithm\" : \"", body.Compression_algorithm, "\",\n"}, ""),
+		errorlog.Concat([]string{"    \"compression_level\" : \"", strconv.Itoa(body.Compression_level), "\",\n"}, ""),
+		errorlog.Concat([]string{"    \"streaming\" : \"", body.Streaming, "\",\n"}, ""),
+		errorlog.Concat([]string{"    \"encryption_key\" : \"", encryptionKey, "\",\n"}, ""),
+		errorlog.Concat([]string{"    \"encryption_algorithm\" : \"", body.Encryption_algorithm, "\",\n"}, ""),
+		errorlog.Concat([]string{"    \"encryption_public_key\" : \"", body.Encryption_public_key, "\",\n"}, ""),
 		"    \"destination\" : {\n",
-		errorlog.Concat([]string{"        \"type\" : \"", body.Destination.Type, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"bucket\" : \"", body.Destination.Bucket, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"region\" : \"", body.Destination.Region, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"authKey\" : \"", body.Destination.AuthKey, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"authSecret\" : \"", authSecret, "\",\n"}, ""),
-		"\n",
-		errorlog.Concat([]string{"        \"authUrl\" : \"", body.Destination.AuthUrl, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"domain\" : \"", body.Destination.Domain, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"container_name\" : \"", body.Destination.Container_name, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"project_name\" : \"", body.Destination.Project_name, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"username\" : \"", body.Destination.Username, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"password\" : \"", swiftPassword, "\",\n"}, ""),
+		getDestinationAsLogStrings(body.Destination),
 		"    },\n",
 		"    \"backup\" : {\n",
 		errorlog.Concat([]string{"        \"host\" : \"", body.Backup.Host, "\",\n"}, ""),
@@ -157,6 +457,15 @@ func CheckForMissingFieldsInRestoreBody(body RestoreBody) (bool, string) {
 	if body.Encryption_key == "" {
 		missingFields += " encryption_key"
 	}
+	if !IsValidCompression(body.Compression_algorithm, 0) {
+		missingFields += " valid compression_algorithm (" + Compression_gzip + " or " + Compression_zstd + ")"
+	}
+	if !IsValidEncryption(body.Encryption_algorithm) {
+		missingFields += " valid encryption_algorithm (" + Encryption_aes256gcm + ")"
+	}
+	if !IsValidStreamingMode(body.Streaming) {
+		missingFields += " valid streaming (" + Streaming_stdout + " or " + Streaming_pipe + ")"
+	}
 
 	valid, fields := CheckForMissingFieldDestinationInformation(body.Destination, false)
 	if !valid {
@@ -175,9 +484,27 @@ func CheckForMissingFieldsInBackupBody(body BackupBody) (bool, string) {
 	if body.Id == "" {
 		missingFields += " id"
 	}
-	if body.Encryption_key == "" {
+	// A backup encrypted for a public key needs no secret key
+	if body.Encryption_key == "" && body.Encryption_public_key == "" {
 		missingFields += " encryption_key"
 	}
+	if body.Encryption_public_key != "" && body.Encryption_algorithm == "" {
+		missingFields += " encryption_algorithm"
+	}
+	if body.Encryption_public_key != "" {
+		if err := encryption.ValidatePublicKey(body.Encryption_public_key); err != nil {
+			missingFields += " valid encryption_public_key (" + err.Error() + ")"
+		}
+	}
+	if !IsValidCompression(body.Compression_algorithm, body.Compression_level) {
+		missingFields += " valid compression_algorithm (" + Compression_gzip + " or " + Compression_zstd + ") and compression_level"
+	}
+	if !IsValidEncryption(body.Encryption_algorithm) {
+		missingFields += " valid encryption_algorithm (" + Encryption_aes256gcm + ")"
+	}
+	if !IsValidStreamingMode(body.Streaming) {
+		missingFields += " valid streaming (" + Streaming_stdout + " or " + Streaming_pipe + ")"
+	}
 	valid, fields := CheckForMissingFieldDestinationInformation(body.Destination, true)
 	if !valid {
 		missingFields += " destination(" + fields + ")"
@@ -189,52 +516,80 @@ func CheckForMissingFieldsInBackupBody(body BackupBody) (bool, string) {
 	return missingFields == "", missingFields
 }
 
-func CheckForMissingFieldDestinationInformation(body DestinationInformation, fileCanBeMissing bool) (bool, string) {
-	missingFields := ""
-	if body.Type == "S3" {
-		if body.AuthKey == "" {
-			missingFields += " authKey"
-		}
-		if body.AuthSecret == "" {
-			missingFields += " authSecret"
-		}
-		if body.Bucket == "" {
-			missingFields += " bucket"
-		}
-		if body.Region == "" {
-			missingFields += " region"
-		}
-		if body.Filename == "" && !fileCanBeMissing {
-			missingFields += " filename"
-		}
-		return missingFields == "", missingFields
-	} else if body.Type == "SWIFT" {
-		if body.AuthUrl == "" {
-			missingFields += " authUrl"
-		}
-		if body.Domain == "" {
-			missingFields += " domain"
-		}
-		if body.Container_name == "" {
-			missingFields += " container_name"
-		}
-		if body.Project_name == "" {
-			missingFields += " project_name"
-		}
-		if body.Username == "" {
-			missingFields += " username"
-		}
-		if body.Password == "" {
-			missingFields += " password"
-		}
-		if body.Filename == "" && !fileCanBeMissing {
-			missingFields += " filename"
+// IsValidCompression returns true if the algorithm is empty, which disables the compression of the agent, or supported by the agent.
+// A level of 0 uses the default level of the algorithm, gzip supports the levels 1 to 9 and zstd the levels 1 to 22.
+func IsValidCompression(algorithm string, level int) bool {
+	switch algorithm {
+	case "":
+		return level == 0
+	case Compression_gzip:
+		return level >= 0 && level <= 9
+	case Compression_zstd:
+		return level >= 0 && level <= 22
+	}
+	return false
+}
+
+// IsValidEncryption returns true if the algorithm is empty, which disables the encryption of the agent, or supported by the agent.
+func IsValidEncryption(algorithm string) bool {
+	return algorithm == "" || algorithm == Encryption_aes256gcm
+}
+
+// IsValidStreamingMode returns true if the mode is empty, which stages the data in a local file, or one of the streaming modes.
+func IsValidStreamingMode(mode string) bool {
+	return mode == "" || mode == Streaming_stdout || mode == Streaming_pipe
+}
+
+// DestinationValidator returns the names of the missing fields of a destination, each preceded by a space.
+type DestinationValidator func(destination DestinationInformation, fileCanBeMissing bool) string
+
+var destinationValidators = make(map[string]DestinationValidator)
+
+// RegisterDestinationValidator sets the validator for the fields of a destination type. It is called when a storage backend is registered.
+func RegisterDestinationValidator(destinationType string, validator DestinationValidator) {
+	destinationValidators[destinationType] = validator
+}
+
+// DestinationRedactor returns a copy of a destination, which only holds the fields of its type with the credentials replaced.
+type DestinationRedactor func(destination DestinationInformation) DestinationInformation
+
+var destinationRedactors = make(map[string]DestinationRedactor)
+
+// RegisterDestinationRedactor sets the redactor for the logging of a destination type. It is called when a storage backend is registered.
+func RegisterDestinationRedactor(destinationType string, redactor DestinationRedactor) {
+	destinationRedactors[destinationType] = redactor
+}
+
+// getDestinationAsLogStrings returns the set fields of the destination as redacted by its type, a destination of an unknown type only shows the type.
+func getDestinationAsLogStrings(destination DestinationInformation) []string {
+	redacted := DestinationInformation{}
+	if redactor, exists := destinationRedactors[destination.Type]; exists {
+		redacted = redactor(destination)
+	}
+	redacted.Type = destination.Type
+
+	var strs []string
+	value := reflect.ValueOf(redacted)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		if reflect.DeepEqual(field.Interface(), reflect.Zero(field.Type()).Interface()) {
+			continue
 		}
-		return missingFields == "", missingFields
-	} else if body.Type == "" {
+		strs = append(strs, errorlog.Concat([]string{"        \"", value.Type().Field(i).Name, "\" : \"", fmt.Sprintf("%v", field.Interface()), "\",\n"}, ""))
+	}
+	return strs
+}
+
+func CheckForMissingFieldDestinationInformation(body DestinationInformation, fileCanBeMissing bool) (bool, string) {
+	if body.Type == "" {
 		return false, " type"
 	}
-	return false, " supported type"
+	validator, exists := destinationValidators[body.Type]
+	if !exists {
+		return false, " supported type"
+	}
+	missingFields := validator(body, fileCanBeMissing)
+	return missingFields == "", missingFields
 }
 
 func CheckForMissingFieldsInDbInformation(body DbInformation) (bool, string) {
@@ -255,29 +610,19 @@ func CheckForMissingFieldsInDbInformation(body DbInformation) (bool, string) {
 }
 
 func PrintOutRestoreBody(body RestoreBody) {
-	authSecret := GetRedactedOrEmptyPasswordString(body.Destination.AuthSecret)
-	swiftPassword := GetRedactedOrEmptyPasswordString(body.Destination.Password)
 	dbPassword := GetRedactedOrEmptyPasswordString(body.Restore.Password)
 	privateEncryptionKey := GetRedactedOrEmptyPasswordString(body.Encryption_key)
 
 	log.Println("Restore Request Body: {\n",
 		errorlog.Concat([]string{"    \"id\" : \"", body.Id, "\",\n"}, ""),
 		errorlog.Concat([]string{"    \"compression\" : \"", strconv.FormatBool(body.Compression), "\",\n"}, ""),
+		errorlog.Concat([]string{"    \"compression_algorithm\" : \"", body.Compression_algorithm, "\",\n"}, ""),
+		errorlog.Concat([]string{"    \"streaming\" : \"", body.Streaming, "\",\n"}, ""),
 		errorlog.Concat([]string{"    \"encryption_key\" : \"", privateEncryptionKey, "\",\n"}, ""),
+		errorlog.Concat([]string{"    \"encryption_algorithm\" : \"", body.Encryption_algorithm, "\",\n"}, ""),
+		errorlog.Concat([]string{"    \"skip_verification\" : \"", strconv.FormatBool(body.Skip_verification), "\",\n"}, ""),
 		"    \"destination\" : {\n",
-		errorlog.Concat([]string{"        \"type\" : \"", body.Destination.Type, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"bucket\" : \"", body.Destination.Bucket, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"region\" : \"", body.Destination.Region, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"authKey\" : \"", body.Destination.AuthKey, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"authSecret\" : \"", authSecret, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"filename\" : \"", body.Destination.Filename, "\",\n"}, ""),
-		"\n",
-		errorlog.Concat([]string{"        \"authUrl\" : \"", body.Destination.AuthUrl, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"domain\" : \"", body.Destination.Domain, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"container_name\" : \"", body.Destination.Container_name, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"project_name\" : \"", body.Destination.Project_name, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"username\" : \"", body.Destination.Username, "\",\n"}, ""),
-		errorlog.Concat([]string{"        \"password\" : \"", swiftPassword, "\",\n"}, ""),
+		getDestinationAsLogStrings(body.Destination),
 		"    },\n",
 		"    \"backup\" : {\n",
 		errorlog.Concat([]string{"        \"host\" : \"", body.Restore.Host, "\",\n"}, ""),
@@ -0,0 +1,74 @@
+package httpBodies
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+func TestPublicKeyIsValidatedWithTheBackupBody(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)}))
+	privateKey := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+
+	body := BackupBody{Id: "backup", Encryption_algorithm: Encryption_aes256gcm, Encryption_public_key: publicKey}
+	if _, missingFields := CheckForMissingFieldsInBackupBody(body); strings.Contains(missingFields, "encryption") {
+		t.Errorf("The public key was not accepted instead of the encryption_key:%s", missingFields)
+	}
+
+	body.Encryption_public_key = privateKey
+	valid, missingFields := CheckForMissingFieldsInBackupBody(body)
+	if valid || !strings.Contains(missingFields, " valid encryption_public_key (the public key is a private key)") {
+		t.Errorf("The private key was accepted as public key:%s", missingFields)
+	}
+
+	body.Encryption_algorithm = ""
+	if _, missingFields = CheckForMissingFieldsInBackupBody(body); !strings.Contains(missingFields, " encryption_algorithm") {
+		t.Errorf("The public key was accepted without an encryption_algorithm:%s", missingFields)
+	}
+}
+
+func TestSetStageResultDoesNotChangeTheGivenStages(t *testing.T) {
+	stages := make([]StageResult, 1, 4)
+	stages[0] = StageResult{Name: "backup", Status: "RUNNING"}
+
+	updated := SetStageResult(stages, StageResult{Name: "backup", Status: "SUCCEEDED"})
+	if stages[0].Status != "RUNNING" || len(updated) != 1 || updated[0].Status != "SUCCEEDED" {
+		t.Errorf("Replacing the stage changed the given stages: %v, %v", stages, updated)
+	}
+
+	// Appending must not write into the spare capacity of the given stages, which another copy of the job may share
+	appended := SetStageResult(stages, StageResult{Name: "upload", Status: "RUNNING"})
+	other := append(stages, StageResult{Name: "other"})
+	if len(appended) != 2 || appended[1].Name != "upload" || other[1].Name != "other" {
+		t.Errorf("Appending the stage shared the array of the given stages: %v, %v", appended, other)
+	}
+}
+
+func TestDestinationIsLoggedAsRedactedByItsType(t *testing.T) {
+	RegisterDestinationRedactor("REDACTING", func(destination DestinationInformation) DestinationInformation {
+		return DestinationInformation{Bucket: destination.Bucket, AuthSecret: GetRedactedOrEmptyPasswordString(destination.AuthSecret)}
+	})
+	destination := DestinationInformation{Type: "REDACTING", Bucket: "bucket", AuthSecret: "secret", Password: "unused"}
+
+	logged := strings.Join(getDestinationAsLogStrings(destination), "")
+	for _, expected := range []string{"\"Type\" : \"REDACTING\"", "\"Bucket\" : \"bucket\"", "\"AuthSecret\" : \"<redacted>\""} {
+		if !strings.Contains(logged, expected) {
+			t.Errorf("Expected %s in the logged destination %q", expected, logged)
+		}
+	}
+	if strings.Contains(logged, "secret\"") || strings.Contains(logged, "unused") || strings.Contains(logged, "Region") {
+		t.Errorf("The logged destination holds credentials or fields the type does not use: %q", logged)
+	}
+
+	destination.Type = "UNKNOWN"
+	if logged = strings.Join(getDestinationAsLogStrings(destination), ""); logged != "        \"Type\" : \"UNKNOWN\",\n" {
+		t.Errorf("Expected only the type of an unknown destination type to be logged, got %q", logged)
+	}
+}
@@ -0,0 +1,79 @@
+package jobs
+
+import (
+	"context"
+	"log"
+
+	"github.com/evoila/osb-backup-agent/mutex"
+)
+
+var cancelFuncs map[string]context.CancelFunc
+var cancelMutex mutex.Mutex
+
+func setUpCancelStructure() {
+	cancelFuncs = make(map[string]context.CancelFunc)
+	cancelMutex = make(mutex.Mutex, 1)
+	cancelMutex.Release()
+}
+
+// StartBackupJobContext returns the context of a running backup job, which gets cancelled via CancelBackupJob.
+func StartBackupJobContext(UUID string) context.Context {
+	return startJobContext("backup/" + UUID)
+}
+
+// FinishBackupJobContext releases the context of a backup job, after which it can not be cancelled anymore.
+func FinishBackupJobContext(UUID string) {
+	finishJobContext("backup/" + UUID)
+}
+
+// CancelBackupJob cancels a running backup job and returns false if there is no running job for the UUID.
+func CancelBackupJob(UUID string) bool {
+	return cancelJob("backup/" + UUID)
+}
+
+// StartRestoreJobContext returns the context of a running restore job, which gets cancelled via CancelRestoreJob.
+func StartRestoreJobContext(UUID string) context.Context {
+	return startJobContext("restore/" + UUID)
+}
+
+// FinishRestoreJobContext releases the context of a restore job, after which it can not be cancelled anymore.
+func FinishRestoreJobContext(UUID string) {
+	finishJobContext("restore/" + UUID)
+}
+
+// CancelRestoreJob cancels a running restore job and returns false if there is no running job for the UUID.
+func CancelRestoreJob(UUID string) bool {
+	return cancelJob("restore/" + UUID)
+}
+
+func startJobContext(key string) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	cancelMutex.Acquire()
+	cancelFuncs[key] = cancel
+	cancelMutex.Release()
+
+	return ctx
+}
+
+func finishJobContext(key string) {
+	cancelMutex.Acquire()
+	if cancel, exists := cancelFuncs[key]; exists {
+		cancel()
+		delete(cancelFuncs, key)
+	}
+	cancelMutex.Release()
+}
+
+func cancelJob(key string) bool {
+	cancelMutex.Acquire()
+	cancel, exists := cancelFuncs[key]
+	cancelMutex.Release()
+
+	if !exists {
+		return false
+	}
+	log.Println("Cancelling job", key)
+	cancel()
+	return true
+}
@@ -0,0 +1,198 @@
+package jobs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/evoila/osb-backup-agent/errorlog"
+	"github.com/evoila/osb-backup-agent/httpBodies"
+)
+
+const backupJobsDirectory = "backup"
+const restoreJobsDirectory = "restore"
+const jobFileSuffix = ".json"
+
+// FileStore persists every job as a json file in a dedicated directory, so jobs survive restarts of the agent.
+// All jobs are additionally kept in memory, the files are only read when the store is created.
+type FileStore struct {
+	directory   string
+	backupJobs  map[string]*httpBodies.BackupResponse
+	restoreJobs map[string]*httpBodies.RestoreResponse
+}
+
+type storedBackupJob struct {
+	Id  string                     `json:"id"`
+	Job *httpBodies.BackupResponse `json:"job"`
+}
+
+type storedRestoreJob struct {
+	Id  string                      `json:"id"`
+	Job *httpBodies.RestoreResponse `json:"job"`
+}
+
+// NewFileStore creates a file based store in the given directory and loads all jobs that were already persisted there.
+func NewFileStore(directory string) (*FileStore, error) {
+	if directory == "" {
+		return nil, errorlog.LogError("No directory for the job store was given")
+	}
+
+	s := &FileStore{
+		directory:   directory,
+		backupJobs:  make(map[string]*httpBodies.BackupResponse),
+		restoreJobs: make(map[string]*httpBodies.RestoreResponse),
+	}
+
+	for _, subDirectory := range []string{backupJobsDirectory, restoreJobsDirectory} {
+		if err := os.MkdirAll(filepath.Join(directory, subDirectory), 0700); err != nil {
+			return nil, errorlog.LogError("Creating the job store directory failed due to '", err.Error(), "'")
+		}
+	}
+
+	err := s.loadJobs(backupJobsDirectory, func(data []byte) error {
+		var stored storedBackupJob
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+		s.backupJobs[stored.Id] = stored.Job
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.loadJobs(restoreJobsDirectory, func(data []byte) error {
+		var stored storedRestoreJob
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+		s.restoreJobs[stored.Id] = stored.Job
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("Loaded", len(s.backupJobs), "backup jobs and", len(s.restoreJobs), "restore jobs from", directory)
+	return s, nil
+}
+
+func (s *FileStore) GetBackupJob(UUID string) (*httpBodies.BackupResponse, bool) {
+	job, existing := s.backupJobs[UUID]
+	return job, existing
+}
+
+func (s *FileStore) SaveBackupJob(UUID string, job *httpBodies.BackupResponse) error {
+	s.backupJobs[UUID] = job
+	return s.writeJob(backupJobsDirectory, UUID, storedBackupJob{Id: UUID, Job: job})
+}
+
+func (s *FileStore) DeleteBackupJob(UUID string) error {
+	delete(s.backupJobs, UUID)
+	return s.removeJob(backupJobsDirectory, UUID)
+}
+
+func (s *FileStore) GetBackupJobs() map[string]*httpBodies.BackupResponse {
+	jobs := make(map[string]*httpBodies.BackupResponse, len(s.backupJobs))
+	for UUID, job := range s.backupJobs {
+		jobs[UUID] = job
+	}
+	return jobs
+}
+
+func (s *FileStore) GetRestoreJob(UUID string) (*httpBodies.RestoreResponse, bool) {
+	job, existing := s.restoreJobs[UUID]
+	return job, existing
+}
+
+func (s *FileStore) SaveRestoreJob(UUID string, job *httpBodies.RestoreResponse) error {
+	s.restoreJobs[UUID] = job
+	return s.writeJob(restoreJobsDirectory, UUID, storedRestoreJob{Id: UUID, Job: job})
+}
+
+func (s *FileStore) GetRestoreJobs() map[string]*httpBodies.RestoreResponse {
+	jobs := make(map[string]*httpBodies.RestoreResponse, len(s.restoreJobs))
+	for UUID, job := range s.restoreJobs {
+		jobs[UUID] = job
+	}
+	return jobs
+}
+
+func (s *FileStore) DeleteRestoreJob(UUID string) error {
+	delete(s.restoreJobs, UUID)
+	return s.removeJob(restoreJobsDirectory, UUID)
+}
+
+// getJobFilePath escapes the UUID, so that arbitrary ids can not point outside of the store's directory.
+func (s *FileStore) getJobFilePath(subDirectory, UUID string) string {
+	return filepath.Join(s.directory, subDirectory, url.PathEscape(UUID)+jobFileSuffix)
+}
+
+// writeJob writes the job into a temporary file first and renames it afterwards, so a crash never leaves a half written job behind.
+func (s *FileStore) writeJob(subDirectory, UUID string, stored interface{}) error {
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return errorlog.LogError("Serializing job ", UUID, " failed due to '", err.Error(), "'")
+	}
+
+	file, err := ioutil.TempFile(filepath.Join(s.directory, subDirectory), ".tmp-")
+	if err != nil {
+		return errorlog.LogError("Creating a file for job ", UUID, " failed due to '", err.Error(), "'")
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), s.getJobFilePath(subDirectory, UUID))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return errorlog.LogError("Persisting job ", UUID, " failed due to '", err.Error(), "'")
+	}
+	return nil
+}
+
+func (s *FileStore) removeJob(subDirectory, UUID string) error {
+	err := os.Remove(s.getJobFilePath(subDirectory, UUID))
+	if err != nil && !os.IsNotExist(err) {
+		return errorlog.LogError("Removing the file of job ", UUID, " failed due to '", err.Error(), "'")
+	}
+	return nil
+}
+
+func (s *FileStore) loadJobs(subDirectory string, load func(data []byte) error) error {
+	files, err := ioutil.ReadDir(filepath.Join(s.directory, subDirectory))
+	if err != nil {
+		return errorlog.LogError("Reading the job store directory failed due to '", err.Error(), "'")
+	}
+
+	for _, f := range files {
+		path := filepath.Join(s.directory, subDirectory, f.Name())
+		if f.IsDir() || !strings.HasSuffix(f.Name(), jobFileSuffix) {
+			if strings.HasPrefix(f.Name(), ".tmp-") {
+				log.Println("Removing leftover temporary job file", path)
+				os.Remove(path)
+			}
+			continue
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = load(data)
+		}
+		if err != nil {
+			// A single broken file should not prevent the agent from starting
+			errorlog.LogError("Skipping job file ", path, " due to '", err.Error(), "'")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package jobs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/evoila/osb-backup-agent/httpBodies"
+)
+
+func newTestFileStore(t *testing.T, directory string) *FileStore {
+	s, err := NewFileStore(directory)
+	if err != nil {
+		t.Fatalf("Creating the file store failed: %v", err)
+	}
+	return s
+}
+
+func newTestDirectory(t *testing.T) string {
+	directory, err := ioutil.TempDir("", "jobstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return directory
+}
+
+func TestFileStoreSurvivesReload(t *testing.T) {
+	directory := newTestDirectory(t)
+	defer os.RemoveAll(directory)
+
+	backupJob := &httpBodies.BackupResponse{Status: httpBodies.Status_success, State: "finished", Type: "S3", FileName: "backup.gz",
+		StartTime: "2024-01-02T03:04:05+00:00", EndTime: "2024-01-02T03:14:05+00:00",
+		Stages: []httpBodies.StageResult{{Name: "backup", Status: "SUCCEEDED", Stdout: "dumped"}}}
+	restoreJob := &httpBodies.RestoreResponse{Status: httpBodies.Status_running, State: "restore", Type: "SWIFT"}
+
+	s := newTestFileStore(t, directory)
+	if err := s.SaveBackupJob("backup-1", backupJob); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveBackupJob("backup-2", &httpBodies.BackupResponse{Status: httpBodies.Status_failed}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveRestoreJob("restore-1", restoreJob); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteBackupJob("backup-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := newTestFileStore(t, directory)
+	if jobs := reloaded.GetBackupJobs(); len(jobs) != 1 {
+		t.Fatalf("Expected 1 backup job after the reload, got %d", len(jobs))
+	}
+	job, exists := reloaded.GetBackupJob("backup-1")
+	if !exists || !reflect.DeepEqual(job, backupJob) {
+		t.Errorf("The backup job changed during the reload: %+v", job)
+	}
+	if _, exists = reloaded.GetBackupJob("backup-2"); exists {
+		t.Error("The deleted backup job was reloaded")
+	}
+	restored, exists := reloaded.GetRestoreJob("restore-1")
+	if !exists || !reflect.DeepEqual(restored, restoreJob) {
+		t.Errorf("The restore job changed during the reload: %+v", restored)
+	}
+}
+
+func TestFileStoreSkipsBrokenAndTemporaryFiles(t *testing.T) {
+	directory := newTestDirectory(t)
+	defer os.RemoveAll(directory)
+
+	s := newTestFileStore(t, directory)
+	if err := s.SaveBackupJob("backup-1", &httpBodies.BackupResponse{Status: httpBodies.Status_success}); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(directory, backupJobsDirectory, "broken"+jobFileSuffix)
+	if err := ioutil.WriteFile(broken, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	leftover := filepath.Join(directory, backupJobsDirectory, ".tmp-123")
+	if err := ioutil.WriteFile(leftover, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := newTestFileStore(t, directory)
+	if jobs := reloaded.GetBackupJobs(); len(jobs) != 1 {
+		t.Errorf("Expected only the valid backup job after the reload, got %d jobs", len(jobs))
+	}
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Error("The leftover temporary file was not removed")
+	}
+}
+
+func TestFileStoreKeepsJobsInsideItsDirectory(t *testing.T) {
+	directory := newTestDirectory(t)
+	defer os.RemoveAll(directory)
+
+	s := newTestFileStore(t, directory)
+	if err := s.SaveRestoreJob("../../escaped", &httpBodies.RestoreResponse{Status: httpBodies.Status_success}); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(directory, restoreJobsDirectory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected the job file in the restore directory, found %d files", len(files))
+	}
+
+	reloaded := newTestFileStore(t, directory)
+	if _, exists := reloaded.GetRestoreJob("../../escaped"); !exists {
+		t.Error("The job with the escaped id was not reloaded")
+	}
+}
@@ -0,0 +1,93 @@
+package jobs
+
+import (
+	"sort"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/evoila/osb-backup-agent/timeutil"
+)
+
+// Filter selects jobs by their attributes. Empty fields match every job.
+type Filter struct {
+	Status   string
+	State    string
+	Type     string
+	Database string
+	// From and To limit the start time of the jobs
+	From time.Time
+	To   time.Time
+}
+
+// BackupJobEntry is a backup job together with its UUID.
+type BackupJobEntry struct {
+	Id  string
+	Job *httpBodies.BackupResponse
+}
+
+// RestoreJobEntry is a restore job together with its UUID.
+type RestoreJobEntry struct {
+	Id  string
+	Job *httpBodies.RestoreResponse
+}
+
+// FindBackupJobs returns all backup jobs matching the filter, the most recently started first.
+func FindBackupJobs(filter Filter) []BackupJobEntry {
+	var entries []BackupJobEntry
+	for UUID, job := range GetBackupJobs() {
+		if filter.matches(job.Status, job.State, job.Type, job.Database, job.StartTime) {
+			entries = append(entries, BackupJobEntry{Id: UUID, Job: job})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return isLater(entries[i].Job.StartTime, entries[i].Id, entries[j].Job.StartTime, entries[j].Id)
+	})
+	return entries
+}
+
+// FindRestoreJobs returns all restore jobs matching the filter, the most recently started first.
+func FindRestoreJobs(filter Filter) []RestoreJobEntry {
+	var entries []RestoreJobEntry
+	for UUID, job := range GetRestoreJobs() {
+		if filter.matches(job.Status, job.State, job.Type, job.Database, job.StartTime) {
+			entries = append(entries, RestoreJobEntry{Id: UUID, Job: job})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return isLater(entries[i].Job.StartTime, entries[i].Id, entries[j].Job.StartTime, entries[j].Id)
+	})
+	return entries
+}
+
+func (f Filter) matches(status, state, destinationType, database, startTime string) bool {
+	if (f.Status != "" && f.Status != status) || (f.State != "" && f.State != state) ||
+		(f.Type != "" && f.Type != destinationType) || (f.Database != "" && f.Database != database) {
+		return false
+	}
+	if f.From.IsZero() && f.To.IsZero() {
+		return true
+	}
+
+	// Jobs that never started can not match a time range
+	started, err := timeutil.ParseTimestamp(startTime)
+	if err != nil {
+		return false
+	}
+	return (f.From.IsZero() || !started.Before(f.From)) && (f.To.IsZero() || !started.After(f.To))
+}
+
+// isLater sorts jobs by the given timestamps, while jobs without a timestamp come last and ties are sorted by UUID.
+func isLater(timestampA, UUIDA, timestampB, UUIDB string) bool {
+	a, errA := timeutil.ParseTimestamp(timestampA)
+	b, errB := timeutil.ParseTimestamp(timestampB)
+	if errA != nil || errB != nil {
+		if (errA == nil) != (errB == nil) {
+			return errA == nil
+		}
+		return UUIDA < UUIDB
+	}
+	if a.Equal(b) {
+		return UUIDA < UUIDB
+	}
+	return a.After(b)
+}
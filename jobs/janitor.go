@@ -0,0 +1,98 @@
+package jobs
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/configuration"
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/evoila/osb-backup-agent/timeutil"
+)
+
+// undatedJobs holds the time the janitor first saw finished jobs without any timestamp, whose age is counted from then on.
+// It is only accessed by the janitor.
+var undatedJobs = make(map[string]time.Time)
+
+// StartJanitor evicts finished jobs in the background according to job_retention_time and job_retention_count.
+// Running jobs are never evicted.
+func StartJanitor() {
+	maxAge := configuration.GetJobRetentionTime()
+	maxCount := configuration.GetJobRetentionCount()
+	if maxAge == 0 && maxCount == 0 {
+		log.Println("No job retention configured -> finished jobs are kept until they get deleted.")
+		return
+	}
+
+	interval := configuration.GetJobEvictionInterval()
+	log.Println("Evicting finished jobs every", interval)
+	go func() {
+		for range time.Tick(interval) {
+			EvictFinishedJobs(time.Now(), maxAge, maxCount)
+		}
+	}()
+}
+
+// EvictFinishedJobs removes all finished jobs that ended before now minus maxAge
+// as well as all finished jobs except for the maxCount most recent ones per job type. A value of 0 disables the respective limit.
+func EvictFinishedJobs(now time.Time, maxAge time.Duration, maxCount int) {
+	var undated = make(map[string]time.Time)
+
+	var finishedBackups []BackupJobEntry
+	for UUID, job := range GetBackupJobs() {
+		if isFinished(job.Status) {
+			finishedBackups = append(finishedBackups, BackupJobEntry{Id: UUID, Job: job})
+		}
+	}
+	sort.Slice(finishedBackups, func(i, j int) bool {
+		return isLater(finishedBackups[i].Job.EndTime, finishedBackups[i].Id, finishedBackups[j].Job.EndTime, finishedBackups[j].Id)
+	})
+	for i, entry := range finishedBackups {
+		if (maxCount > 0 && i >= maxCount) || isExpired(getEndTime("backup/"+entry.Id, entry.Job.EndTime, entry.Job.StartTime, now, undated), now, maxAge) {
+			log.Println("Evicting finished backup job", entry.Id)
+			RemoveBackupJob(entry.Id)
+		}
+	}
+
+	var finishedRestores []RestoreJobEntry
+	for UUID, job := range GetRestoreJobs() {
+		if isFinished(job.Status) {
+			finishedRestores = append(finishedRestores, RestoreJobEntry{Id: UUID, Job: job})
+		}
+	}
+	sort.Slice(finishedRestores, func(i, j int) bool {
+		return isLater(finishedRestores[i].Job.EndTime, finishedRestores[i].Id, finishedRestores[j].Job.EndTime, finishedRestores[j].Id)
+	})
+	for i, entry := range finishedRestores {
+		if (maxCount > 0 && i >= maxCount) || isExpired(getEndTime("restore/"+entry.Id, entry.Job.EndTime, entry.Job.StartTime, now, undated), now, maxAge) {
+			log.Println("Evicting finished restore job", entry.Id)
+			RemoveRestoreJob(entry.Id)
+		}
+	}
+	undatedJobs = undated
+}
+
+func isFinished(status string) bool {
+	return status == httpBodies.Status_success || status == httpBodies.Status_failed || status == httpBodies.Status_cancelled
+}
+
+// getEndTime returns the end time of a finished job. Jobs without an end time, for example because their body could not be deserialized,
+// fall back to their start time or, if they have none either, to the time the janitor first saw them, which is recorded in undated.
+func getEndTime(key, endTime, startTime string, now time.Time, undated map[string]time.Time) time.Time {
+	if ended, err := timeutil.ParseTimestamp(endTime); err == nil {
+		return ended
+	}
+	if started, err := timeutil.ParseTimestamp(startTime); err == nil {
+		return started
+	}
+	seen, exists := undatedJobs[key]
+	if !exists {
+		seen = now
+	}
+	undated[key] = seen
+	return seen
+}
+
+func isExpired(ended time.Time, now time.Time, maxAge time.Duration) bool {
+	return maxAge > 0 && now.Sub(ended) > maxAge
+}
@@ -0,0 +1,106 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/httpBodies"
+)
+
+var janitorTestNow = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func setUpJanitorTestJobs(t *testing.T) {
+	if err := SetUpJobStructure(); err != nil {
+		t.Fatal(err)
+	}
+	undatedJobs = make(map[string]time.Time)
+	var backupJobs = map[string]*httpBodies.BackupResponse{
+		"old":     {Status: httpBodies.Status_success, StartTime: "2024-02-01T10:00:00+00:00", EndTime: "2024-02-01T11:00:00+00:00"},
+		"recent":  {Status: httpBodies.Status_failed, StartTime: "2024-03-01T09:00:00+00:00", EndTime: "2024-03-01T10:00:00+00:00"},
+		"newest":  {Status: httpBodies.Status_cancelled, StartTime: "2024-03-01T10:30:00+01:00", EndTime: "2024-03-01T11:30:00+01:00"},
+		"running": {Status: httpBodies.Status_running, StartTime: "2024-01-01T10:00:00+00:00"},
+	}
+	for UUID, job := range backupJobs {
+		AddNewBackupJob(UUID)
+		UpdateBackupJob(UUID, job)
+	}
+	var restoreJobs = map[string]*httpBodies.RestoreResponse{
+		"old":    {Status: httpBodies.Status_success, EndTime: "2024-02-01T11:00:00+00:00"},
+		"recent": {Status: httpBodies.Status_success, EndTime: "2024-03-01T10:00:00+00:00"},
+	}
+	for UUID, job := range restoreJobs {
+		AddNewRestoreJob(UUID)
+		UpdateRestoreJob(UUID, job)
+	}
+}
+
+func assertBackupJobs(t *testing.T, expected ...string) {
+	remaining := GetBackupJobs()
+	if len(remaining) != len(expected) {
+		t.Errorf("Expected the backup jobs %v, got %d jobs", expected, len(remaining))
+	}
+	for _, UUID := range expected {
+		if _, exists := remaining[UUID]; !exists {
+			t.Errorf("Backup job %s was evicted", UUID)
+		}
+	}
+}
+
+func TestEvictionByAge(t *testing.T) {
+	setUpJanitorTestJobs(t)
+
+	EvictFinishedJobs(janitorTestNow, 24*time.Hour, 0)
+
+	assertBackupJobs(t, "recent", "newest", "running")
+	if _, exists := GetRestoreJob("old"); exists {
+		t.Error("The old restore job was not evicted")
+	}
+	if _, exists := GetRestoreJob("recent"); !exists {
+		t.Error("The recent restore job was evicted")
+	}
+}
+
+func TestEvictionByCount(t *testing.T) {
+	setUpJanitorTestJobs(t)
+
+	// The end time of the newest job is given in another offset and is half an hour later than the one of the recent job
+	EvictFinishedJobs(janitorTestNow, 0, 1)
+
+	assertBackupJobs(t, "newest", "running")
+	if jobs := GetRestoreJobs(); len(jobs) != 1 || jobs["recent"] == nil {
+		t.Errorf("Expected only the recent restore job, got %v", jobs)
+	}
+}
+
+func TestEvictionByAgeAndCount(t *testing.T) {
+	setUpJanitorTestJobs(t)
+
+	EvictFinishedJobs(janitorTestNow, 30*time.Minute, 2)
+
+	assertBackupJobs(t, "running")
+}
+
+func TestNoEvictionWithoutLimits(t *testing.T) {
+	setUpJanitorTestJobs(t)
+
+	EvictFinishedJobs(janitorTestNow, 0, 0)
+
+	assertBackupJobs(t, "old", "recent", "newest", "running")
+}
+
+func TestEvictionOfJobsWithoutEndTime(t *testing.T) {
+	setUpJanitorTestJobs(t)
+	AddNewBackupJob("invalid-body")
+	UpdateBackupJob("invalid-body", &httpBodies.BackupResponse{Status: httpBodies.Status_failed, State: "Body Deserialization"})
+	AddNewBackupJob("started")
+	UpdateBackupJob("started", &httpBodies.BackupResponse{Status: httpBodies.Status_failed, StartTime: "2024-02-01T10:00:00+00:00"})
+
+	EvictFinishedJobs(janitorTestNow, 24*time.Hour, 0)
+	assertBackupJobs(t, "recent", "newest", "running", "invalid-body")
+
+	// Jobs without any timestamp age from the first time the janitor saw them
+	EvictFinishedJobs(janitorTestNow.Add(23*time.Hour), 24*time.Hour, 0)
+	assertBackupJobs(t, "running", "invalid-body")
+	EvictFinishedJobs(janitorTestNow.Add(25*time.Hour), 24*time.Hour, 0)
+	assertBackupJobs(t, "running")
+}
@@ -12,22 +12,36 @@ import (
 var currentJobCount int
 var jobCountMutex mutex.Mutex
 
-var backupJobs map[string]*httpBodies.BackupResponse
+var store Store
 var backupMutex mutex.Mutex
-
-var restoreJobs map[string]*httpBodies.RestoreResponse
 var restoreMutex mutex.Mutex
 
-func SetUpJobStructure() {
+// SetUpJobStructure prepares the job counter, the mutexes and the job store configured via job_store.
+func SetUpJobStructure() error {
 	currentJobCount = 0
-	backupJobs = make(map[string]*httpBodies.BackupResponse)
-	restoreJobs = make(map[string]*httpBodies.RestoreResponse)
 	jobCountMutex = make(mutex.Mutex, 1)
 	backupMutex = make(mutex.Mutex, 1)
 	restoreMutex = make(mutex.Mutex, 1)
 	jobCountMutex.Release()
 	backupMutex.Release()
 	restoreMutex.Release()
+	setUpCancelStructure()
+
+	var err error
+	store, err = newStore(configuration.GetJobStoreType())
+	return err
+}
+
+func newStore(storeType string) (Store, error) {
+	switch storeType {
+	case StoreTypeMemory:
+		log.Println("Using an in-memory job store.")
+		return NewMemoryStore(), nil
+	case StoreTypeFile:
+		log.Println("Using a file based job store at", configuration.GetJobDirectory())
+		return NewFileStore(configuration.GetJobDirectory())
+	}
+	return nil, errorlog.LogError("Job store type '", storeType, "' is not supported")
 }
 
 func IncreaseCurrentJobCountWithCheck() bool {
@@ -57,7 +71,8 @@ func GetBackupJob(UUID string) (*httpBodies.BackupResponse, bool) {
 	log.Println("Accessing backup mutex for getting a job.")
 	backupMutex.Acquire()
 
-	job, existing := backupJobs[UUID]
+	job, existing := store.GetBackupJob(UUID)
+	job = copyBackupJob(job)
 
 	log.Println("Unlocking backup mutex after getting a job.")
 	backupMutex.Release()
@@ -65,6 +80,22 @@ func GetBackupJob(UUID string) (*httpBodies.BackupResponse, bool) {
 	return job, existing
 }
 
+// GetBackupJobs returns all backup jobs known to the agent, mapped by their UUID.
+func GetBackupJobs() map[string]*httpBodies.BackupResponse {
+	log.Println("Accessing backup mutex for getting all jobs.")
+	backupMutex.Acquire()
+
+	jobs := store.GetBackupJobs()
+	for UUID, job := range jobs {
+		jobs[UUID] = copyBackupJob(job)
+	}
+
+	log.Println("Unlocking backup mutex after getting all jobs.")
+	backupMutex.Release()
+
+	return jobs
+}
+
 func AddNewBackupJob(UUID string) (*httpBodies.BackupResponse, error) {
 	_, exists := GetBackupJob(UUID)
 	if exists {
@@ -75,12 +106,12 @@ func AddNewBackupJob(UUID string) (*httpBodies.BackupResponse, error) {
 	backupMutex.Acquire()
 
 	newJob := &httpBodies.BackupResponse{Status: "Running"}
-	backupJobs[UUID] = newJob
+	err := store.SaveBackupJob(UUID, copyBackupJob(newJob))
 
 	log.Println("Unlocking backup mutex after adding a new job.")
 	backupMutex.Release()
 
-	return newJob, nil
+	return newJob, err
 }
 
 func UpdateBackupJob(UUID string, job *httpBodies.BackupResponse) error {
@@ -92,12 +123,12 @@ func UpdateBackupJob(UUID string, job *httpBodies.BackupResponse) error {
 	log.Println("Accessing backup mutex for updating a job.")
 	backupMutex.Acquire()
 
-	backupJobs[UUID] = job
+	err := store.SaveBackupJob(UUID, copyBackupJob(job))
 
 	log.Println("Unlocking backup mutex after updating a job.")
 	backupMutex.Release()
 
-	return nil
+	return err
 }
 
 func RemoveBackupJob(UUID string) bool {
@@ -108,7 +139,7 @@ func RemoveBackupJob(UUID string) bool {
 	log.Println("Accessing backup mutex for deleting a job.")
 	backupMutex.Acquire()
 
-	delete(backupJobs, UUID)
+	store.DeleteBackupJob(UUID)
 
 	log.Println("Unlocking backup mutex after deleting a job.")
 	backupMutex.Release()
@@ -120,7 +151,8 @@ func GetRestoreJob(UUID string) (*httpBodies.RestoreResponse, bool) {
 	log.Println("Accessing restore mutex for getting a job.")
 	restoreMutex.Acquire()
 
-	job, existing := restoreJobs[UUID]
+	job, existing := store.GetRestoreJob(UUID)
+	job = copyRestoreJob(job)
 
 	log.Println("Unlocking restore mutex after getting a job.")
 	restoreMutex.Release()
@@ -128,6 +160,22 @@ func GetRestoreJob(UUID string) (*httpBodies.RestoreResponse, bool) {
 	return job, existing
 }
 
+// GetRestoreJobs returns all restore jobs known to the agent, mapped by their UUID.
+func GetRestoreJobs() map[string]*httpBodies.RestoreResponse {
+	log.Println("Accessing restore mutex for getting all jobs.")
+	restoreMutex.Acquire()
+
+	jobs := store.GetRestoreJobs()
+	for UUID, job := range jobs {
+		jobs[UUID] = copyRestoreJob(job)
+	}
+
+	log.Println("Unlocking restore mutex after getting all jobs.")
+	restoreMutex.Release()
+
+	return jobs
+}
+
 func AddNewRestoreJob(UUID string) (*httpBodies.RestoreResponse, error) {
 	_, exists := GetRestoreJob(UUID)
 	if exists {
@@ -138,12 +186,12 @@ func AddNewRestoreJob(UUID string) (*httpBodies.RestoreResponse, error) {
 	restoreMutex.Acquire()
 
 	newJob := &httpBodies.RestoreResponse{Status: "Running"}
-	restoreJobs[UUID] = newJob
+	err := store.SaveRestoreJob(UUID, copyRestoreJob(newJob))
 
 	log.Println("Unlocking restore mutex after adding a new job.")
 	restoreMutex.Release()
 
-	return newJob, nil
+	return newJob, err
 }
 
 func UpdateRestoreJob(UUID string, job *httpBodies.RestoreResponse) error {
@@ -156,11 +204,11 @@ func UpdateRestoreJob(UUID string, job *httpBodies.RestoreResponse) error {
 	log.Println("Accessing restore mutex for updating a job.")
 	restoreMutex.Acquire()
 
-	restoreJobs[UUID] = job
+	err := store.SaveRestoreJob(UUID, copyRestoreJob(job))
 
 	log.Println("Unlocking restore mutex after updating a job.")
 	restoreMutex.Release()
-	return nil
+	return err
 }
 
 func RemoveRestoreJob(UUID string) bool {
@@ -171,9 +219,30 @@ func RemoveRestoreJob(UUID string) bool {
 	log.Println("Accessing restore mutex for deleting a job.")
 	restoreMutex.Acquire()
 
-	delete(restoreJobs, UUID)
+	store.DeleteRestoreJob(UUID)
 
 	log.Println("Unlocking restore mutex after deleting a job.")
 	restoreMutex.Release()
 	return true
 }
+
+// copyBackupJob returns a copy of the job including its stages. The store only holds copies and hands out copies,
+// so a running job can change its response while other requests read or persist the stored one.
+func copyBackupJob(job *httpBodies.BackupResponse) *httpBodies.BackupResponse {
+	if job == nil {
+		return nil
+	}
+	copied := *job
+	copied.Stages = append([]httpBodies.StageResult(nil), job.Stages...)
+	return &copied
+}
+
+// copyRestoreJob returns a copy of the job including its stages, see copyBackupJob.
+func copyRestoreJob(job *httpBodies.RestoreResponse) *httpBodies.RestoreResponse {
+	if job == nil {
+		return nil
+	}
+	copied := *job
+	copied.Stages = append([]httpBodies.StageResult(nil), job.Stages...)
+	return &copied
+}
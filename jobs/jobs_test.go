@@ -0,0 +1,43 @@
+package jobs
+
+import (
+	"testing"
+
+	"github.com/evoila/osb-backup-agent/httpBodies"
+)
+
+func TestStoredJobsAreNotSharedWithTheCaller(t *testing.T) {
+	if err := SetUpJobStructure(); err != nil {
+		t.Fatal(err)
+	}
+	job, err := AddNewBackupJob("backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Stages = []httpBodies.StageResult{{Name: "backup", Status: httpBodies.Status_running}}
+	UpdateBackupJob("backup", job)
+
+	// Changing the job after the update must not change the stored job, which other requests read without the job
+	job.State = "upload"
+	job.Stages[0].Status = httpBodies.Status_success
+	stored, _ := GetBackupJob("backup")
+	if stored.State != "" || stored.Stages[0].Status != httpBodies.Status_running {
+		t.Errorf("The stored job changed without an update: %+v", stored)
+	}
+
+	stored.Stages[0].Status = httpBodies.Status_failed
+	if stored, _ = GetBackupJobs()["backup"]; stored.Stages[0].Status != httpBodies.Status_running {
+		t.Errorf("Changing a returned job changed the stored job: %+v", stored)
+	}
+
+	restoreJob, err := AddNewRestoreJob("restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoreJob.Stages = []httpBodies.StageResult{{Name: "restore", Status: httpBodies.Status_running}}
+	UpdateRestoreJob("restore", restoreJob)
+	restoreJob.Stages[0].Status = httpBodies.Status_success
+	if storedRestoreJob, _ := GetRestoreJob("restore"); storedRestoreJob.Stages[0].Status != httpBodies.Status_running {
+		t.Errorf("The stored restore job changed without an update: %+v", storedRestoreJob)
+	}
+}
@@ -0,0 +1,64 @@
+package jobs
+
+import (
+	"github.com/evoila/osb-backup-agent/httpBodies"
+)
+
+// MemoryStore keeps all jobs in maps, which are lost when the agent stops.
+type MemoryStore struct {
+	backupJobs  map[string]*httpBodies.BackupResponse
+	restoreJobs map[string]*httpBodies.RestoreResponse
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		backupJobs:  make(map[string]*httpBodies.BackupResponse),
+		restoreJobs: make(map[string]*httpBodies.RestoreResponse),
+	}
+}
+
+func (s *MemoryStore) GetBackupJob(UUID string) (*httpBodies.BackupResponse, bool) {
+	job, existing := s.backupJobs[UUID]
+	return job, existing
+}
+
+func (s *MemoryStore) SaveBackupJob(UUID string, job *httpBodies.BackupResponse) error {
+	s.backupJobs[UUID] = job
+	return nil
+}
+
+func (s *MemoryStore) DeleteBackupJob(UUID string) error {
+	delete(s.backupJobs, UUID)
+	return nil
+}
+
+func (s *MemoryStore) GetBackupJobs() map[string]*httpBodies.BackupResponse {
+	jobs := make(map[string]*httpBodies.BackupResponse, len(s.backupJobs))
+	for UUID, job := range s.backupJobs {
+		jobs[UUID] = job
+	}
+	return jobs
+}
+
+func (s *MemoryStore) GetRestoreJob(UUID string) (*httpBodies.RestoreResponse, bool) {
+	job, existing := s.restoreJobs[UUID]
+	return job, existing
+}
+
+func (s *MemoryStore) SaveRestoreJob(UUID string, job *httpBodies.RestoreResponse) error {
+	s.restoreJobs[UUID] = job
+	return nil
+}
+
+func (s *MemoryStore) GetRestoreJobs() map[string]*httpBodies.RestoreResponse {
+	jobs := make(map[string]*httpBodies.RestoreResponse, len(s.restoreJobs))
+	for UUID, job := range s.restoreJobs {
+		jobs[UUID] = job
+	}
+	return jobs
+}
+
+func (s *MemoryStore) DeleteRestoreJob(UUID string) error {
+	delete(s.restoreJobs, UUID)
+	return nil
+}
@@ -0,0 +1,26 @@
+package jobs
+
+import (
+	"github.com/evoila/osb-backup-agent/httpBodies"
+)
+
+// StoreTypeMemory : Name of the job store that only keeps jobs in memory
+const StoreTypeMemory = "memory"
+
+// StoreTypeFile : Name of the job store that persists jobs as files on disk
+const StoreTypeFile = "file"
+
+// Store holds the backup and restore jobs of the agent.
+// Implementations do not need to be safe for concurrent use, as the jobs package serialises all access via its mutexes
+// and only passes copies of the jobs in and out.
+type Store interface {
+	GetBackupJob(UUID string) (*httpBodies.BackupResponse, bool)
+	SaveBackupJob(UUID string, job *httpBodies.BackupResponse) error
+	DeleteBackupJob(UUID string) error
+	GetBackupJobs() map[string]*httpBodies.BackupResponse
+
+	GetRestoreJob(UUID string) (*httpBodies.RestoreResponse, bool)
+	SaveRestoreJob(UUID string, job *httpBodies.RestoreResponse) error
+	DeleteRestoreJob(UUID string) error
+	GetRestoreJobs() map[string]*httpBodies.RestoreResponse
+}
@@ -0,0 +1,203 @@
+package local
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/evoila/osb-backup-agent/configuration"
+	"github.com/evoila/osb-backup-agent/errorlog"
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/evoila/osb-backup-agent/storage"
+)
+
+// Type : Name of the destination type handled by this backend
+const Type = "LOCAL"
+
+const tempFilePrefix = ".tmp-"
+
+// Backend stores backups in a directory of the local file system, for example a mounted NFS share.
+// Every destination uses its own subdirectory of the configured base directory.
+type Backend struct{}
+
+func (b Backend) Validate(destination httpBodies.DestinationInformation, fileCanBeMissing bool) string {
+	missingFields := ""
+	if configuration.GetLocalDestinationDirectory() == "" {
+		missingFields += " directory_local_destination (agent configuration)"
+	}
+	if destination.Path == "" {
+		missingFields += " path"
+	} else if _, err := getDirectory(destination); err != nil {
+		missingFields += " valid path"
+	}
+	if destination.Filename == "" && !fileCanBeMissing {
+		missingFields += " filename"
+	} else if destination.Filename != "" && !isValidFileName(destination.Filename) {
+		missingFields += " valid filename"
+	}
+	return missingFields
+}
+
+// Redact returns the fields of the LOCAL destination with its credentials replaced.
+func (b Backend) Redact(destination httpBodies.DestinationInformation) httpBodies.DestinationInformation {
+	return httpBodies.DestinationInformation{
+		Type:     destination.Type,
+		Path:     destination.Path,
+		Filename: destination.Filename,
+	}
+}
+
+func (b Backend) Upload(ctx context.Context, destination httpBodies.DestinationInformation, name string, reader io.Reader, size int64) (storage.ObjectInfo, error) {
+	path, err := getFilePath(destination, name)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+
+	directory := filepath.Dir(path)
+	if err = os.MkdirAll(directory, 0700); err != nil {
+		return storage.ObjectInfo{}, errorlog.LogError("Creating the directory ", directory, " failed due to '", err.Error(), "'")
+	}
+
+	// Writing into a temporary file first ensures that an interrupted upload never leaves a partial backup under the final name
+	file, err := ioutil.TempFile(directory, tempFilePrefix)
+	if err != nil {
+		return storage.ObjectInfo{}, errorlog.LogError("Creating a temporary file in ", directory, " failed due to '", err.Error(), "'")
+	}
+
+	log.Println("Writing", name, "to", directory)
+	written, err := io.Copy(file, storage.NewContextReader(ctx, reader))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return storage.ObjectInfo{}, errorlog.LogError("Writing ", path, " failed due to '", err.Error(), "'")
+	}
+	syncDirectory(directory)
+	log.Println("Successfully wrote", path, "(", written, "bytes )")
+
+	return b.Stat(ctx, destination, name)
+}
+
+func (b Backend) Download(ctx context.Context, destination httpBodies.DestinationInformation, name string, writer io.Writer) (int64, error) {
+	path, err := getFilePath(destination, name)
+	if err != nil {
+		return 0, err
+	}
+
+	log.Println("Reading", path)
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, errorlog.LogError("Failed to open file ", path, " due to '", err.Error(), "'")
+	}
+	defer file.Close()
+
+	written, err := io.Copy(storage.NewContextWriter(ctx, writer), file)
+	if err != nil {
+		return written, errorlog.LogError("Failed to read the file ", path, " due to '", err.Error(), "'")
+	}
+	return written, nil
+}
+
+func (b Backend) Stat(ctx context.Context, destination httpBodies.DestinationInformation, name string) (storage.ObjectInfo, error) {
+	path, err := getFilePath(destination, name)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return storage.ObjectInfo{}, errorlog.LogError("Accessing file stats of ", path, " failed due to '", err.Error(), "'")
+	}
+	return storage.ObjectInfo{Name: name, Size: stat.Size(), LastModified: stat.ModTime()}, nil
+}
+
+func (b Backend) List(ctx context.Context, destination httpBodies.DestinationInformation, prefix string) ([]storage.ObjectInfo, error) {
+	directory, err := getDirectory(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(directory)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errorlog.LogError("Reading the directory ", directory, " failed due to '", err.Error(), "'")
+	}
+
+	var objects []storage.ObjectInfo
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), tempFilePrefix) || !strings.HasPrefix(f.Name(), prefix) {
+			continue
+		}
+		objects = append(objects, storage.ObjectInfo{Name: f.Name(), Size: f.Size(), LastModified: f.ModTime()})
+	}
+	return objects, nil
+}
+
+func (b Backend) Delete(ctx context.Context, destination httpBodies.DestinationInformation, name string) error {
+	path, err := getFilePath(destination, name)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(path); err != nil {
+		return errorlog.LogError("Removing ", path, " failed due to '", err.Error(), "'")
+	}
+	return nil
+}
+
+// getDirectory returns the directory of the destination and makes sure it lies within the base directory.
+func getDirectory(destination httpBodies.DestinationInformation) (string, error) {
+	baseDirectory := configuration.GetLocalDestinationDirectory()
+	if baseDirectory == "" {
+		return "", errorlog.LogError("No base directory for the LOCAL destination type is configured")
+	}
+	baseDirectory = filepath.Clean(baseDirectory)
+
+	directory := filepath.Join(baseDirectory, destination.Path)
+	relative, err := filepath.Rel(baseDirectory, directory)
+	if err != nil || relative == "." || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", errorlog.LogError("The path '", destination.Path, "' does not point to a subdirectory of the base directory")
+	}
+	return directory, nil
+}
+
+// getFilePath returns the path of the file with the given name within the directory of the destination.
+func getFilePath(destination httpBodies.DestinationInformation, name string) (string, error) {
+	if !isValidFileName(name) {
+		return "", errorlog.LogError("The filename '", name, "' is not a valid name of a file")
+	}
+	directory, err := getDirectory(destination)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(directory, name), nil
+}
+
+// isValidFileName only allows plain names, so a file can not be read or written outside of its directory.
+func isValidFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\") && !strings.HasPrefix(name, tempFilePrefix)
+}
+
+// syncDirectory persists the rename of a file. Failures are only logged, as not every file system supports it.
+func syncDirectory(directory string) {
+	dir, err := os.Open(directory)
+	if err != nil {
+		return
+	}
+	defer dir.Close()
+	if err = dir.Sync(); err != nil {
+		log.Println("Syncing the directory", directory, "failed due to", err.Error())
+	}
+}
@@ -0,0 +1,195 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/evoila/osb-backup-agent/httpBodies"
+)
+
+// setUpBaseDirectory creates a base directory within a new parent directory, so files written outside of the base directory can be detected.
+// The caller has to remove the returned parent directory.
+func setUpBaseDirectory(t *testing.T) (string, string) {
+	parent, err := ioutil.TempDir("", "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Join(parent, "base")
+	if err = os.Mkdir(base, 0700); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("directory_local_destination", base)
+	return parent, base
+}
+
+func getDestination(path string) httpBodies.DestinationInformation {
+	return httpBodies.DestinationInformation{Type: Type, Path: path}
+}
+
+// getFileNames returns the names of all files below the directory, relative to it.
+func getFileNames(t *testing.T, directory string) []string {
+	var names []string
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			relative, _ := filepath.Rel(directory, path)
+			names = append(names, relative)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+// failingReader returns the data and fails afterwards, like a backup script that dies during the upload.
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("script failed")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestPathTraversalIsRejected(t *testing.T) {
+	parent, _ := setUpBaseDirectory(t)
+	defer os.RemoveAll(parent)
+	backend := Backend{}
+
+	tests := []struct {
+		name     string
+		path     string
+		filename string
+		valid    bool
+	}{
+		{"instance directory", "instance", "backup.sql", true},
+		{"nested directory", "org/instance", "backup.sql", true},
+		{"cleaned nested directory", "org/../instance", "backup.sql", true},
+		{"parent directory", "..", "backup.sql", false},
+		{"sibling directory", "../other", "backup.sql", false},
+		{"nested parent directory", "instance/../../other", "backup.sql", false},
+		{"base directory", ".", "backup.sql", false},
+		{"base directory via subdirectory", "instance/..", "backup.sql", false},
+		{"filename with directory", "instance", "../backup.sql", false},
+		{"filename with backslash", "instance", "..\\backup.sql", false},
+		{"parent as filename", "instance", "..", false},
+		{"temporary file as filename", "instance", tempFilePrefix + "backup", false},
+	}
+	for _, test := range tests {
+		destination := getDestination(test.path)
+		destination.Filename = test.filename
+		if missingFields := backend.Validate(destination, false); (missingFields == "") != test.valid {
+			t.Errorf("%s: expected the destination to be valid: %t, got the missing fields %q", test.name, test.valid, missingFields)
+		}
+
+		_, uploadErr := backend.Upload(context.Background(), destination, test.filename, strings.NewReader("data"), 4)
+		_, statErr := backend.Stat(context.Background(), destination, test.filename)
+		_, downloadErr := backend.Download(context.Background(), destination, test.filename, ioutil.Discard)
+		deleteErr := backend.Delete(context.Background(), destination, test.filename)
+		if test.valid && (uploadErr != nil || statErr != nil || downloadErr != nil || deleteErr != nil) {
+			t.Errorf("%s: expected the transfers to succeed, got %v, %v, %v and %v", test.name, uploadErr, statErr, downloadErr, deleteErr)
+		}
+		if !test.valid && (uploadErr == nil || statErr == nil || downloadErr == nil || deleteErr == nil) {
+			t.Errorf("%s: expected all transfers to be rejected, got %v, %v, %v and %v", test.name, uploadErr, statErr, downloadErr, deleteErr)
+		}
+	}
+
+	for _, name := range getFileNames(t, parent) {
+		t.Errorf("The file %s was left behind or written outside of its directory", name)
+	}
+}
+
+func TestUploadRenamesTheTemporaryFile(t *testing.T) {
+	parent, base := setUpBaseDirectory(t)
+	defer os.RemoveAll(parent)
+	backend := Backend{}
+	destination := getDestination("instance")
+
+	info, err := backend.Upload(context.Background(), destination, "backup.sql", strings.NewReader("backup data"), 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "backup.sql" || info.Size != 11 {
+		t.Errorf("Unexpected information about the uploaded file: %+v", info)
+	}
+	if names := getFileNames(t, base); len(names) != 1 || names[0] != filepath.Join("instance", "backup.sql") {
+		t.Errorf("Expected only the uploaded file, got %v", names)
+	}
+
+	var downloaded bytes.Buffer
+	if size, err := backend.Download(context.Background(), destination, "backup.sql", &downloaded); err != nil || size != 11 || downloaded.String() != "backup data" {
+		t.Errorf("Expected to download the uploaded data, got %q, %d and %v", downloaded.String(), size, err)
+	}
+}
+
+func TestFailedUploadKeepsTheExistingFile(t *testing.T) {
+	parent, base := setUpBaseDirectory(t)
+	defer os.RemoveAll(parent)
+	backend := Backend{}
+	destination := getDestination("instance")
+
+	if _, err := backend.Upload(context.Background(), destination, "backup.sql", strings.NewReader("old backup"), 10); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for name, upload := range map[string]func() error{
+		"failing reader": func() error {
+			_, err := backend.Upload(context.Background(), destination, "backup.sql", &failingReader{data: []byte("partial")}, 100)
+			return err
+		},
+		"cancelled context": func() error {
+			_, err := backend.Upload(cancelled, destination, "backup.sql", strings.NewReader("new backup"), 10)
+			return err
+		},
+	} {
+		if err := upload(); err == nil {
+			t.Errorf("%s: expected the upload to fail", name)
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(base, "instance", "backup.sql")); err != nil || string(data) != "old backup" {
+			t.Errorf("%s: expected the existing file to be kept, got %q and %v", name, data, err)
+		}
+		if names := getFileNames(t, base); len(names) != 1 {
+			t.Errorf("%s: expected the temporary file to be removed, got %v", name, names)
+		}
+	}
+}
+
+func TestListSkipsTemporaryFiles(t *testing.T) {
+	parent, base := setUpBaseDirectory(t)
+	defer os.RemoveAll(parent)
+	backend := Backend{}
+	destination := getDestination("instance")
+
+	if _, err := backend.Upload(context.Background(), destination, "backup.sql", strings.NewReader("data"), 4); err != nil {
+		t.Fatal(err)
+	}
+	// A temporary file of an upload that is still running or was interrupted by a crash of the agent
+	if err := ioutil.WriteFile(filepath.Join(base, "instance", tempFilePrefix+"123"), []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := backend.List(context.Background(), destination, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Name != "backup.sql" || objects[0].Size != 4 {
+		t.Errorf("Expected only the uploaded file to be listed, got %+v", objects)
+	}
+
+	if objects, err = backend.List(context.Background(), getDestination("missing"), ""); err != nil || len(objects) != 0 {
+		t.Errorf("Expected an empty listing of a missing directory, got %+v and %v", objects, err)
+	}
+}
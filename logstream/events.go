@@ -0,0 +1,84 @@
+package logstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/evoila/osb-backup-agent/httpBodies"
+)
+
+// ServeEvents writes the output of the job identified by the key as server-sent events.
+// Every line is sent as a "log" event and a final "end" event carries the status of the job.
+// If the job is running, the lines written so far are sent and, with follow set, all further lines until the job ends.
+// If the job is not running, the lines of the stored stages are sent instead.
+func ServeEvents(w http.ResponseWriter, r *http.Request, key string, follow bool, stages func() []httpBodies.StageResult, status func() string) {
+	flusher, canFlush := w.(http.Flusher)
+	if !canFlush {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(200)
+
+	stream, running := Get(key)
+	if !running {
+		for _, line := range getLinesOfStages(stages()) {
+			writeEvent(w, "log", line)
+		}
+		writeEvent(w, "end", map[string]string{"status": status()})
+		flusher.Flush()
+		return
+	}
+
+	history, subscriber := stream.Subscribe()
+	defer stream.Unsubscribe(subscriber)
+
+	for _, line := range history {
+		writeEvent(w, "log", line)
+	}
+	flusher.Flush()
+
+	if follow {
+		for {
+			select {
+			case line, open := <-subscriber:
+				if !open {
+					writeEvent(w, "end", map[string]string{"status": status()})
+					flusher.Flush()
+					return
+				}
+				writeEvent(w, "log", line)
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+	writeEvent(w, "end", map[string]string{"status": status()})
+	flusher.Flush()
+}
+
+func getLinesOfStages(stages []httpBodies.StageResult) []Line {
+	var lines []Line
+	for _, stage := range stages {
+		for _, output := range []struct{ name, text string }{{"stdout", stage.Stdout}, {"stderr", stage.Stderr}} {
+			if output.text == "" {
+				continue
+			}
+			for _, text := range strings.Split(strings.TrimSuffix(output.text, "\n"), "\n") {
+				lines = append(lines, Line{Stage: stage.Name, Stream: output.name, Text: text, Time: stage.EndTime})
+			}
+		}
+	}
+	return lines
+}
+
+func writeEvent(w http.ResponseWriter, event string, data interface{}) {
+	encoded, _ := json.Marshal(data)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, encoded)
+}
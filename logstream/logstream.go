@@ -0,0 +1,197 @@
+package logstream
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/mutex"
+	"github.com/evoila/osb-backup-agent/timeutil"
+)
+
+// maxHistoryLines : Number of lines a stream keeps for followers that connect later
+const maxHistoryLines = 10000
+
+// subscriberBufferSize : Number of lines buffered per follower, further lines are dropped for slow followers
+const subscriberBufferSize = 1024
+
+// Line is a single line of output of a stage's script.
+type Line struct {
+	Stage  string `json:"stage"`
+	Stream string `json:"stream"`
+	Text   string `json:"line"`
+	Time   string `json:"time"`
+}
+
+// Stream collects the output of a running job and hands it to its followers.
+type Stream struct {
+	lock        mutex.Mutex
+	lines       []Line
+	subscribers map[chan Line]bool
+	closed      bool
+}
+
+type contextKey struct{}
+
+var streams map[string]*Stream
+var streamsMutex mutex.Mutex
+
+// SetUpLogStreams has to be called once before any stream is started.
+func SetUpLogStreams() {
+	streams = make(map[string]*Stream)
+	streamsMutex = make(mutex.Mutex, 1)
+	streamsMutex.Release()
+}
+
+// Start creates a new stream for the job identified by the key and replaces any existing one.
+func Start(key string) *Stream {
+	stream := &Stream{lock: make(mutex.Mutex, 1), subscribers: make(map[chan Line]bool)}
+	stream.lock.Release()
+
+	streamsMutex.Acquire()
+	streams[key] = stream
+	streamsMutex.Release()
+	return stream
+}
+
+// Get returns the stream of a running job.
+func Get(key string) (*Stream, bool) {
+	streamsMutex.Acquire()
+	stream, exists := streams[key]
+	streamsMutex.Release()
+	return stream, exists
+}
+
+// Finish closes the stream of the job, which ends all followers, and removes it.
+func Finish(key string) {
+	streamsMutex.Acquire()
+	stream, exists := streams[key]
+	delete(streams, key)
+	streamsMutex.Release()
+
+	if exists {
+		stream.close()
+	}
+}
+
+// NewContext returns a context that carries the stream to the scripts run with it.
+func NewContext(ctx context.Context, stream *Stream) context.Context {
+	return context.WithValue(ctx, contextKey{}, stream)
+}
+
+// FromContext returns the stream carried by the context, if any.
+func FromContext(ctx context.Context) (*Stream, bool) {
+	stream, exists := ctx.Value(contextKey{}).(*Stream)
+	return stream, exists
+}
+
+// Subscribe returns the lines written so far and a channel for the following ones, which is closed when the stream ends.
+// Call Unsubscribe with the channel once it is not read anymore.
+func (s *Stream) Subscribe() ([]Line, chan Line) {
+	s.lock.Acquire()
+	defer s.lock.Release()
+
+	history := make([]Line, len(s.lines))
+	copy(history, s.lines)
+
+	subscriber := make(chan Line, subscriberBufferSize)
+	if s.closed {
+		close(subscriber)
+	} else {
+		s.subscribers[subscriber] = true
+	}
+	return history, subscriber
+}
+
+func (s *Stream) Unsubscribe(subscriber chan Line) {
+	s.lock.Acquire()
+	defer s.lock.Release()
+
+	if s.subscribers[subscriber] {
+		delete(s.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// Writer returns a writer that publishes everything written to it line by line.
+// Call Flush on it after the script finished to publish an incomplete last line.
+func (s *Stream) Writer(stage, stream string) *LineWriter {
+	return &LineWriter{stream: s, stage: stage, name: stream}
+}
+
+func (s *Stream) publish(line Line) {
+	s.lock.Acquire()
+	defer s.lock.Release()
+
+	if s.closed {
+		return
+	}
+	s.lines = append(s.lines, line)
+	if len(s.lines) > maxHistoryLines {
+		s.lines = s.lines[len(s.lines)-maxHistoryLines:]
+	}
+
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- line:
+		default:
+			log.Println("Dropping a log line for a slow follower.")
+		}
+	}
+}
+
+func (s *Stream) close() {
+	s.lock.Acquire()
+	defer s.lock.Release()
+
+	s.closed = true
+	for subscriber := range s.subscribers {
+		delete(s.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// LineWriter splits the written output into lines and publishes them to a stream.
+type LineWriter struct {
+	stream  *Stream
+	stage   string
+	name    string
+	partial []byte
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		index := bytes.IndexByte(w.partial, '\n')
+		if index < 0 {
+			break
+		}
+		w.publish(string(w.partial[:index]))
+		w.partial = w.partial[index+1:]
+	}
+	return len(p), nil
+}
+
+// Flush publishes the last line, even if it did not end with a line break.
+func (w *LineWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.publish(string(w.partial))
+		w.partial = nil
+	}
+}
+
+func (w *LineWriter) publish(text string) {
+	now := time.Now()
+	w.stream.publish(Line{Stage: w.stage, Stream: w.name, Text: text, Time: timeutil.GetTimestamp(&now)})
+}
+
+// BackupJobKey returns the key of the stream of a backup job.
+func BackupJobKey(UUID string) string {
+	return "backup/" + UUID
+}
+
+// RestoreJobKey returns the key of the stream of a restore job.
+func RestoreJobKey(UUID string) string {
+	return "restore/" + UUID
+}
@@ -0,0 +1,214 @@
+package logstream
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/httpBodies"
+)
+
+const testKey = "backup/job"
+
+type event struct {
+	name string
+	data string
+}
+
+// serveTestEvents serves the events of the test job with the given stored stages.
+// The job is reported as running while its stream exists and with the final status afterwards.
+func serveTestEvents(stages []httpBodies.StageResult, finalStatus string) *httptest.Server {
+	status := func() string {
+		if _, running := Get(testKey); running {
+			return httpBodies.Status_running
+		}
+		return finalStatus
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		follow := r.URL.Query().Get("follow") == "true"
+		ServeEvents(w, r, testKey, follow, func() []httpBodies.StageResult { return stages }, status)
+	}))
+}
+
+// readEvents reads the events of the response into the channel and closes it, once the response ends.
+func readEvents(t *testing.T, url string) chan event {
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType := response.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %s", contentType)
+	}
+
+	events := make(chan event, 100)
+	go func() {
+		defer response.Body.Close()
+		defer close(events)
+		reader := bufio.NewReader(response.Body)
+		var current event
+		for {
+			text, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			text = strings.TrimSuffix(text, "\n")
+			switch {
+			case strings.HasPrefix(text, "event: "):
+				current.name = strings.TrimPrefix(text, "event: ")
+			case strings.HasPrefix(text, "data: "):
+				current.data = strings.TrimPrefix(text, "data: ")
+			case text == "":
+				events <- current
+				current = event{}
+			}
+		}
+	}()
+	return events
+}
+
+func nextEvent(t *testing.T, events chan event) event {
+	select {
+	case next, open := <-events:
+		if !open {
+			t.Fatal("The stream ended unexpectedly")
+		}
+		return next
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event was received")
+	}
+	return event{}
+}
+
+func assertLogEvent(t *testing.T, received event, stage, stream, text string) {
+	var line Line
+	if received.name != "log" || json.Unmarshal([]byte(received.data), &line) != nil {
+		t.Fatalf("Expected a log event, got %+v", received)
+	}
+	if line.Stage != stage || line.Stream != stream || line.Text != text {
+		t.Errorf("Expected the line %q of %s %s, got %+v", text, stage, stream, line)
+	}
+}
+
+func assertEndEvent(t *testing.T, events chan event, status string) {
+	if received := nextEvent(t, events); received.name != "end" || received.data != fmt.Sprintf("{\"status\":%q}", status) {
+		t.Errorf("Expected the end event with the status %s, got %+v", status, received)
+	}
+	select {
+	case received, open := <-events:
+		if open {
+			t.Errorf("Expected the stream to end after the end event, got %+v", received)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("The stream was not closed after the end event")
+	}
+}
+
+// waitForSubscribers waits until the stream has the number of followers.
+func waitForSubscribers(t *testing.T, stream *Stream, count int) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		stream.lock.Acquire()
+		subscribers := len(stream.subscribers)
+		stream.lock.Release()
+		if subscribers == count {
+			return
+		}
+	}
+	t.Fatalf("Expected %d followers of the stream", count)
+}
+
+func TestLineWriterSplitsLines(t *testing.T) {
+	SetUpLogStreams()
+	stream := Start(testKey)
+	defer Finish(testKey)
+
+	writer := stream.Writer("backup", "stdout")
+	writer.Write([]byte("first\nsec"))
+	writer.Write([]byte("ond\n\nla"))
+	writer.Write([]byte("st"))
+	writer.Flush()
+
+	history, subscriber := stream.Subscribe()
+	defer stream.Unsubscribe(subscriber)
+	var texts []string
+	for _, line := range history {
+		texts = append(texts, line.Text)
+	}
+	if strings.Join(texts, "|") != "first|second||last" {
+		t.Errorf("Unexpected lines %q", texts)
+	}
+}
+
+func TestEventsOfAFinishedJob(t *testing.T) {
+	SetUpLogStreams()
+	server := serveTestEvents([]httpBodies.StageResult{
+		{Name: "pre-backup-lock", Stdout: "locking\nlocked\n", Stderr: "warning\n"},
+		{Name: "backup"},
+	}, httpBodies.Status_success)
+	defer server.Close()
+
+	events := readEvents(t, server.URL+"?follow=true")
+	assertLogEvent(t, nextEvent(t, events), "pre-backup-lock", "stdout", "locking")
+	assertLogEvent(t, nextEvent(t, events), "pre-backup-lock", "stdout", "locked")
+	assertLogEvent(t, nextEvent(t, events), "pre-backup-lock", "stderr", "warning")
+	assertEndEvent(t, events, httpBodies.Status_success)
+}
+
+func TestEventsOfARunningJobWithoutFollow(t *testing.T) {
+	SetUpLogStreams()
+	stream := Start(testKey)
+	defer Finish(testKey)
+	stream.Writer("backup", "stdout").Write([]byte("written before\n"))
+
+	server := serveTestEvents(nil, httpBodies.Status_success)
+	defer server.Close()
+
+	events := readEvents(t, server.URL)
+	assertLogEvent(t, nextEvent(t, events), "backup", "stdout", "written before")
+	assertEndEvent(t, events, httpBodies.Status_running)
+	waitForSubscribers(t, stream, 0)
+}
+
+func TestFollowedEventsEndWithTheJob(t *testing.T) {
+	SetUpLogStreams()
+	stream := Start(testKey)
+	writer := stream.Writer("backup", "stderr")
+	writer.Write([]byte("written before\n"))
+
+	server := serveTestEvents(nil, httpBodies.Status_success)
+	defer server.Close()
+
+	events := readEvents(t, server.URL+"?follow=true")
+	assertLogEvent(t, nextEvent(t, events), "backup", "stderr", "written before")
+	waitForSubscribers(t, stream, 1)
+
+	writer.Write([]byte("written while following\n"))
+	assertLogEvent(t, nextEvent(t, events), "backup", "stderr", "written while following")
+
+	Finish(testKey)
+	assertEndEvent(t, events, httpBodies.Status_success)
+	if _, running := Get(testKey); running {
+		t.Error("The stream of the finished job was not removed")
+	}
+}
+
+func TestDisconnectedFollowerIsUnsubscribed(t *testing.T) {
+	SetUpLogStreams()
+	stream := Start(testKey)
+	defer Finish(testKey)
+
+	server := serveTestEvents(nil, httpBodies.Status_success)
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "?follow=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForSubscribers(t, stream, 1)
+	response.Body.Close()
+	waitForSubscribers(t, stream, 0)
+}
@@ -23,15 +23,37 @@ func PrintOutConfig() {
 	var port = configuration.GetPort()
 	var backupDirectory = configuration.GetBackupDirectory()
 	var restoreDirectory = configuration.GetRestoreDirectory()
+	var localDestinationDirectory = configuration.GetLocalDestinationDirectory()
+	var gcsCredentialsFile = configuration.GetGCSCredentialsFile()
 	var scriptsPath = configuration.GetScriptsPath()
 	var allowedToDeleteFiles = configuration.IsAllowedToDeleteFiles()
+	var jobStore = configuration.GetJobStoreType()
+	var jobDirectory = configuration.GetJobDirectory()
+	var recoveryPolicy = configuration.GetRecoveryPolicy()
+	var jobTimeout = configuration.GetJobTimeout()
+	var stageTimeouts = configuration.GetStageTimeouts()
+	var customStages = configuration.GetCustomStages()
+	var jobRetentionTime = configuration.GetJobRetentionTime()
+	var jobRetentionCount = configuration.GetJobRetentionCount()
+	var jobEvictionInterval = configuration.GetJobEvictionInterval()
 	log.Println("Using following configuration: ",
 		"\nclient_username :", username,
 		"\nclient_password :", pw,
 		"\nclient_port :", port,
 		"\ndirectory_backup :", backupDirectory,
 		"\ndirectory_restore :", restoreDirectory,
+		"\ndirectory_local_destination :", localDestinationDirectory,
+		"\ngcs_credentials_file :", gcsCredentialsFile,
 		"\nscripts_path :", scriptsPath,
-		"\nallowed_to_delete_files :", allowedToDeleteFiles)
+		"\nallowed_to_delete_files :", allowedToDeleteFiles,
+		"\njob_store :", jobStore,
+		"\ndirectory_jobs :", jobDirectory,
+		"\nrecovery_policy :", recoveryPolicy,
+		"\njob_timeout :", jobTimeout,
+		"\nstage_timeouts :", stageTimeouts,
+		"\ncustom_stages :", customStages,
+		"\njob_retention_time :", jobRetentionTime,
+		"\njob_retention_count :", jobRetentionCount,
+		"\njob_eviction_interval :", jobEvictionInterval)
 
 }
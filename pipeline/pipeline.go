@@ -0,0 +1,225 @@
+package pipeline
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/errorlog"
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/evoila/osb-backup-agent/shell"
+	"github.com/evoila/osb-backup-agent/timeutil"
+)
+
+// StageStatusRunning : Status of a stage that is currently running
+const StageStatusRunning = "RUNNING"
+
+// StageStatusSucceeded : Status of a stage that finished without an error
+const StageStatusSucceeded = "SUCCEEDED"
+
+// StageStatusFailed : Status of a stage that finished with an error
+const StageStatusFailed = "FAILED"
+
+// StageStatusSkipped : Status of a stage that did not run, because an earlier stage failed or the job was cancelled
+const StageStatusSkipped = "SKIPPED"
+
+// Stage is a single step of a job, for example a script, an upload or a download.
+type Stage struct {
+	Name string
+	// Required stages fail the job if they fail, failures of other stages are only logged.
+	Required bool
+	// AlwaysRun stages run regardless of earlier failures, cancellation or timeouts, as long as any earlier stage was started.
+	AlwaysRun bool
+	// Timeout is the maximum duration of the stage. A value of 0 disables the timeout.
+	Timeout time.Duration
+	// Run carries out the stage. The environment variables are meant to be passed to scripts.
+	Run func(ctx context.Context, env []string) Result
+}
+
+// Result holds the outcome of a single stage.
+type Result struct {
+	Name      string
+	Status    string
+	Log       string
+	ErrorLog  string
+	Err       error
+	StartTime time.Time
+	EndTime   time.Time
+	// ExitCode is only set for stages that ran a script
+	ExitCode *int
+}
+
+// Outcome holds the outcome of a whole pipeline run.
+type Outcome struct {
+	// Err is the error of the first required stage that failed.
+	Err error
+	// InterruptedStage is the last stage that was started before the stages that always run.
+	InterruptedStage string
+	// Cancelled and TimedOut are only set, if the context was done before the last stage that does not always run ended.
+	Cancelled bool
+	TimedOut  bool
+	Results   []Result
+}
+
+// Pipeline runs its stages in order and reports the progress via the optional callbacks.
+type Pipeline struct {
+	Stages          []Stage
+	OnStageStarted  func(stage Stage)
+	OnStageFinished func(result Result)
+}
+
+// TimeoutError is returned when a stage was aborted, because it exceeded its timeout.
+type TimeoutError struct {
+	Stage   string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return errorlog.Concat([]string{"stage ", e.Stage, " exceeded its timeout of ", e.Timeout.String()}, "")
+}
+
+// GetRunningStageResult returns the representation of a stage, which just started, for the job response.
+func GetRunningStageResult(name string) httpBodies.StageResult {
+	var now = time.Now()
+	return httpBodies.StageResult{Name: name, Status: StageStatusRunning, StartTime: timeutil.GetTimestamp(&now)}
+}
+
+// GetStageResult returns the representation of a finished or skipped stage for the job response.
+func GetStageResult(result Result) httpBodies.StageResult {
+	var stageResult = httpBodies.StageResult{
+		Name:     result.Name,
+		Status:   result.Status,
+		ExitCode: result.ExitCode,
+		Stdout:   result.Log,
+		Stderr:   result.ErrorLog,
+	}
+	if !result.StartTime.IsZero() {
+		stageResult.StartTime = timeutil.GetTimestamp(&result.StartTime)
+		stageResult.EndTime = timeutil.GetTimestamp(&result.EndTime)
+		stageResult.Duration = timeutil.GetTimeDifferenceInMilliseconds(result.StartTime.UnixNano(), result.EndTime.UnixNano())
+	}
+	return stageResult
+}
+
+// IsTimeoutError returns true if the error was caused by a stage exceeding its timeout.
+func IsTimeoutError(err error) bool {
+	_, isTimeout := err.(*TimeoutError)
+	return isTimeout
+}
+
+// Run executes all stages. Cancelling the context or exceeding its deadline skips the remaining stages,
+// except for the ones that always run.
+func (p *Pipeline) Run(ctx context.Context, env []string) Outcome {
+	var outcome Outcome
+	var started = false
+	// The cancellation or timeout of the job is taken from the stages that do not always run,
+	// so a cancellation while the cleanup and unlock stages run does not change the outcome of a finished job
+	var interruption = ctx.Err()
+
+	for _, stage := range p.Stages {
+		var failing = outcome.Err != nil || ctx.Err() != nil
+		if (stage.AlwaysRun && !started) || (!stage.AlwaysRun && failing) {
+			log.Println("> Skipping", stage.Name, "stage.")
+			var result = Result{Name: stage.Name, Status: StageStatusSkipped}
+			outcome.Results = append(outcome.Results, result)
+			if p.OnStageFinished != nil {
+				p.OnStageFinished(result)
+			}
+			if !stage.AlwaysRun {
+				interruption = ctx.Err()
+			}
+			continue
+		}
+
+		if !stage.AlwaysRun {
+			outcome.InterruptedStage = stage.Name
+		}
+		started = true
+
+		var result = p.runStage(ctx, stage, env, failing)
+		if !stage.AlwaysRun {
+			interruption = ctx.Err()
+		}
+		if result.Err != nil {
+			if stage.Required && outcome.Err == nil {
+				outcome.Err = result.Err
+			} else if !stage.Required {
+				log.Println("Ignoring the failure of the optional", stage.Name, "stage.")
+			}
+		}
+		outcome.Results = append(outcome.Results, result)
+	}
+
+	outcome.Cancelled = interruption == context.Canceled
+	outcome.TimedOut = interruption == context.DeadlineExceeded
+	return outcome
+}
+
+func (p *Pipeline) runStage(ctx context.Context, stage Stage, env []string, failing bool) Result {
+	// Stages that always run must not be aborted by the job's cancellation or timeout, but keep the context's values
+	var parentCtx = ctx
+	if stage.AlwaysRun {
+		parentCtx = detachedContext{parent: ctx}
+		env = append(env[:len(env):len(env)], shell.GetJobFailingEnvVar(failing))
+	}
+
+	var stageCtx = parentCtx
+	if stage.Timeout > 0 {
+		var cancel context.CancelFunc
+		stageCtx, cancel = context.WithTimeout(parentCtx, stage.Timeout)
+		defer cancel()
+	}
+
+	if p.OnStageStarted != nil {
+		p.OnStageStarted(stage)
+	}
+	log.Println("> Starting", stage.Name, "stage.")
+
+	var startTime = time.Now()
+	var result = stage.Run(stageCtx, env)
+	result.Name = stage.Name
+	result.StartTime = startTime
+	result.EndTime = time.Now()
+
+	if result.Err != nil && parentCtx.Err() == nil && stageCtx.Err() == context.DeadlineExceeded {
+		result.Err = &TimeoutError{Stage: stage.Name, Timeout: stage.Timeout}
+		errorlog.LogError("Aborted the ", stage.Name, " stage, because it exceeded its timeout of ", stage.Timeout.String())
+		result.ErrorLog = errorlog.Concat([]string{result.ErrorLog, "\n[backup-agent] ", result.Err.Error(), "\n"}, "")
+	} else if result.Err != nil && parentCtx.Err() == context.DeadlineExceeded {
+		result.ErrorLog = errorlog.Concat([]string{result.ErrorLog, "\n[backup-agent] job exceeded its timeout during stage ", stage.Name, "\n"}, "")
+	}
+
+	if result.Err != nil {
+		result.Status = StageStatusFailed
+	} else {
+		result.Status = StageStatusSucceeded
+	}
+
+	if p.OnStageFinished != nil {
+		p.OnStageFinished(result)
+	}
+	log.Println("> Finishing", stage.Name, "stage.")
+	return result
+}
+
+// detachedContext carries the values of its parent, but is neither cancelled nor has a deadline.
+// It is a replacement of context.WithoutCancel, which is not available before go 1.21.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -0,0 +1,404 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/shell"
+)
+
+// setUpScripts writes the scripts into a new directory, which is used as script directory. The caller has to remove the directory.
+func setUpScripts(t *testing.T, scripts map[string]string) string {
+	directory, err := ioutil.TempDir("", "scripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range scripts {
+		if err = ioutil.WriteFile(filepath.Join(directory, name+".sh"), []byte(content), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shell.Directory = directory
+	return directory
+}
+
+// getTickingScript returns a script, whose child process appends to the file at the path until it is killed.
+func getTickingScript(path string) string {
+	return "( while true; do echo tick >> '" + path + "'; sleep 0.05; done ) &\nsleep 60\n"
+}
+
+func assertTicksStopped(t *testing.T, path string) {
+	time.Sleep(200 * time.Millisecond)
+	before, _ := ioutil.ReadFile(path)
+	time.Sleep(300 * time.Millisecond)
+	after, _ := ioutil.ReadFile(path)
+	if len(before) == 0 || len(after) != len(before) {
+		t.Errorf("The child process of the script did not run or is still running: %d and %d bytes", len(before), len(after))
+	}
+}
+
+func getStatuses(outcome Outcome) map[string]string {
+	statuses := make(map[string]string)
+	for _, result := range outcome.Results {
+		statuses[result.Name] = result.Status
+	}
+	return statuses
+}
+
+func TestStageTimeoutKillsTheScript(t *testing.T) {
+	directory := setUpScripts(t, nil)
+	defer os.RemoveAll(directory)
+	ticks := filepath.Join(directory, "ticks")
+	ioutil.WriteFile(filepath.Join(directory, "backup.sh"), []byte(getTickingScript(ticks)), 0700)
+
+	var p = Pipeline{Stages: []Stage{
+		ScriptStage("backup", true, false, time.Second),
+		ScriptStage("upload", true, false, 0),
+	}}
+	start := time.Now()
+	outcome := p.Run(context.Background(), nil)
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("The stage ran for %v despite its timeout", time.Since(start))
+	}
+	assertTicksStopped(t, ticks)
+
+	if !IsTimeoutError(outcome.Err) {
+		t.Errorf("Expected a timeout error, got %v", outcome.Err)
+	}
+	// Only the job's own deadline marks the whole job as timed out
+	if outcome.TimedOut || outcome.Cancelled {
+		t.Errorf("The stage timeout was reported as job timeout or cancellation: %+v", outcome)
+	}
+	if statuses := getStatuses(outcome); statuses["backup"] != StageStatusFailed || statuses["upload"] != StageStatusSkipped {
+		t.Errorf("Unexpected statuses of the stages: %v", statuses)
+	}
+	if outcome.Results[0].ExitCode == nil || *outcome.Results[0].ExitCode != -1 {
+		t.Error("The killed script was not reported with the exit code -1")
+	}
+}
+
+func TestJobTimeoutKillsTheScriptAndSetsTimedOut(t *testing.T) {
+	directory := setUpScripts(t, nil)
+	defer os.RemoveAll(directory)
+	ticks := filepath.Join(directory, "ticks")
+	ioutil.WriteFile(filepath.Join(directory, "backup.sh"), []byte(getTickingScript(ticks)), 0700)
+
+	var p = Pipeline{Stages: []Stage{
+		ScriptStage("backup", true, false, time.Hour),
+		ScriptStage("upload", true, false, 0),
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	outcome := p.Run(ctx, nil)
+	assertTicksStopped(t, ticks)
+
+	if !outcome.TimedOut || outcome.Cancelled || outcome.InterruptedStage != "backup" {
+		t.Errorf("Expected the job to time out during the backup stage: %+v", outcome)
+	}
+	// The stage's own timeout was not exceeded, so the error is not reported as stage timeout
+	if outcome.Err == nil || IsTimeoutError(outcome.Err) {
+		t.Errorf("Expected the error of the killed script, got %v", outcome.Err)
+	}
+	if statuses := getStatuses(outcome); statuses["backup"] != StageStatusFailed || statuses["upload"] != StageStatusSkipped {
+		t.Errorf("Unexpected statuses of the stages: %v", statuses)
+	}
+}
+
+// getAlwaysRunStages returns a cleanup and an unlock stage, whose scripts print whether the job is failing.
+func getAlwaysRunStages() []Stage {
+	return []Stage{
+		ScriptStage("backup-cleanup", true, true, 0, "db", "id"),
+		ScriptStage("post-backup-unlock", true, true, 0, "db"),
+	}
+}
+
+func getResult(outcome Outcome, name string) Result {
+	for _, result := range outcome.Results {
+		if result.Name == name {
+			return result
+		}
+	}
+	return Result{}
+}
+
+func assertAlwaysRunStagesRan(t *testing.T, outcome Outcome, failing string) {
+	for _, name := range []string{"backup-cleanup", "post-backup-unlock"} {
+		result := getResult(outcome, name)
+		if result.Status != StageStatusSucceeded {
+			t.Errorf("Expected the %s stage to run, got %s", name, result.Status)
+		} else if result.Log != shell.JobFailingEnvVar+"="+failing+"\n" {
+			t.Errorf("Expected the %s stage to get %s=%s, got %q", name, shell.JobFailingEnvVar, failing, result.Log)
+		}
+	}
+}
+
+const printFailingScript = "echo \"BACKUP_AGENT_JOB_FAILING=$BACKUP_AGENT_JOB_FAILING\"\n"
+
+func TestAlwaysRunStagesRunAfterAFailure(t *testing.T) {
+	directory := setUpScripts(t, map[string]string{
+		"pre-backup-lock":    "exit 0\n",
+		"backup":             "exit 1\n",
+		"backup-cleanup":     printFailingScript,
+		"post-backup-unlock": printFailingScript,
+	})
+	defer os.RemoveAll(directory)
+
+	var p = Pipeline{Stages: append([]Stage{
+		ScriptStage("pre-backup-lock", true, false, 0, "db"),
+		ScriptStage("backup", true, false, 0),
+		ScriptStage("upload", true, false, 0),
+	}, getAlwaysRunStages()...)}
+	outcome := p.Run(context.Background(), []string{"PARAMETER=value"})
+
+	if outcome.Err == nil || outcome.InterruptedStage != "backup" {
+		t.Errorf("Expected the job to fail during the backup stage: %+v", outcome)
+	}
+	if status := getResult(outcome, "upload").Status; status != StageStatusSkipped {
+		t.Errorf("Expected the upload to be skipped, got %s", status)
+	}
+	assertAlwaysRunStagesRan(t, outcome, "true")
+}
+
+func TestAlwaysRunStagesRunAfterASuccess(t *testing.T) {
+	directory := setUpScripts(t, map[string]string{
+		"pre-backup-lock":    "exit 0\n",
+		"backup-cleanup":     printFailingScript,
+		"post-backup-unlock": printFailingScript,
+	})
+	defer os.RemoveAll(directory)
+
+	var p = Pipeline{Stages: append([]Stage{ScriptStage("pre-backup-lock", true, false, 0, "db")}, getAlwaysRunStages()...)}
+	outcome := p.Run(context.Background(), nil)
+	if outcome.Err != nil {
+		t.Errorf("The job failed: %v", outcome.Err)
+	}
+	assertAlwaysRunStagesRan(t, outcome, "false")
+}
+
+func TestAlwaysRunStagesRunAfterACancellation(t *testing.T) {
+	directory := setUpScripts(t, map[string]string{
+		"pre-backup-lock":    "sleep 60\n",
+		"backup-cleanup":     "sleep 0.2\n" + printFailingScript,
+		"post-backup-unlock": printFailingScript,
+	})
+	defer os.RemoveAll(directory)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var p = Pipeline{
+		Stages: append([]Stage{ScriptStage("pre-backup-lock", true, false, 0, "db")}, getAlwaysRunStages()...),
+		OnStageStarted: func(stage Stage) {
+			if stage.Name == "pre-backup-lock" {
+				time.AfterFunc(200*time.Millisecond, cancel)
+			}
+		},
+	}
+	outcome := p.Run(ctx, nil)
+
+	// The cancelled context must neither skip nor abort the stages that always run
+	if !outcome.Cancelled || outcome.InterruptedStage != "pre-backup-lock" {
+		t.Errorf("Expected the job to be cancelled during the pre-backup-lock stage: %+v", outcome)
+	}
+	assertAlwaysRunStagesRan(t, outcome, "true")
+}
+
+func TestAlwaysRunStagesAreSkippedIfNothingStarted(t *testing.T) {
+	var ran []string
+	var record = func(name string) Stage {
+		return Stage{Name: name, Required: true, AlwaysRun: true, Run: func(ctx context.Context, env []string) Result {
+			ran = append(ran, name)
+			return Result{}
+		}}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var p = Pipeline{Stages: []Stage{
+		record("backup-cleanup"),
+		{Name: "pre-backup-lock", Required: true, Run: func(ctx context.Context, env []string) Result { return Result{} }},
+		record("post-backup-unlock"),
+	}}
+	outcome := p.Run(ctx, nil)
+
+	// An unlock without a lock would release a lock of another job
+	if len(ran) != 0 {
+		t.Errorf("The stages %v ran, although no stage was started before", ran)
+	}
+	if statuses := getStatuses(outcome); statuses["backup-cleanup"] != StageStatusSkipped || statuses["post-backup-unlock"] != StageStatusSkipped {
+		t.Errorf("Unexpected statuses of the stages: %v", statuses)
+	}
+}
+
+// newStage returns a stage, which records its run in ran and returns the error.
+func newStage(name string, required, alwaysRun bool, err error, ran *[]string) Stage {
+	return Stage{Name: name, Required: required, AlwaysRun: alwaysRun, Run: func(ctx context.Context, env []string) Result {
+		*ran = append(*ran, name)
+		return Result{Err: err}
+	}}
+}
+
+func TestOptionalStageFailureIsIgnored(t *testing.T) {
+	var ran []string
+	var p = Pipeline{Stages: []Stage{
+		newStage("lock", true, false, nil, &ran),
+		newStage("notify", false, false, errors.New("notification failed"), &ran),
+		newStage("backup", true, false, nil, &ran),
+		newStage("unlock", true, true, nil, &ran),
+	}}
+	outcome := p.Run(context.Background(), nil)
+
+	if outcome.Err != nil || len(ran) != 4 {
+		t.Errorf("Expected all stages to run without an error, ran %v with %v", ran, outcome.Err)
+	}
+	if statuses := getStatuses(outcome); statuses["notify"] != StageStatusFailed || statuses["backup"] != StageStatusSucceeded {
+		t.Errorf("Unexpected statuses of the stages: %v", statuses)
+	}
+}
+
+func TestRequiredStageFailureSkipsTheRemainingStages(t *testing.T) {
+	var ran []string
+	var failure = errors.New("backup failed")
+	var p = Pipeline{Stages: []Stage{
+		newStage("lock", true, false, nil, &ran),
+		newStage("backup", true, false, failure, &ran),
+		newStage("upload", true, false, nil, &ran),
+		newStage("cleanup", true, true, errors.New("cleanup failed"), &ran),
+		newStage("unlock", true, true, nil, &ran),
+	}}
+	outcome := p.Run(context.Background(), nil)
+
+	// The first failure is reported, not the one of the cleanup
+	if outcome.Err != failure || outcome.InterruptedStage != "backup" {
+		t.Errorf("Expected the failure of the backup stage, got %v during %s", outcome.Err, outcome.InterruptedStage)
+	}
+	if strings.Join(ran, ",") != "lock,backup,cleanup,unlock" {
+		t.Errorf("Unexpected stages ran: %v", ran)
+	}
+	if statuses := getStatuses(outcome); statuses["upload"] != StageStatusSkipped || statuses["cleanup"] != StageStatusFailed {
+		t.Errorf("Unexpected statuses of the stages: %v", statuses)
+	}
+}
+
+func TestCancellationDuringAlwaysRunStagesDoesNotChangeTheOutcome(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var ran []string
+	var cleanup = newStage("cleanup", true, true, nil, &ran)
+	var runCleanup = cleanup.Run
+	cleanup.Run = func(ctx context.Context, env []string) Result {
+		cancel()
+		return runCleanup(ctx, env)
+	}
+	var p = Pipeline{Stages: []Stage{
+		newStage("backup", true, false, nil, &ran),
+		cleanup,
+		newStage("unlock", true, true, nil, &ran),
+	}}
+	outcome := p.Run(ctx, nil)
+
+	if outcome.Cancelled || outcome.Err != nil || len(ran) != 3 {
+		t.Errorf("The job was reported as cancelled after its required stages finished: %+v", outcome)
+	}
+}
+
+func TestJobTimeoutDuringAlwaysRunStagesDoesNotChangeTheOutcome(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var ran []string
+	var slowCleanup = Stage{Name: "cleanup", Required: true, AlwaysRun: true, Run: func(ctx context.Context, env []string) Result {
+		// The stage is detached from the job's deadline, so it can finish after the deadline passed
+		time.Sleep(200 * time.Millisecond)
+		return Result{Err: ctx.Err()}
+	}}
+	var p = Pipeline{Stages: []Stage{newStage("backup", true, false, nil, &ran), slowCleanup}}
+	outcome := p.Run(ctx, nil)
+
+	if outcome.TimedOut || outcome.Err != nil {
+		t.Errorf("The job was reported as timed out after its required stages finished: %+v", outcome)
+	}
+}
+
+func TestStageTimeoutOnlyAbortsItsStage(t *testing.T) {
+	var ran []string
+	var slow = Stage{Name: "check", Timeout: 50 * time.Millisecond, Run: func(ctx context.Context, env []string) Result {
+		<-ctx.Done()
+		return Result{Err: ctx.Err()}
+	}}
+	var p = Pipeline{Stages: []Stage{slow, newStage("backup", true, false, nil, &ran)}}
+	outcome := p.Run(context.Background(), nil)
+
+	// The optional stage exceeded its timeout, the job continues
+	if outcome.Err != nil || outcome.TimedOut || len(ran) != 1 {
+		t.Errorf("The timeout of the optional stage aborted the job: %+v", outcome)
+	}
+	if result := getResult(outcome, "check"); !IsTimeoutError(result.Err) || !strings.Contains(result.ErrorLog, "exceeded its timeout of 50ms") {
+		t.Errorf("Expected the stage to report its timeout, got %v and %q", result.Err, result.ErrorLog)
+	}
+}
+
+func TestCallbacksReportEveryStage(t *testing.T) {
+	var ran, started, finished []string
+	var p = Pipeline{
+		Stages: []Stage{
+			newStage("backup", true, false, errors.New("backup failed"), &ran),
+			newStage("upload", true, false, nil, &ran),
+			newStage("unlock", true, true, nil, &ran),
+		},
+		OnStageStarted:  func(stage Stage) { started = append(started, stage.Name) },
+		OnStageFinished: func(result Result) { finished = append(finished, result.Name+"="+result.Status) },
+	}
+	p.Run(context.Background(), nil)
+
+	if strings.Join(started, ",") != "backup,unlock" {
+		t.Errorf("Unexpected started stages: %v", started)
+	}
+	if strings.Join(finished, ",") != "backup=FAILED,upload=SKIPPED,unlock=SUCCEEDED" {
+		t.Errorf("Unexpected finished stages: %v", finished)
+	}
+}
+
+func TestAddCustomStages(t *testing.T) {
+	os.Setenv("custom_stages", `[
+		{"job": "backup", "name": "notify", "after": "backup", "optional": true, "timeout": "5m"},
+		{"job": "backup", "name": "verify", "after": "backup"},
+		{"job": "backup", "name": "report", "always_run": true, "timeout": "5 minutes"},
+		{"job": "restore", "name": "restore-notify"},
+		{"job": "backup", "name": "orphan", "after": "missing"}
+	]`)
+	defer os.Unsetenv("custom_stages")
+
+	var stages = []Stage{
+		ScriptStage("backup", true, false, 0),
+		ScriptStage("upload", true, false, 0),
+		ScriptStage("unlock", true, true, 0),
+	}
+	stages = AddCustomStages(stages, "backup", func(name string) time.Duration {
+		if name == "verify" {
+			return time.Hour
+		}
+		return 0
+	})
+
+	var names []string
+	for _, stage := range stages {
+		names = append(names, stage.Name)
+	}
+	if strings.Join(names, ",") != "backup,notify,verify,upload,report,unlock" {
+		t.Fatalf("Unexpected order of the stages: %v", names)
+	}
+	if notify := stages[1]; notify.Required || notify.Timeout != 5*time.Minute {
+		t.Errorf("Expected the optional notify stage with the configured timeout: %+v", notify)
+	}
+	if verify := stages[2]; !verify.Required || verify.Timeout != time.Hour {
+		t.Errorf("Expected the required verify stage with the timeout of the request: %+v", verify)
+	}
+	// The invalid timeout is reported when it is parsed and the stage runs without a timeout
+	if report := stages[4]; !report.AlwaysRun || report.Timeout != 0 {
+		t.Errorf("Expected the always running report stage without a timeout: %+v", report)
+	}
+}
@@ -0,0 +1,124 @@
+package pipeline
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/configuration"
+	"github.com/evoila/osb-backup-agent/errorlog"
+	"github.com/evoila/osb-backup-agent/shell"
+)
+
+// ScriptStage returns a stage that runs the script named like the stage with the given parameters.
+func ScriptStage(name string, required, alwaysRun bool, timeout time.Duration, params ...string) Stage {
+	return Stage{
+		Name:      name,
+		Required:  required,
+		AlwaysRun: alwaysRun,
+		Timeout:   timeout,
+		Run: func(ctx context.Context, env []string) Result {
+			found, logs, errlogs, err := shell.ExecuteScriptForStage(ctx, name, env, params...)
+			return getScriptResult(name, found, logs, errlogs, err)
+		},
+	}
+}
+
+// OutputStreamStage returns a stage that runs the script named like the stage, while upload reads the data the script writes to its stdout
+// or, if pipePath is not empty, into the named pipe at this path. The script's stdout is not part of the logs.
+func OutputStreamStage(name string, timeout time.Duration, pipePath string, upload func(ctx context.Context, reader io.Reader) error, params ...string) Stage {
+	return Stage{
+		Name:     name,
+		Required: true,
+		Timeout:  timeout,
+		Run: func(ctx context.Context, env []string) Result {
+			found, logs, errlogs, err := shell.ExecuteScriptForStageWithOutputStream(ctx, name, env, pipePath, upload, params...)
+			return getScriptResult(name, found, logs, errlogs, err)
+		},
+	}
+}
+
+// InputStreamStage returns a stage that runs the script named like the stage, while download writes the data the script reads from its stdin
+// or, if pipePath is not empty, from the named pipe at this path.
+func InputStreamStage(name string, timeout time.Duration, pipePath string, download func(ctx context.Context, writer io.Writer) error, params ...string) Stage {
+	return Stage{
+		Name:     name,
+		Required: true,
+		Timeout:  timeout,
+		Run: func(ctx context.Context, env []string) Result {
+			found, logs, errlogs, err := shell.ExecuteScriptForStageWithInputStream(ctx, name, env, pipePath, download, params...)
+			return getScriptResult(name, found, logs, errlogs, err)
+		},
+	}
+}
+
+// DeferredStage returns a stage that creates the actual stage only when it starts, so the parameters of its script can depend
+// on the results of earlier stages. The name, the requirement and the timeout of the created stage are ignored.
+func DeferredStage(name string, required bool, timeout time.Duration, newStage func() Stage) Stage {
+	return Stage{
+		Name:     name,
+		Required: required,
+		Timeout:  timeout,
+		Run: func(ctx context.Context, env []string) Result {
+			return newStage().Run(ctx, env)
+		},
+	}
+}
+
+func getScriptResult(name string, found bool, logs, errlogs string, err error) Result {
+	if !found {
+		if err == nil {
+			err = errorlog.LogError("No script found for the ", name, " stage.")
+		}
+		return Result{Log: logs, ErrorLog: errlogs, Err: err}
+	}
+	var exitCode = shell.GetExitCode(err)
+	return Result{Log: logs, ErrorLog: errlogs, Err: err, ExitCode: &exitCode}
+}
+
+// AddCustomStages inserts the custom stages configured for the job type after the stage they refer to.
+// Custom stages without a reference are added before the stages that always run.
+// They are scripts, which get the given parameters, and their timeout can be overridden like for every other stage.
+func AddCustomStages(stages []Stage, jobType string, getTimeout func(name string) time.Duration, params ...string) []Stage {
+	var lastInsertedAfter = make(map[string]string)
+
+	for _, custom := range configuration.GetCustomStages() {
+		if custom.Job != jobType {
+			continue
+		}
+
+		var timeout = getTimeout(custom.Name)
+		if timeout == 0 {
+			timeout = custom.TimeoutDuration
+		}
+		var stage = ScriptStage(custom.Name, !custom.Optional, custom.AlwaysRun, timeout, params...)
+
+		// Several custom stages after the same stage keep the order of the configuration
+		var after = custom.After
+		if name, exists := lastInsertedAfter[after]; exists {
+			after = name
+		}
+
+		var index, found = len(stages), after == ""
+		for i, existing := range stages {
+			if after == "" && existing.AlwaysRun {
+				index = i
+				break
+			}
+			if after != "" && existing.Name == after {
+				index, found = i+1, true
+				break
+			}
+		}
+		if !found {
+			errorlog.LogError("Ignoring custom stage ", custom.Name, ", because the stage ", custom.After, " does not exist for ", jobType, " jobs")
+			continue
+		}
+
+		log.Println("Adding custom stage", custom.Name, "to the", jobType, "job.")
+		stages = append(stages[:index], append([]Stage{stage}, stages[index:]...)...)
+		lastInsertedAfter[custom.After] = custom.Name
+	}
+	return stages
+}
@@ -0,0 +1,122 @@
+package recovery
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/backup"
+	"github.com/evoila/osb-backup-agent/configuration"
+	"github.com/evoila/osb-backup-agent/errorlog"
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/evoila/osb-backup-agent/jobs"
+	"github.com/evoila/osb-backup-agent/pipeline"
+	"github.com/evoila/osb-backup-agent/restore"
+	"github.com/evoila/osb-backup-agent/timeutil"
+)
+
+// RecoverInterruptedJobs looks for jobs in the job store that were still running when the agent stopped
+// and handles them according to the given recovery policy.
+// It has to be called after the job structure is set up and before any new job is accepted.
+func RecoverInterruptedJobs(policy string) {
+	if policy == configuration.RecoveryPolicyNone {
+		log.Println("Recovery policy is", policy, "-> not looking for interrupted jobs.")
+		return
+	}
+	unlock := policy == configuration.RecoveryPolicyMarkFailedAndUnlock
+
+	for UUID, job := range jobs.GetBackupJobs() {
+		if isRunning(job.Status) {
+			recoverBackupJob(UUID, job, unlock)
+		}
+	}
+	for UUID, job := range jobs.GetRestoreJobs() {
+		if isRunning(job.Status) {
+			recoverRestoreJob(UUID, job, unlock)
+		}
+	}
+}
+
+// isRunning also accepts the initial status of a freshly added job, which is not yet upper case.
+func isRunning(status string) bool {
+	return strings.EqualFold(status, httpBodies.Status_running)
+}
+
+func recoverBackupJob(UUID string, job *httpBodies.BackupResponse, unlock bool) {
+	stage := job.State
+	log.Println("Backup job", UUID, "was interrupted during stage", getStageDescription(stage))
+
+	if unlock && stage != "" {
+		// The parameters of the original request are not persisted, so the scripts run without them
+		var stages []pipeline.Stage
+		if stage == backup.NameBackup || stage == backup.NameUpload || stage == backup.NameBackupCleanup {
+			stages = append(stages, pipeline.ScriptStage(backup.NameBackupCleanup, false, false, 0, job.Database, UUID))
+		}
+		stages = append(stages, pipeline.ScriptStage(backup.NamePostBackupUnlock, false, false, 0, job.Database))
+		log.Println("Running the remaining stages of interrupted job", UUID)
+		job.Stages = runStages(job.Stages, stages)
+	}
+
+	currentTime := time.Now()
+	err := getInterruptionError(stage)
+	job.Status = httpBodies.Status_failed
+	job.Message = "backup failed"
+	job.ErrorMessage = err.Error()
+	job.State = "finished"
+	job.EndTime = timeutil.GetTimestamp(&currentTime)
+
+	log.Println("Updating backup job", UUID, "with an error response.")
+	jobs.UpdateBackupJob(UUID, job)
+}
+
+func recoverRestoreJob(UUID string, job *httpBodies.RestoreResponse, unlock bool) {
+	stage := job.State
+	log.Println("Restore job", UUID, "was interrupted during stage", getStageDescription(stage))
+
+	if unlock && stage != "" {
+		// The parameters of the original request are not persisted, so the scripts run without them
+		var stages []pipeline.Stage
+		if stage == restore.NameDownload || stage == restore.NameVerify || stage == restore.NameRestore || stage == restore.NameRestoreCleanup {
+			stages = append(stages, pipeline.ScriptStage(restore.NameRestoreCleanup, false, false, 0, UUID))
+		}
+		stages = append(stages, pipeline.ScriptStage(restore.NamePostRestoreUnlock, false, false, 0))
+		log.Println("Running the remaining stages of interrupted job", UUID)
+		job.Stages = runStages(job.Stages, stages)
+	}
+
+	currentTime := time.Now()
+	err := getInterruptionError(stage)
+	job.Status = httpBodies.Status_failed
+	job.Message = "restore failed"
+	job.ErrorMessage = err.Error()
+	job.State = "finished"
+	job.EndTime = timeutil.GetTimestamp(&currentTime)
+
+	log.Println("Updating restore job", UUID, "with an error response.")
+	jobs.UpdateRestoreJob(UUID, job)
+}
+
+// runStages runs the stages for an interrupted job and records their results in the stages of the job.
+// The stages are optional, so the unlock runs even if the cleanup failed.
+func runStages(results []httpBodies.StageResult, stages []pipeline.Stage) []httpBodies.StageResult {
+	var p = pipeline.Pipeline{
+		Stages: stages,
+		OnStageFinished: func(result pipeline.Result) {
+			results = httpBodies.SetStageResult(results, pipeline.GetStageResult(result))
+		},
+	}
+	p.Run(context.Background(), nil)
+	return results
+}
+
+func getInterruptionError(stage string) error {
+	return errorlog.LogError("agent restarted during stage ", getStageDescription(stage))
+}
+
+func getStageDescription(stage string) string {
+	if stage == "" {
+		return "job start"
+	}
+	return stage
+}
@@ -0,0 +1,147 @@
+package recovery
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/evoila/osb-backup-agent/backup"
+	"github.com/evoila/osb-backup-agent/configuration"
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/evoila/osb-backup-agent/jobs"
+	"github.com/evoila/osb-backup-agent/restore"
+	"github.com/evoila/osb-backup-agent/shell"
+)
+
+// setUpTestScripts points the shell at a directory with cleanup and unlock scripts, which echo their name and parameters.
+func setUpTestScripts(t *testing.T) func() {
+	directory, err := ioutil.TempDir("", "scripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{backup.NameBackupCleanup, backup.NamePostBackupUnlock, restore.NameRestoreCleanup, restore.NamePostRestoreUnlock} {
+		script := "echo " + name + " \"$@\"\n"
+		if err := ioutil.WriteFile(filepath.Join(directory, name+".sh"), []byte(script), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	previous := shell.Directory
+	shell.Directory = directory
+	return func() {
+		shell.Directory = previous
+		os.RemoveAll(directory)
+	}
+}
+
+func setUpTestJobs(t *testing.T) {
+	if err := jobs.SetUpJobStructure(); err != nil {
+		t.Fatal(err)
+	}
+	var backupJobs = map[string]*httpBodies.BackupResponse{
+		"uploading": {Status: httpBodies.Status_running, State: backup.NameUpload, Database: "db"},
+		"checking":  {Status: httpBodies.Status_running, State: backup.NamePreBackupCheck, Database: "db"},
+		"added":     {Status: "Running"},
+		"finished":  {Status: httpBodies.Status_success, State: "finished", EndTime: "2024-01-02T03:04:05+00:00"},
+	}
+	for UUID, job := range backupJobs {
+		jobs.AddNewBackupJob(UUID)
+		jobs.UpdateBackupJob(UUID, job)
+	}
+	jobs.AddNewRestoreJob("verifying")
+	jobs.UpdateRestoreJob("verifying", &httpBodies.RestoreResponse{Status: httpBodies.Status_running, State: restore.NameVerify})
+}
+
+func getStage(stages []httpBodies.StageResult, name string) (httpBodies.StageResult, bool) {
+	for _, stage := range stages {
+		if stage.Name == name {
+			return stage, true
+		}
+	}
+	return httpBodies.StageResult{}, false
+}
+
+func TestRunningJobsAreMarkedFailed(t *testing.T) {
+	defer setUpTestScripts(t)()
+	setUpTestJobs(t)
+
+	RecoverInterruptedJobs(configuration.RecoveryPolicyMarkFailed)
+
+	for _, UUID := range []string{"uploading", "checking", "added"} {
+		job, _ := jobs.GetBackupJob(UUID)
+		if job.Status != httpBodies.Status_failed || job.State != "finished" || job.EndTime == "" {
+			t.Errorf("Backup job %s was not marked as failed: %+v", UUID, job)
+		}
+		if len(job.Stages) != 0 {
+			t.Errorf("Backup job %s ran scripts without the unlock policy: %+v", UUID, job.Stages)
+		}
+	}
+	job, _ := jobs.GetBackupJob("uploading")
+	if !strings.Contains(job.ErrorMessage, backup.NameUpload) {
+		t.Errorf("The error message does not name the interrupted stage: %s", job.ErrorMessage)
+	}
+	job, _ = jobs.GetBackupJob("added")
+	if !strings.Contains(job.ErrorMessage, "job start") {
+		t.Errorf("The error message of a job that did not start yet is wrong: %s", job.ErrorMessage)
+	}
+	job, _ = jobs.GetBackupJob("finished")
+	if job.Status != httpBodies.Status_success || job.EndTime != "2024-01-02T03:04:05+00:00" {
+		t.Errorf("The finished backup job was changed: %+v", job)
+	}
+	restoreJob, _ := jobs.GetRestoreJob("verifying")
+	if restoreJob.Status != httpBodies.Status_failed || restoreJob.State != "finished" {
+		t.Errorf("The restore job was not marked as failed: %+v", restoreJob)
+	}
+}
+
+func TestRunningJobsAreUnlocked(t *testing.T) {
+	defer setUpTestScripts(t)()
+	setUpTestJobs(t)
+
+	RecoverInterruptedJobs(configuration.RecoveryPolicyMarkFailedAndUnlock)
+
+	job, _ := jobs.GetBackupJob("uploading")
+	if job.Status != httpBodies.Status_failed {
+		t.Errorf("The backup job was not marked as failed: %+v", job)
+	}
+	if stage, found := getStage(job.Stages, backup.NameBackupCleanup); !found || stage.Stdout != "backup-cleanup db uploading\n" {
+		t.Errorf("The cleanup of the interrupted upload did not run: %+v", job.Stages)
+	}
+	if stage, found := getStage(job.Stages, backup.NamePostBackupUnlock); !found || stage.Status != "SUCCEEDED" || stage.Stdout != "post-backup-unlock db\n" {
+		t.Errorf("The unlock of the interrupted upload did not run: %+v", job.Stages)
+	}
+
+	job, _ = jobs.GetBackupJob("checking")
+	if _, found := getStage(job.Stages, backup.NameBackupCleanup); found {
+		t.Errorf("The cleanup ran although no backup was created: %+v", job.Stages)
+	}
+	if _, found := getStage(job.Stages, backup.NamePostBackupUnlock); !found {
+		t.Errorf("The unlock of the interrupted check did not run: %+v", job.Stages)
+	}
+
+	job, _ = jobs.GetBackupJob("added")
+	if len(job.Stages) != 0 {
+		t.Errorf("Scripts ran for a job that did not start yet: %+v", job.Stages)
+	}
+
+	restoreJob, _ := jobs.GetRestoreJob("verifying")
+	if stage, found := getStage(restoreJob.Stages, restore.NameRestoreCleanup); !found || stage.Stdout != "restore-cleanup verifying\n" {
+		t.Errorf("The cleanup of the interrupted verification did not run: %+v", restoreJob.Stages)
+	}
+	if _, found := getStage(restoreJob.Stages, restore.NamePostRestoreUnlock); !found {
+		t.Errorf("The unlock of the interrupted verification did not run: %+v", restoreJob.Stages)
+	}
+}
+
+func TestNoneLeavesRunningJobsUntouched(t *testing.T) {
+	defer setUpTestScripts(t)()
+	setUpTestJobs(t)
+
+	RecoverInterruptedJobs(configuration.RecoveryPolicyNone)
+
+	job, _ := jobs.GetBackupJob("uploading")
+	if job.Status != httpBodies.Status_running || job.State != backup.NameUpload {
+		t.Errorf("The running backup job was changed: %+v", job)
+	}
+}
@@ -1,32 +1,122 @@
 package restore
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/evoila/osb-backup-agent/compression"
 	"github.com/evoila/osb-backup-agent/configuration"
+	"github.com/evoila/osb-backup-agent/encryption"
 	"github.com/evoila/osb-backup-agent/errorlog"
 	"github.com/evoila/osb-backup-agent/httpBodies"
 	"github.com/evoila/osb-backup-agent/jobs"
-	"github.com/evoila/osb-backup-agent/s3"
+	"github.com/evoila/osb-backup-agent/logstream"
+	"github.com/evoila/osb-backup-agent/pipeline"
 	"github.com/evoila/osb-backup-agent/security"
 	"github.com/evoila/osb-backup-agent/shell"
-	"github.com/evoila/osb-backup-agent/swift"
+	"github.com/evoila/osb-backup-agent/storage"
 	"github.com/evoila/osb-backup-agent/timeutil"
 	"github.com/evoila/osb-backup-agent/utils"
 	"github.com/gorilla/mux"
 )
 
 const NamePreRestoreLock = "pre-restore-lock"
+const NameDownload = "download"
+const NameVerify = "verify"
 const NameRestore = "restore"
 const NameRestoreCleanup = "restore-cleanup"
 const NamePostRestoreUnlock = "post-restore-unlock"
 
+func HandleCancel(w http.ResponseWriter, r *http.Request) {
+	handleCancel(w, r, getResponseV1)
+}
+
+func HandleCancelV2(w http.ResponseWriter, r *http.Request) {
+	handleCancel(w, r, httpBodies.GetRestoreResponseV2)
+}
+
+func handleCancel(w http.ResponseWriter, r *http.Request, view func(*httpBodies.RestoreResponse) interface{}) {
+	log.Println("-- Restore cancel request received. --")
+
+	if !security.BasicAuth(w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	Id, exists := vars["id"]
+	if !exists {
+		w.WriteHeader(400)
+		return
+	}
+
+	job, existingJob := jobs.GetRestoreJob(Id)
+	if !existingJob {
+		w.WriteHeader(404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if jobs.CancelRestoreJob(Id) {
+		w.WriteHeader(202)
+	} else {
+		log.Println("Job is not running -> showing current result.")
+		w.WriteHeader(409)
+	}
+	json.NewEncoder(w).Encode(view(job))
+	log.Println("-- Restore cancel request completed. --")
+}
+
+func HandleLogs(w http.ResponseWriter, r *http.Request) {
+	log.Println("-- Restore log request received. --")
+
+	if !security.BasicAuth(w, r) {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	Id, exists := vars["id"]
+	if !exists {
+		w.WriteHeader(400)
+		return
+	}
+
+	if _, existingJob := jobs.GetRestoreJob(Id); !existingJob {
+		w.WriteHeader(404)
+		return
+	}
+
+	follow, valid := utils.ParseFollowParameter(w, r)
+	if !valid {
+		return
+	}
+
+	logstream.ServeEvents(w, r, logstream.RestoreJobKey(Id), follow, func() []httpBodies.StageResult {
+		job, _ := jobs.GetRestoreJob(Id)
+		if job == nil {
+			return nil
+		}
+		return job.Stages
+	}, func() string {
+		job, _ := jobs.GetRestoreJob(Id)
+		if job == nil {
+			return ""
+		}
+		return job.Status
+	})
+	log.Println("-- Restore log request completed. --")
+}
+
 func RemoveJob(w http.ResponseWriter, r *http.Request) {
 	log.Println("Restore job deletion request received.")
 	if !security.BasicAuth(w, r) {
@@ -47,7 +137,53 @@ func RemoveJob(w http.ResponseWriter, r *http.Request) {
 	log.Println("Restore job deletion request completed.")
 }
 
+func HandleListing(w http.ResponseWriter, r *http.Request) {
+	handleListing(w, r, getResponseV1)
+}
+
+func HandleListingV2(w http.ResponseWriter, r *http.Request) {
+	handleListing(w, r, httpBodies.GetRestoreResponseV2)
+}
+
+func handleListing(w http.ResponseWriter, r *http.Request, view func(*httpBodies.RestoreResponse) interface{}) {
+	log.Println("-- Restore listing request received. --")
+
+	if !security.BasicAuth(w, r) {
+		return
+	}
+
+	query, valid := utils.ParseJobListQuery(w, r)
+	if !valid {
+		return
+	}
+
+	entries := jobs.FindRestoreJobs(query.Filter)
+	start, end := query.GetPageBounds(len(entries))
+
+	var response = httpBodies.JobListResponse{Total: len(entries), Offset: query.Offset, Limit: query.Limit, Jobs: []httpBodies.JobListEntry{}}
+	for _, entry := range entries[start:end] {
+		job := entry.Job
+		if !query.Logs {
+			job = httpBodies.GetRestoreResponseWithoutLogs(job)
+		}
+		response.Jobs = append(response.Jobs, httpBodies.JobListEntry{Id: entry.Id, Job: view(job)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(response)
+	log.Println("-- Restore listing request completed. --")
+}
+
 func HandlePolling(w http.ResponseWriter, r *http.Request) {
+	handlePolling(w, r, getResponseV1)
+}
+
+func HandlePollingV2(w http.ResponseWriter, r *http.Request) {
+	handlePolling(w, r, httpBodies.GetRestoreResponseV2)
+}
+
+func handlePolling(w http.ResponseWriter, r *http.Request, view func(*httpBodies.RestoreResponse) interface{}) {
 	log.Println("-- Restore status request received. --")
 
 	if !security.BasicAuth(w, r) {
@@ -70,11 +206,19 @@ func HandlePolling(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(job)
+	json.NewEncoder(w).Encode(view(job))
 	log.Println("-- Restore status request completed. --")
 }
 
 func HandleAsyncRequest(w http.ResponseWriter, r *http.Request) {
+	handleAsyncRequest(w, r, getResponseV1)
+}
+
+func HandleAsyncRequestV2(w http.ResponseWriter, r *http.Request) {
+	handleAsyncRequest(w, r, httpBodies.GetRestoreResponseV2)
+}
+
+func handleAsyncRequest(w http.ResponseWriter, r *http.Request, view func(*httpBodies.RestoreResponse) interface{}) {
 	log.Println("-- Async Restore request received. --")
 
 	if !security.BasicAuth(w, r) {
@@ -91,7 +235,7 @@ func HandleAsyncRequest(w http.ResponseWriter, r *http.Request) {
 		log.Println("Job does exist -> showing current result.")
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(409)
-		json.NewEncoder(w).Encode(job)
+		json.NewEncoder(w).Encode(view(job))
 	} else {
 		// No job exists yet -> create new one
 		log.Println("Job does not exist yet -> creating a new one.")
@@ -100,6 +244,10 @@ func HandleAsyncRequest(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if !utils.AreTimeoutsValid(w, r, body.Timeouts, "Restore") {
+			return
+		}
+
 		allFieldsExist, missingFields := httpBodies.CheckForMissingFieldsInRestoreBody(body)
 		if !allFieldsExist {
 			err = errors.New("body is missing essential fields:" + missingFields)
@@ -111,7 +259,7 @@ func HandleAsyncRequest(w http.ResponseWriter, r *http.Request) {
 
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(400)
-			json.NewEncoder(w).Encode(response)
+			json.NewEncoder(w).Encode(view(&response))
 			return
 		}
 
@@ -128,7 +276,7 @@ func HandleAsyncRequest(w http.ResponseWriter, r *http.Request) {
 			jobs.DecreaseCurrentJobCount()
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(409)
-			json.NewEncoder(w).Encode(response)
+			json.NewEncoder(w).Encode(view(&response))
 			return
 		}
 
@@ -147,16 +295,27 @@ func Restore(body httpBodies.RestoreBody, job *httpBodies.RestoreResponse) *http
 	log.Println("Database", body.Restore.Database, "is supposed to get a restore.")
 	httpBodies.PrintOutRestoreBody(body)
 
+	ctx := jobs.StartRestoreJobContext(body.Id)
+	defer jobs.FinishRestoreJobContext(body.Id)
+
+	ctx = logstream.NewContext(ctx, logstream.Start(logstream.RestoreJobKey(body.Id)))
+	defer logstream.Finish(logstream.RestoreJobKey(body.Id))
+
+	var jobTimeout = utils.GetJobTimeout(body.Timeouts)
+	if jobTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, jobTimeout)
+		defer cancel()
+	}
+
 	response, _ := jobs.GetRestoreJob(body.Id)
 	response.Message = "restore is running"
 	response.Status = httpBodies.Status_running
 	response.Type = body.Destination.Type
 	response.Compression = body.Compression
+	response.Database = body.Restore.Database
 	jobs.UpdateRestoreJob(body.Id, response)
 
-	// Set up variables for filling response bodies later on
-	var err error
-
 	// Get environment parameters from request body
 	var envParameters = httpBodies.GetParametersAsEnvVarStringSlice(body.Restore.Parameters)
 
@@ -168,61 +327,13 @@ func Restore(body httpBodies.RestoreBody, job *httpBodies.RestoreResponse) *http
 	response.StartTime = startTime
 	jobs.UpdateRestoreJob(body.Id, response)
 
-	var status = true
-	if status {
-		response.State = NamePreRestoreLock
-		jobs.UpdateRestoreJob(body.Id, response)
-
-		log.Println("> Starting", response.State, "stage.")
-		status, response.PreRestoreLockLog, response.PreRestoreLockErrorLog, err = shell.ExecuteScriptForStage(NamePreRestoreLock, envParameters, body.Id)
-		jobs.UpdateRestoreJob(body.Id, response)
-		log.Println("> Finishing", response.State, "stage.")
-	}
-	if status {
-		response.State = NameRestore
-		jobs.UpdateRestoreJob(body.Id, response)
-
-		log.Println("> Starting", response.State, "stage.")
-
-		if body.Destination.Type == "S3" {
-			err = download(body, body.Destination.Type)
-		} else if body.Destination.Type == "SWIFT" {
-			err = download(body, body.Destination.Type)
-		} else {
-			status = false
-			err = errors.New("type is not supported")
-		}
-
-		if err != nil {
-			status = false
-			err = errorlog.LogError("Downloading from "+body.Destination.Type+" failed due to '", err.Error(), "'")
-		} else {
-			status, response.RestoreLog, response.RestoreErrorLog, err = shell.ExecuteScriptForStage(NameRestore, envParameters,
-				body.Restore.Host, body.Restore.Username, body.Restore.Password, body.Restore.Database,
-				body.Destination.Filename, body.Id, strconv.FormatBool(body.Compression), body.Encryption_key)
-			jobs.UpdateRestoreJob(body.Id, response)
-		}
-
-		log.Println("> Finishing", response.State, "stage.")
-	}
-	if status {
-		response.State = NameRestoreCleanup
-		jobs.UpdateRestoreJob(body.Id, response)
-
-		log.Println("> Starting", response.State, "stage.")
-		status, response.RestoreCleanupLog, response.RestoreCleanupErrorLog, err = shell.ExecuteScriptForStage(NameRestoreCleanup, envParameters, body.Id)
-		jobs.UpdateRestoreJob(body.Id, response)
-		log.Println("> Finishing", response.State, "stage.")
-	}
-	if status {
-		response.State = NamePostRestoreUnlock
-		jobs.UpdateRestoreJob(body.Id, response)
-
-		log.Println("> Starting", response.State, "stage.")
-		status, response.PostRestoreUnlockLog, response.PostRestoreUnlockErrorLog, err = shell.ExecuteScriptForStage(NamePostRestoreUnlock, envParameters)
-		jobs.UpdateRestoreJob(body.Id, response)
-		log.Println("> Finishing", response.State, "stage.")
-	}
+	// Start execution of the stages
+	var outcome = newPipeline(body, response).Run(ctx, envParameters)
+	var err = outcome.Err
+	var interruptedStage = outcome.InterruptedStage
+	var cancelled = outcome.Cancelled
+	var timedOut = outcome.TimedOut
+	var status = err == nil
 
 	// Set end time and calculate execution time
 	currentTime = time.Now()
@@ -235,7 +346,29 @@ func Restore(body httpBodies.RestoreBody, job *httpBodies.RestoreResponse) *http
 	jobs.UpdateRestoreJob(body.Id, response)
 
 	// Write standard or error response according to status
-	if status {
+	if cancelled {
+		err = errorlog.LogError("Restore was cancelled during stage ", interruptedStage)
+
+		response.Status = httpBodies.Status_cancelled
+		response.Message = "restore cancelled"
+		response.ErrorMessage = err.Error()
+
+		log.Println("Updating restore job", body.Id, "with a cancellation response.")
+		jobs.UpdateRestoreJob(body.Id, response)
+	} else if timedOut || pipeline.IsTimeoutError(err) {
+		if timedOut {
+			err = errorlog.LogError("Restore exceeded the job timeout of ", jobTimeout.String(), " during stage ", interruptedStage)
+		} else {
+			err = errorlog.LogError("Restore timed out due to '", err.Error(), "'")
+		}
+
+		response.Status = httpBodies.Status_failed
+		response.Message = "restore timed out"
+		response.ErrorMessage = err.Error()
+
+		log.Println("Updating restore job", body.Id, "with a timeout response.")
+		jobs.UpdateRestoreJob(body.Id, response)
+	} else if status {
 		response.Status = httpBodies.Status_success
 		response.Message = "restore successfully carried out"
 
@@ -265,7 +398,126 @@ func Restore(body httpBodies.RestoreBody, job *httpBodies.RestoreResponse) *http
 
 }
 
-func download(body httpBodies.RestoreBody, downloadType string) error {
+// newPipeline defines the stages of a restore job, including the custom stages of the configuration.
+// The progress of the stages is written into the given response.
+func newPipeline(body httpBodies.RestoreBody, response *httpBodies.RestoreResponse) *pipeline.Pipeline {
+	var getTimeout = func(name string) time.Duration {
+		return utils.GetStageTimeout(body.Timeouts, name)
+	}
+
+	var stages = []pipeline.Stage{
+		pipeline.ScriptStage(NamePreRestoreLock, true, false, getTimeout(NamePreRestoreLock), body.Id),
+	}
+	// The format is determined by the download or the verification, before the restore script is started
+	var format transferFormat
+	if body.Streaming == "" {
+		stages = append(stages,
+			pipeline.Stage{
+				Name:     NameDownload,
+				Required: true,
+				Timeout:  getTimeout(NameDownload),
+				Run: func(ctx context.Context, env []string) pipeline.Result {
+					var err error
+					format, err = download(ctx, body, response, body.Destination.Type)
+					if err != nil {
+						err = errorlog.LogError("Downloading from "+body.Destination.Type+" failed due to '", err.Error(), "'")
+					}
+					return pipeline.Result{Err: err}
+				},
+			},
+			pipeline.DeferredStage(NameRestore, true, getTimeout(NameRestore), func() pipeline.Stage {
+				return pipeline.ScriptStage(NameRestore, true, false, 0, getScriptParams(body, format)...)
+			}))
+	} else {
+		// The backup is downloaded while the restore script reads it, so there is no separate download stage.
+		// Encrypted backups are verified completely beforehand, so the script never reads data of a modified backup, unless the request skips it.
+		var pipePath string
+		if body.Streaming == httpBodies.Streaming_pipe {
+			pipePath = configuration.GetRestoreDirectory() + "/" + body.Id + "/" + body.Destination.Filename
+		}
+		stages = append(stages, pipeline.Stage{
+			Name:     NameVerify,
+			Required: true,
+			Timeout:  getTimeout(NameVerify),
+			Run: func(ctx context.Context, env []string) pipeline.Result {
+				var err error
+				format, err = getTransferFormat(ctx, body, response)
+				if err == nil {
+					err = verify(ctx, body, format)
+				}
+				if err != nil {
+					err = errorlog.LogError("Verifying the backup at "+body.Destination.Type+" failed due to '", err.Error(), "'")
+				}
+				return pipeline.Result{Err: err}
+			},
+		})
+		stages = append(stages, pipeline.DeferredStage(NameRestore, true, getTimeout(NameRestore), func() pipeline.Stage {
+			return pipeline.InputStreamStage(NameRestore, 0, pipePath, func(ctx context.Context, writer io.Writer) error {
+				log.Println("Using", body.Destination.Type, "as destination.")
+				err := downloadTransformed(ctx, body, format, writer)
+				if err != nil {
+					return errorlog.LogError("Downloading from "+body.Destination.Type+" failed due to '", err.Error(), "'")
+				}
+				return nil
+			}, getScriptParams(body, format)...)
+		}))
+	}
+	stages = append(stages,
+		pipeline.ScriptStage(NameRestoreCleanup, true, true, getTimeout(NameRestoreCleanup), body.Id),
+		pipeline.ScriptStage(NamePostRestoreUnlock, true, true, getTimeout(NamePostRestoreUnlock)),
+	)
+	stages = pipeline.AddCustomStages(stages, "restore", getTimeout, body.Restore.Database, body.Id)
+
+	return &pipeline.Pipeline{
+		Stages: stages,
+		OnStageStarted: func(stage pipeline.Stage) {
+			response.State = stage.Name
+			response.Stages = httpBodies.SetStageResult(response.Stages, pipeline.GetRunningStageResult(stage.Name))
+			jobs.UpdateRestoreJob(body.Id, response)
+		},
+		OnStageFinished: func(result pipeline.Result) {
+			response.Stages = httpBodies.SetStageResult(response.Stages, pipeline.GetStageResult(result))
+			jobs.UpdateRestoreJob(body.Id, response)
+		},
+	}
+}
+
+// getResponseV1 returns the job in the shape of the v1 api. Its log fields are filled from the stages of the job,
+// so the logs are only stored once, and the download and its verification are reported as part of the restore stage
+// like before they had their own stages.
+func getResponseV1(job *httpBodies.RestoreResponse) interface{} {
+	response := *job
+	for _, stage := range job.Stages {
+		switch stage.Name {
+		case NamePreRestoreLock:
+			response.PreRestoreLockLog, response.PreRestoreLockErrorLog = stage.Stdout, stage.Stderr
+		case NameRestore:
+			response.RestoreLog, response.RestoreErrorLog = stage.Stdout, stage.Stderr
+		case NameRestoreCleanup:
+			response.RestoreCleanupLog, response.RestoreCleanupErrorLog = stage.Stdout, stage.Stderr
+		case NamePostRestoreUnlock:
+			response.PostRestoreUnlockLog, response.PostRestoreUnlockErrorLog = stage.Stdout, stage.Stderr
+		}
+	}
+	if response.State == NameDownload || response.State == NameVerify {
+		response.State = NameRestore
+	}
+	return httpBodies.GetRestoreResponseV1(&response)
+}
+
+// getScriptParams returns the parameters of the restore script. The agent decrypts the backups it encrypted itself,
+// so the key is only passed to the script for other backups, as every user of the machine can see it in the process list.
+func getScriptParams(body httpBodies.RestoreBody, format transferFormat) []string {
+	var scriptEncryptionKey = body.Encryption_key
+	if format.encryption != "" {
+		scriptEncryptionKey = ""
+	}
+	return []string{body.Restore.Host, body.Restore.Username, body.Restore.Password, body.Restore.Database,
+		body.Destination.Filename, body.Id, strconv.FormatBool(body.Compression), scriptEncryptionKey}
+}
+
+// download stores the backup in the restore directory and returns the format it was stored in.
+func download(ctx context.Context, body httpBodies.RestoreBody, response *httpBodies.RestoreResponse, downloadType string) (transferFormat, error) {
 	var restoreDirectory = configuration.GetRestoreDirectory() + "/" + body.Id
 	var path = errorlog.Concat([]string{restoreDirectory, "/", body.Destination.Filename}, "")
 	var err error
@@ -275,21 +527,201 @@ func download(body httpBodies.RestoreBody, downloadType string) error {
 			err := os.Remove(path)
 
 			if err != nil {
-				return errorlog.LogError(err.Error())
+				return transferFormat{}, errorlog.LogError(err.Error())
 			}
 		} else {
-			return errorlog.LogError("File already exists: ", path)
+			return transferFormat{}, errorlog.LogError("File already exists: ", path)
 		}
 	}
 	log.Println("Using file at", path)
 
-	if downloadType == "S3" {
-		log.Println("Using S3 as destination.")
-		err = s3.DownloadFile(body.Destination.Filename, path, body)
-	} else {
-		log.Println("Using swift as destination.")
-		err = swift.DownloadFile(body.Destination.Filename, path, body)
+	if err = ctx.Err(); err != nil {
+		return transferFormat{}, err
+	}
+
+	log.Println("Using", downloadType, "as destination.")
+	format, err := getTransferFormat(ctx, body, response)
+	if err != nil {
+		return format, err
+	}
+	if format == (transferFormat{}) {
+		_, err = storage.DownloadFile(ctx, body.Destination, body.Destination.Filename, path)
+		return format, err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return format, errorlog.LogError("Failed to create file ", path, " due to '", err.Error(), "'")
 	}
+	defer file.Close()
+	return format, downloadTransformed(ctx, body, format, file)
+}
+
+// transferFormat holds the algorithms the agent compressed and encrypted a backup with. Empty algorithms were not applied.
+type transferFormat struct {
+	compression string
+	encryption  string
+}
 
+// verify downloads and authenticates the complete backup without writing it anywhere, if the agent encrypted it.
+// This downloads the backup twice, but the restore script never reads data of a modified backup, unless the request skips the verification.
+func verify(ctx context.Context, body httpBodies.RestoreBody, format transferFormat) error {
+	if format.encryption == "" {
+		log.Println("The backup was not encrypted by the agent, there is nothing to verify.")
+		return nil
+	}
+	if body.Skip_verification {
+		log.Println("Skipping the verification of the backup as requested, modified data is only detected while the restore script reads it.")
+		return nil
+	}
+	log.Println("Verifying the backup before it is restored.")
+	return downloadTransformed(ctx, body, transferFormat{encryption: format.encryption}, ioutil.Discard)
+}
+
+// downloadTransformed writes the backup into the writer. Backups the agent encrypted are decrypted and then decompressed
+// while they are downloaded, the data of a chunk is only written once it was authenticated.
+func downloadTransformed(ctx context.Context, body httpBodies.RestoreBody, format transferFormat, writer io.Writer) error {
+	var decompressor, decryptor io.WriteCloser
+	if format.compression != "" {
+		log.Println("Decompressing the backup with", format.compression)
+		decompressor = compression.NewDecompressingWriter(writer, format.compression)
+		writer = decompressor
+	}
+	if format.encryption != "" {
+		log.Println("Decrypting the backup with", format.encryption)
+		decryptor = encryption.NewDecryptingWriter(writer, body.Encryption_key)
+		writer = decryptor
+	}
+
+	_, err := storage.DownloadStream(ctx, body.Destination, body.Destination.Filename, writer)
+	// The decryptor writes into the decompressor, so it has to be closed first
+	for _, closer := range []io.WriteCloser{decryptor, decompressor} {
+		if closer == nil {
+			continue
+		}
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
 	return err
 }
+
+// getTransferFormat returns the algorithms the agent compressed and encrypted the backup with and adds them to the response.
+// The algorithms of the request take precedence over the algorithms recorded in the metadata of the object.
+// Without both, the algorithms are detected from the start of the backup, as LOCAL and SFTP store no metadata.
+func getTransferFormat(ctx context.Context, body httpBodies.RestoreBody, response *httpBodies.RestoreResponse) (transferFormat, error) {
+	var format = transferFormat{compression: body.Compression_algorithm, encryption: body.Encryption_algorithm}
+	if format.compression == "" || format.encryption == "" {
+		backend, exists := storage.Get(body.Destination.Type)
+		if !exists {
+			return format, errorlog.LogError("No storage backend registered for type ", body.Destination.Type)
+		}
+		info, err := backend.Stat(ctx, body.Destination, body.Destination.Filename)
+		if err != nil {
+			return format, err
+		}
+		if format.compression == "" {
+			format.compression = info.Metadata[compression.MetadataKey]
+			if format.compression != "" && !httpBodies.IsValidCompression(format.compression, 0) {
+				return format, errorlog.LogError("The backup was compressed with the unsupported algorithm ", format.compression)
+			}
+		}
+		if format.encryption == "" {
+			format.encryption = info.Metadata[encryption.MetadataKey]
+			if format.encryption != "" && !httpBodies.IsValidEncryption(format.encryption) {
+				return format, errorlog.LogError("The backup was encrypted with the unsupported algorithm ", format.encryption)
+			}
+		}
+	}
+	// The restore script must never read data the agent encrypted, as it never gets the key
+	if format.encryption == "" {
+		var err error
+		if format.encryption, err = detectEncryption(ctx, body); err != nil {
+			return format, err
+		}
+	}
+	// Backups of scripts that compress the data themselves are decompressed by the scripts
+	if format.compression == "" && !body.Compression {
+		var err error
+		if format.compression, err = detectCompression(ctx, body, format.encryption); err != nil {
+			return format, err
+		}
+	}
+	if format.compression != "" {
+		log.Println("The backup was compressed with", format.compression)
+	}
+	if format.encryption != "" {
+		log.Println("The backup was encrypted with", format.encryption)
+	}
+	response.CompressionAlgorithm = format.compression
+	response.EncryptionAlgorithm = format.encryption
+	return format, nil
+}
+
+// detectionSize : Number of bytes at the start of a backup, which tell how the agent transformed it
+const detectionSize = 8
+
+// errStartRead : Stops the download of a backup, once its start was read
+var errStartRead = errors.New("stopped after the start of the backup was read")
+
+// startWriter keeps the first bytes written into it and fails afterwards, so the download of the rest of the backup stops.
+type startWriter struct {
+	start []byte
+	size  int
+}
+
+func (w *startWriter) Write(p []byte) (int, error) {
+	missing := w.size - len(w.start)
+	if len(p) < missing {
+		w.start = append(w.start, p...)
+		return len(p), nil
+	}
+	w.start = append(w.start, p[:missing]...)
+	return missing, errStartRead
+}
+
+// readStart downloads the first bytes of the backup, decrypted with the algorithm if it is not empty.
+// Backups smaller than the size are returned completely.
+func readStart(ctx context.Context, body httpBodies.RestoreBody, encryptionAlgorithm string, size int) ([]byte, error) {
+	writer := &startWriter{size: size}
+	err := downloadTransformed(ctx, body, transferFormat{encryption: encryptionAlgorithm}, writer)
+	if len(writer.start) == size {
+		// The error only reports the stopped download
+		return writer.start, nil
+	}
+	return writer.start, err
+}
+
+// detectEncryption returns the algorithm of the backup, if it starts with the header of the encryption of the agent,
+// or an empty string if the agent did not encrypt it.
+func detectEncryption(ctx context.Context, body httpBodies.RestoreBody) (string, error) {
+	log.Println("Neither the request nor the metadata name the encryption, detecting it from the start of the backup.")
+	start, err := readStart(ctx, body, "", detectionSize)
+	if err != nil {
+		return "", errorlog.LogError("Failed to read the start of the backup due to '", err.Error(), "'")
+	}
+	if encryption.IsEncrypted(start) {
+		return httpBodies.Encryption_aes256gcm, nil
+	}
+	return "", nil
+}
+
+// detectCompression returns the algorithm the agent compressed the backup with, judging by the magic bytes at its start,
+// or an empty string if the backup was not compressed. It fails, if the name has the extension of an algorithm the data was not compressed with.
+func detectCompression(ctx context.Context, body httpBodies.RestoreBody, encryptionAlgorithm string) (string, error) {
+	log.Println("Neither the request nor the metadata name the compression, detecting it from the start of the backup.")
+	start, err := readStart(ctx, body, encryptionAlgorithm, detectionSize)
+	if err != nil {
+		return "", errorlog.LogError("Failed to read the start of the backup due to '", err.Error(), "'")
+	}
+	algorithm := compression.Detect(start)
+	if algorithm == "" {
+		name := strings.TrimSuffix(body.Destination.Filename, encryption.FileExtension)
+		for _, candidate := range []string{httpBodies.Compression_gzip, httpBodies.Compression_zstd} {
+			if strings.HasSuffix(name, compression.GetFileExtension(candidate)) {
+				return "", errorlog.LogError("The name of the backup has the extension of ", candidate, ", but its data was not compressed with it")
+			}
+		}
+	}
+	return algorithm, nil
+}
@@ -0,0 +1,159 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/evoila/osb-backup-agent/compression"
+	"github.com/evoila/osb-backup-agent/encryption"
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/evoila/osb-backup-agent/local"
+	"github.com/evoila/osb-backup-agent/storage"
+)
+
+const testKey = "secret"
+
+// setUpLocalDestination registers the LOCAL backend, which stores no metadata, with a new directory. The caller has to remove the directory.
+func setUpLocalDestination(t *testing.T) string {
+	directory, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("directory_local_destination", directory)
+	storage.Register(local.Type, local.Backend{})
+	return directory
+}
+
+// storeBackup stores the data like the agent does, compressed and then encrypted with the algorithms that are not empty,
+// and returns the body of a restore request for it.
+func storeBackup(t *testing.T, name string, data []byte, compressionAlgorithm, encryptionAlgorithm string) httpBodies.RestoreBody {
+	var reader io.Reader = bytes.NewReader(data)
+	if compressionAlgorithm != "" {
+		compressed := compression.NewCompressingReader(reader, compressionAlgorithm, 0)
+		defer compressed.Close()
+		reader = compressed
+	}
+	if encryptionAlgorithm != "" {
+		encrypted := encryption.NewEncryptingReader(reader, testKey, "")
+		defer encrypted.Close()
+		reader = encrypted
+	}
+	destination := httpBodies.DestinationInformation{Type: local.Type, Path: "instance", Filename: name}
+	if _, err := (local.Backend{}).Upload(context.Background(), destination, name, reader, storage.UnknownSize); err != nil {
+		t.Fatal(err)
+	}
+	return httpBodies.RestoreBody{Id: "restore", Encryption_key: testKey, Destination: destination}
+}
+
+func getTestData() []byte {
+	return bytes.Repeat([]byte("INSERT INTO backups VALUES ('data');\n"), 5000)
+}
+
+func TestCompressionIsDetectedWithoutMetadata(t *testing.T) {
+	directory := setUpLocalDestination(t)
+	defer os.RemoveAll(directory)
+
+	for _, compressionAlgorithm := range []string{"", httpBodies.Compression_gzip, httpBodies.Compression_zstd} {
+		for _, encryptionAlgorithm := range []string{"", httpBodies.Encryption_aes256gcm} {
+			for _, data := range [][]byte{getTestData(), []byte("tiny")} {
+				body := storeBackup(t, "backup", data, compressionAlgorithm, encryptionAlgorithm)
+				body.Encryption_algorithm = encryptionAlgorithm
+
+				var response httpBodies.RestoreResponse
+				format, err := getTransferFormat(context.Background(), body, &response)
+				if err != nil || format.compression != compressionAlgorithm || response.CompressionAlgorithm != compressionAlgorithm {
+					t.Errorf("Expected to detect the compression %q of the backup encrypted with %q, got %+v and %v", compressionAlgorithm, encryptionAlgorithm, format, err)
+					continue
+				}
+
+				var restored bytes.Buffer
+				if err = downloadTransformed(context.Background(), body, format, &restored); err != nil || !bytes.Equal(restored.Bytes(), data) {
+					t.Errorf("Expected to restore the backup compressed with %q and encrypted with %q, got %d bytes and %v", compressionAlgorithm, encryptionAlgorithm, restored.Len(), err)
+				}
+			}
+		}
+	}
+}
+
+func TestCompressionOfTheScriptsIsNotDetected(t *testing.T) {
+	directory := setUpLocalDestination(t)
+	defer os.RemoveAll(directory)
+
+	body := storeBackup(t, "backup.gz", getTestData(), httpBodies.Compression_gzip, "")
+	body.Compression = true
+	var response httpBodies.RestoreResponse
+	if format, err := getTransferFormat(context.Background(), body, &response); err != nil || format.compression != "" {
+		t.Errorf("Expected the restore script to decompress its own backup, got %+v and %v", format, err)
+	}
+}
+
+func TestExtensionOfAnotherCompressionIsRejected(t *testing.T) {
+	directory := setUpLocalDestination(t)
+	defer os.RemoveAll(directory)
+
+	for _, name := range []string{"backup.gz", "backup.zst"} {
+		body := storeBackup(t, name, getTestData(), "", "")
+		var response httpBodies.RestoreResponse
+		if format, err := getTransferFormat(context.Background(), body, &response); err == nil {
+			t.Errorf("Expected the uncompressed data of %s to be rejected, got %+v", name, format)
+		}
+	}
+
+	body := storeBackup(t, "backup.gz", getTestData(), httpBodies.Compression_zstd, "")
+	var response httpBodies.RestoreResponse
+	if format, err := getTransferFormat(context.Background(), body, &response); err != nil || format.compression != httpBodies.Compression_zstd {
+		t.Errorf("Expected the data to take precedence over the extension, got %+v and %v", format, err)
+	}
+}
+
+func TestEncryptionIsDetectedWithoutMetadata(t *testing.T) {
+	directory := setUpLocalDestination(t)
+	defer os.RemoveAll(directory)
+
+	for _, compressionAlgorithm := range []string{"", httpBodies.Compression_gzip, httpBodies.Compression_zstd} {
+		data := getTestData()
+		body := storeBackup(t, "backup", data, compressionAlgorithm, httpBodies.Encryption_aes256gcm)
+
+		var response httpBodies.RestoreResponse
+		format, err := getTransferFormat(context.Background(), body, &response)
+		expected := transferFormat{compression: compressionAlgorithm, encryption: httpBodies.Encryption_aes256gcm}
+		if err != nil || format != expected || response.EncryptionAlgorithm != httpBodies.Encryption_aes256gcm {
+			t.Errorf("Expected to detect %+v, got %+v and %v", expected, format, err)
+			continue
+		}
+		if params := getScriptParams(body, format); params[len(params)-1] != "" {
+			t.Error("The key of the detected encryption was passed to the restore script")
+		}
+
+		var restored bytes.Buffer
+		if err = downloadTransformed(context.Background(), body, format, &restored); err != nil || !bytes.Equal(restored.Bytes(), data) {
+			t.Errorf("Expected to decrypt the backup compressed with %q, got %d bytes and %v", compressionAlgorithm, restored.Len(), err)
+		}
+	}
+}
+
+func TestDetectedEncryptionWithAWrongKeyIsRejected(t *testing.T) {
+	directory := setUpLocalDestination(t)
+	defer os.RemoveAll(directory)
+
+	body := storeBackup(t, "backup", getTestData(), "", httpBodies.Encryption_aes256gcm)
+	body.Encryption_key = "wrong"
+	body.Compression_algorithm = httpBodies.Compression_gzip
+	var response httpBodies.RestoreResponse
+	format, err := getTransferFormat(context.Background(), body, &response)
+	if err != nil || format.encryption != httpBodies.Encryption_aes256gcm {
+		t.Fatalf("Expected to detect the encryption, got %+v and %v", format, err)
+	}
+	if err = verify(context.Background(), body, format); err == nil {
+		t.Error("The backup was verified with a wrong key")
+	}
+
+	body.Compression_algorithm = ""
+	if format, err = getTransferFormat(context.Background(), body, &response); err == nil {
+		t.Errorf("Expected the detection of the compression to fail with a wrong key, got %+v", format)
+	}
+}
@@ -1,145 +1,472 @@
 package s3
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"os"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/evoila/osb-backup-agent/errorlog"
 	"github.com/evoila/osb-backup-agent/httpBodies"
-	"github.com/evoila/osb-backup-agent/mutex"
+	"github.com/evoila/osb-backup-agent/storage"
 )
 
-var sessionMutex mutex.Mutex
+// defaultRegion : Region used for custom endpoints, if the destination does not define one. Most S3-compatible servers ignore it.
+const defaultRegion = "us-east-1"
 
-func SetUpS3() {
-	sessionMutex = make(mutex.Mutex, 1)
-	sessionMutex.Release()
-}
+// Values of the sse field of a destination
+const sseAES256 = "AES256"
+const sseKMS = "aws:kms"
 
-// getSession creates a AWS S3 session with the provided credentials.
-// The ENV VARs are set and cleared in this method.
-// A mutex regulates access to the credentials and ensures the creation of the session with the correct credentials.
-func getSession(region, authkey, authSecret string) (*session.Session, error) {
-	sessionMutex.Acquire()
+// defaultRoleSessionName : Name of the role session, if the destination does not define one
+const defaultRoleSessionName = "osb-backup-agent"
 
-	// Setting credentials of this request
-	os.Setenv("AWS_ACCESS_KEY_ID", authkey)
-	os.Setenv("AWS_SECRET_ACCESS_KEY", authSecret)
+// getSession creates a AWS S3 session with the credentials and config of the destination.
+// Every session gets its own credentials provider, so sessions of concurrent jobs are created in parallel and
+// no credentials are exposed to other processes of the agent.
+func getSession(destination httpBodies.DestinationInformation) (*session.Session, error) {
+	config, err := getConfig(destination)
+	if err != nil {
+		return nil, err
+	}
+	if config.Credentials, err = getCredentials(destination, config); err != nil {
+		return nil, err
+	}
+
+	options := session.Options{Config: *config}
+	// The session would replace the ca_cert of the destination with the bundle of the AWS_CA_BUNDLE environment variable
+	if destination.Ca_cert != "" {
+		options.CustomCABundle = strings.NewReader(destination.Ca_cert)
+	}
 
 	log.Println("Creating S3 session ...")
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(region)},
-	)
+	return session.NewSessionWithOptions(options)
+}
+
+// getCredentials returns static credentials of the access key, which may belong to a temporary session.
+// With a role ARN, the role is assumed either with the static credentials or with a web identity token.
+func getCredentials(destination httpBodies.DestinationInformation, config *aws.Config) (*credentials.Credentials, error) {
+	var staticCredentials *credentials.Credentials
+	if destination.AuthKey != "" {
+		staticCredentials = credentials.NewStaticCredentials(destination.AuthKey, destination.AuthSecret, destination.Session_token)
+	}
+	if destination.Role_arn == "" {
+		return staticCredentials, nil
+	}
+
+	// STS is not called with the custom endpoint of the destination
+	stsSession, err := session.NewSession(&aws.Config{Region: config.Region, HTTPClient: config.HTTPClient, Credentials: staticCredentials})
+	if err != nil {
+		return nil, err
+	}
+	sessionName := destination.Role_session_name
+	if sessionName == "" {
+		sessionName = defaultRoleSessionName
+	}
 
-	//Clear credentials after use
-	os.Setenv("AWS_ACCESS_KEY_ID", "")
-	os.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	if destination.Web_identity_token != "" {
+		log.Println("Assuming role", destination.Role_arn, "with a web identity token")
+		provider := stscreds.NewWebIdentityRoleProviderWithOptions(sts.New(stsSession), destination.Role_arn, sessionName, webIdentityToken(destination.Web_identity_token))
+		return credentials.NewCredentials(provider), nil
+	}
+	log.Println("Assuming role", destination.Role_arn)
+	return stscreds.NewCredentials(stsSession, destination.Role_arn, func(provider *stscreds.AssumeRoleProvider) {
+		provider.RoleSessionName = sessionName
+		if destination.External_id != "" {
+			provider.ExternalID = aws.String(destination.External_id)
+		}
+	}), nil
+}
 
-	sessionMutex.Release()
+// webIdentityToken passes the web identity token of a request to the role provider, which usually reads it from a file.
+type webIdentityToken string
 
-	return sess, err
+func (t webIdentityToken) FetchToken(ctx credentials.Context) ([]byte, error) {
+	return []byte(t), nil
 }
 
-func UploadFile(filename, path string, body httpBodies.BackupBody) error {
+// Type : Name of the destination type handled by this backend
+const Type = "S3"
 
-	log.Println("Opening file at", path)
-	file, err := os.Open(path)
-	if err != nil {
-		return errorlog.LogError("Failed to open file ", path, " due to '", err.Error(), "'")
+// Backend stores backups in an S3 bucket.
+type Backend struct{}
+
+func (b Backend) Validate(destination httpBodies.DestinationInformation, fileCanBeMissing bool) string {
+	missingFields := ""
+	// A web identity token replaces the access key, but can only be used to assume a role
+	if destination.Web_identity_token != "" && destination.Role_arn == "" {
+		missingFields += " role_arn"
+	}
+	if destination.Web_identity_token == "" {
+		if destination.AuthKey == "" {
+			missingFields += " authKey"
+		}
+		if destination.AuthSecret == "" {
+			missingFields += " authSecret"
+		}
+	}
+	if destination.Bucket == "" {
+		missingFields += " bucket"
+	}
+	if destination.Region == "" && destination.Endpoint == "" {
+		missingFields += " region"
+	}
+	if destination.Sse != "" && destination.Sse != sseAES256 && destination.Sse != sseKMS {
+		missingFields += " valid sse (" + sseAES256 + " or " + sseKMS + ")"
+	}
+	if destination.Kms_key_id != "" && destination.Sse != sseKMS {
+		missingFields += " sse " + sseKMS + " for kms_key_id"
+	}
+	if destination.Sse_customer_key != "" {
+		if destination.Sse != "" {
+			missingFields += " either sse or sse_customer_key"
+		}
+		if _, err := getCustomerKey(destination); err != nil {
+			missingFields += " valid sse_customer_key"
+		}
+	}
+	if destination.Object_lock_mode != "" && destination.Object_lock_mode != s3.ObjectLockModeGovernance && destination.Object_lock_mode != s3.ObjectLockModeCompliance {
+		missingFields += " valid object_lock_mode (" + s3.ObjectLockModeGovernance + " or " + s3.ObjectLockModeCompliance + ")"
+	}
+	if destination.Object_lock_mode != "" || destination.Object_lock_retention != "" {
+		if retention, err := time.ParseDuration(destination.Object_lock_retention); err != nil || retention <= 0 {
+			missingFields += " valid object_lock_retention"
+		} else if destination.Object_lock_mode == "" {
+			missingFields += " object_lock_mode"
+		}
 	}
-	defer file.Close()
-	log.Println("Successfully opened file at", path)
+	if destination.Filename == "" && !fileCanBeMissing {
+		missingFields += " filename"
+	}
+	return missingFields
+}
 
-	// -- Creating S3 session and uploader --
-	sess, err := getSession(body.Destination.Region, body.Destination.AuthKey, body.Destination.AuthSecret)
+// Redact returns the fields of the S3 destination with its credentials replaced.
+func (b Backend) Redact(destination httpBodies.DestinationInformation) httpBodies.DestinationInformation {
+	return httpBodies.DestinationInformation{
+		Type:                   destination.Type,
+		Bucket:                 destination.Bucket,
+		Region:                 destination.Region,
+		Filename:               destination.Filename,
+		AuthKey:                destination.AuthKey,
+		AuthSecret:             httpBodies.GetRedactedOrEmptyPasswordString(destination.AuthSecret),
+		Session_token:          httpBodies.GetRedactedOrEmptyPasswordString(destination.Session_token),
+		Role_arn:               destination.Role_arn,
+		Role_session_name:      destination.Role_session_name,
+		External_id:            destination.External_id,
+		Web_identity_token:     httpBodies.GetRedactedOrEmptyPasswordString(destination.Web_identity_token),
+		Sse:                    destination.Sse,
+		Kms_key_id:             destination.Kms_key_id,
+		Sse_customer_key:       httpBodies.GetRedactedOrEmptyPasswordString(destination.Sse_customer_key),
+		Storage_class:          destination.Storage_class,
+		Tags:                   destination.Tags,
+		Metadata:               destination.Metadata,
+		Object_lock_mode:       destination.Object_lock_mode,
+		Object_lock_retention:  destination.Object_lock_retention,
+		Object_lock_legal_hold: destination.Object_lock_legal_hold,
+		Endpoint:               destination.Endpoint,
+		Path_style:             destination.Path_style,
+		Insecure_skip_verify:   destination.Insecure_skip_verify,
+		Ca_cert:                destination.Ca_cert,
+	}
+}
 
+func (b Backend) Upload(ctx context.Context, destination httpBodies.DestinationInformation, name string, reader io.Reader, size int64) (storage.ObjectInfo, error) {
+	sess, err := getSessionForDestination(destination)
 	if err != nil {
-		return errorlog.LogError("Unable to create a S3 session due to '", err.Error(), "'")
+		return storage.ObjectInfo{}, err
+	}
+
+	client := s3.New(sess)
+	if destination.Object_lock_mode != "" || destination.Object_lock_legal_hold {
+		if err = checkObjectLockEnabled(ctx, client, destination.Bucket); err != nil {
+			return storage.ObjectInfo{}, err
+		}
 	}
-	log.Println("Successfully created S3 session")
 
 	log.Println("Setting up S3 uploader")
 	var uploader = s3manager.NewUploader(sess)
-	//var client = s3.New(sess)
 
-	// -- Uploading the backup file to the given bucket --
-	log.Println("Uploading", filename, "to", body.Destination.Bucket)
-
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(body.Destination.Bucket),
-		Key:    aws.String(filename),
-		Body:   file,
-	})
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(destination.Bucket),
+		Key:    aws.String(name),
+		Body:   reader,
+	}
+	if err = setUploadOptions(input, destination); err != nil {
+		return storage.ObjectInfo{}, err
+	}
 
+	log.Println("Uploading", name, "to", destination.Bucket)
+	output, err := uploader.UploadWithContext(ctx, input)
 	if err != nil {
-		return errorlog.LogError("Failed to upload to S3 due to '", err.Error(), "'")
+		return storage.ObjectInfo{}, errorlog.LogError("Failed to upload to S3 due to '", err.Error(), "'")
 	}
-	log.Printf("Successfully uploaded %q to %q\n", filename, body.Destination.Bucket)
+	log.Printf("Successfully uploaded %q to %q\n", name, destination.Bucket)
 
-	return nil
+	// The stats of the uploaded version contain the retention that was actually applied, including a default retention of the bucket
+	info, err := headObject(ctx, client, destination, name, output.VersionID)
+	if err != nil {
+		log.Println("Reading the stats of the uploaded file failed due to", err.Error())
+		info = storage.ObjectInfo{Name: name, Size: size, Version: aws.StringValue(output.VersionID)}
+		// The retention requested by the upload was applied, only a default retention of the bucket is unknown without the stats
+		if input.ObjectLockMode != nil || input.ObjectLockLegalHoldStatus != nil {
+			info.Lock = &storage.ObjectLock{Mode: aws.StringValue(input.ObjectLockMode), RetainUntil: aws.TimeValue(input.ObjectLockRetainUntilDate),
+				LegalHold: input.ObjectLockLegalHoldStatus != nil}
+		}
+	}
+	return info, nil
 }
 
-func DownloadFile(filename, path string, body httpBodies.RestoreBody) error {
-
-	log.Println("Creating file at", path)
-	file, err := os.Create(path)
+func (b Backend) Download(ctx context.Context, destination httpBodies.DestinationInformation, name string, writer io.Writer) (int64, error) {
+	sess, err := getSessionForDestination(destination)
 	if err != nil {
-		return errorlog.LogError("Failed to create file ", path, " due to '", err.Error(), "'")
+		return 0, err
 	}
-	defer file.Close()
 
-	sess, err := getSession(body.Destination.Region, body.Destination.AuthKey, body.Destination.AuthSecret)
+	log.Println("Setting up S3 downloader")
+	var downloader *s3manager.Downloader
+	writerAt, isWriterAt := writer.(io.WriterAt)
+	if isWriterAt {
+		downloader = s3manager.NewDownloader(sess)
+	} else {
+		// Parts have to arrive in order if the target can not be written at arbitrary offsets
+		downloader = s3manager.NewDownloader(sess, func(d *s3manager.Downloader) {
+			d.Concurrency = 1
+		})
+		writerAt = &sequentialWriterAt{writer: writer}
+	}
 
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(destination.Bucket),
+		Key:    aws.String(name),
+	}
+	if input.SSECustomerAlgorithm, input.SSECustomerKey, err = getCustomerKeyOptions(destination); err != nil {
+		return 0, err
+	}
+	numBytes, err := downloader.DownloadWithContext(ctx, writerAt, input)
 	if err != nil {
-		return errorlog.LogError("Unable to create a S3 session due to '", err.Error(), "'")
+		return numBytes, errorlog.LogError("Failed to download the file ", name, "  due to '", err.Error(), "'")
 	}
+	return numBytes, nil
+}
 
-	log.Println("Sucessfully created S3 session")
+func (b Backend) Stat(ctx context.Context, destination httpBodies.DestinationInformation, name string) (storage.ObjectInfo, error) {
+	sess, err := getSessionForDestination(destination)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
 
-	log.Println("Setting up S3 downloader")
-	var downloader = s3manager.NewDownloader(sess)
-	//var client = s3.New(sess)
+	return headObject(ctx, s3.New(sess), destination, name, nil)
+}
 
-	numBytes, err := downloader.Download(file,
-		&s3.GetObjectInput{
-			Bucket: aws.String(body.Destination.Bucket),
-			Key:    aws.String(filename),
-		})
+func (b Backend) List(ctx context.Context, destination httpBodies.DestinationInformation, prefix string) ([]storage.ObjectInfo, error) {
+	sess, err := getSessionForDestination(destination)
 	if err != nil {
-		return errorlog.LogError("Failed to download the file ", filename, "  due to '", err.Error(), "'")
+		return nil, err
 	}
 
-	log.Println("Successfully downloaded", file.Name(), "(", numBytes, "bytes )")
+	var objects []storage.ObjectInfo
+	err = s3.New(sess).ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(destination.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, item := range page.Contents {
+			objects = append(objects, storage.ObjectInfo{Name: aws.StringValue(item.Key), Size: aws.Int64Value(item.Size), LastModified: aws.TimeValue(item.LastModified)})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errorlog.LogError("Failed to list the objects of bucket ", destination.Bucket, " due to '", err.Error(), "'")
+	}
+	return objects, nil
+}
 
+func (b Backend) Delete(ctx context.Context, destination httpBodies.DestinationInformation, name string) error {
+	sess, err := getSessionForDestination(destination)
+	if err != nil {
+		return err
+	}
+
+	_, err = s3.New(sess).DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(destination.Bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		return errorlog.LogError("Failed to delete ", name, " due to '", err.Error(), "'")
+	}
 	return nil
 }
 
-func listObjectsOfBucket(bucket string, client *s3.S3) error {
+func getSessionForDestination(destination httpBodies.DestinationInformation) (*session.Session, error) {
+	sess, err := getSession(destination)
+	if err != nil {
+		return nil, errorlog.LogError("Unable to create a S3 session due to '", err.Error(), "'")
+	}
+	log.Println("Successfully created S3 session")
+	return sess, nil
+}
+
+// getConfig returns the session config of the destination. A custom endpoint allows to use S3-compatible servers like MinIO or Ceph RGW,
+// which often need path-style addressing and use self-signed certificates.
+func getConfig(destination httpBodies.DestinationInformation) (*aws.Config, error) {
+	config := &aws.Config{Region: aws.String(destination.Region)}
+	if destination.Endpoint != "" {
+		config.Endpoint = aws.String(destination.Endpoint)
+		if destination.Region == "" {
+			config.Region = aws.String(defaultRegion)
+		}
+	}
+	if destination.Path_style {
+		config.S3ForcePathStyle = aws.Bool(true)
+	}
 
-	resp, err := client.ListObjects(&s3.ListObjectsInput{Bucket: aws.String(bucket)})
+	if destination.Insecure_skip_verify || destination.Ca_cert != "" {
+		tlsConfig := &tls.Config{InsecureSkipVerify: destination.Insecure_skip_verify}
+		if destination.Ca_cert != "" {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM([]byte(destination.Ca_cert)) {
+				return nil, errorlog.LogError("The ca_cert of the destination does not contain a valid PEM encoded certificate")
+			}
+		}
+		if destination.Insecure_skip_verify {
+			log.Println("Skipping the verification of the S3 endpoint's certificate")
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		config.HTTPClient = &http.Client{Transport: transport}
+	}
+	return config, nil
+}
+
+// headObject returns the stats of the given version of the object, or of the latest version if the version id is nil.
+func headObject(ctx context.Context, client *s3.S3, destination httpBodies.DestinationInformation, name string, versionId *string) (storage.ObjectInfo, error) {
+	input := &s3.HeadObjectInput{
+		Bucket:    aws.String(destination.Bucket),
+		Key:       aws.String(name),
+		VersionId: versionId,
+	}
+	// Objects encrypted with a customer key can not even be inspected without it
+	var err error
+	if input.SSECustomerAlgorithm, input.SSECustomerKey, err = getCustomerKeyOptions(destination); err != nil {
+		return storage.ObjectInfo{}, err
+	}
+	output, err := client.HeadObjectWithContext(ctx, input)
 	if err != nil {
-		return errorlog.LogError("Failed to list all buckets due to '", err.Error(), "'")
+		return storage.ObjectInfo{}, errorlog.LogError("Failed to get the stats of ", name, " due to '", err.Error(), "'")
 	}
 
-	log.Println("Objects of bucket", bucket, ":")
+	info := storage.ObjectInfo{Name: name, Size: aws.Int64Value(output.ContentLength), LastModified: aws.TimeValue(output.LastModified), Version: aws.StringValue(output.VersionId),
+		Metadata: storage.GetMetadataWithLowerCaseKeys(aws.StringValueMap(output.Metadata))}
+	legalHold := aws.StringValue(output.ObjectLockLegalHoldStatus) == s3.ObjectLockLegalHoldStatusOn
+	if output.ObjectLockMode != nil || legalHold {
+		info.Lock = &storage.ObjectLock{Mode: aws.StringValue(output.ObjectLockMode), RetainUntil: aws.TimeValue(output.ObjectLockRetainUntilDate), LegalHold: legalHold}
+	}
+	return info, nil
+}
 
-	for _, item := range resp.Contents {
-		fmt.Println("Name:         ", *item.Key)
-		fmt.Println("Last modified:", *item.LastModified)
-		fmt.Println("Size:         ", *item.Size)
-		fmt.Println("Storage class:", *item.StorageClass)
-		fmt.Println("")
+// checkObjectLockEnabled makes sure that the bucket supports object locks, before any data is uploaded.
+// Without it, S3 would reject the upload only after the whole file was sent.
+func checkObjectLockEnabled(ctx context.Context, client *s3.S3, bucket string) error {
+	output, err := client.GetObjectLockConfigurationWithContext(ctx, &s3.GetObjectLockConfigurationInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		return errorlog.LogError("The object lock configuration of bucket ", bucket, " is not readable, object lock has to be enabled on the bucket. The request failed due to '", err.Error(), "'")
+	}
+	if output.ObjectLockConfiguration == nil || aws.StringValue(output.ObjectLockConfiguration.ObjectLockEnabled) != s3.ObjectLockEnabledEnabled {
+		return errorlog.LogError("Object lock is not enabled on bucket ", bucket)
+	}
+	return nil
+}
 
+// setUploadOptions applies the server-side encryption, storage class, tags and metadata of the destination to the upload.
+func setUploadOptions(input *s3manager.UploadInput, destination httpBodies.DestinationInformation) error {
+	if destination.Sse != "" {
+		input.ServerSideEncryption = aws.String(destination.Sse)
+	}
+	if destination.Kms_key_id != "" {
+		input.SSEKMSKeyId = aws.String(destination.Kms_key_id)
+	}
+	var err error
+	if input.SSECustomerAlgorithm, input.SSECustomerKey, err = getCustomerKeyOptions(destination); err != nil {
+		return err
+	}
+	if destination.Storage_class != "" {
+		input.StorageClass = aws.String(destination.Storage_class)
+	}
+	if len(destination.Tags) > 0 {
+		tags := url.Values{}
+		for key, value := range destination.Tags {
+			tags.Set(key, value)
+		}
+		input.Tagging = aws.String(tags.Encode())
+	}
+	if len(destination.Metadata) > 0 {
+		input.Metadata = aws.StringMap(destination.Metadata)
 	}
 
+	if destination.Object_lock_mode != "" {
+		retention, err := time.ParseDuration(destination.Object_lock_retention)
+		if err != nil {
+			return errorlog.LogError("Parsing the object lock retention failed due to '", err.Error(), "'")
+		}
+		input.ObjectLockMode = aws.String(destination.Object_lock_mode)
+		input.ObjectLockRetainUntilDate = aws.Time(time.Now().Add(retention).UTC())
+	}
+	if destination.Object_lock_legal_hold {
+		input.ObjectLockLegalHoldStatus = aws.String(s3.ObjectLockLegalHoldStatusOn)
+	}
 	return nil
 }
 
+// getCustomerKeyOptions returns the algorithm and the key for server-side encryption with a customer provided key (SSE-C),
+// or nil if the destination does not use one. The same key is needed to upload, stat and download the object.
+func getCustomerKeyOptions(destination httpBodies.DestinationInformation) (*string, *string, error) {
+	if destination.Sse_customer_key == "" {
+		return nil, nil, nil
+	}
+	key, err := getCustomerKey(destination)
+	if err != nil {
+		return nil, nil, errorlog.LogError("The sse_customer_key is invalid due to '", err.Error(), "'")
+	}
+	// The SDK calculates the MD5 digest of the key and encodes it itself
+	return aws.String(sseAES256), aws.String(string(key)), nil
+}
+
+// getCustomerKey decodes the base64 encoded 256 bit key of the destination.
+func getCustomerKey(destination httpBodies.DestinationInformation) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(destination.Sse_customer_key)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, errors.New("the key has to be 256 bits long")
+	}
+	return key, nil
+}
+
+// sequentialWriterAt passes the parts of a download with a concurrency of 1 to a plain writer, as they arrive in order.
+type sequentialWriterAt struct {
+	writer io.Writer
+}
+
+func (w *sequentialWriterAt) WriteAt(p []byte, offset int64) (int, error) {
+	return w.writer.Write(p)
+}
+
 func listAllBuckets(client *s3.S3) error {
 	log.Println("Sending request for the bucket list.")
 	result, err := client.ListBuckets(nil)
@@ -0,0 +1,519 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/evoila/osb-backup-agent/storage"
+)
+
+const testBucket = "backups"
+const testAccessKey = "access"
+
+// Headers of an upload, which the fake service stores with the object and returns with its stats
+var storedHeaders = []string{"X-Amz-Object-Lock-Mode", "X-Amz-Object-Lock-Retain-Until-Date", "X-Amz-Object-Lock-Legal-Hold",
+	"X-Amz-Server-Side-Encryption", "X-Amz-Server-Side-Encryption-Customer-Algorithm", "X-Amz-Storage-Class", "X-Amz-Tagging"}
+
+const customerKeyHeader = "X-Amz-Server-Side-Encryption-Customer-Key"
+
+// fakeS3 implements the parts of the S3 REST API the backend and the transfer managers of the SDK use, with path-style addressing.
+// Listings return two objects per page.
+type fakeS3 struct {
+	mutex       sync.Mutex
+	server      *httptest.Server
+	objects     map[string]fakeObject
+	uploads     map[string]*fakeMultipartUpload
+	lockEnabled bool
+	// failHead makes the stats of every object unreadable
+	failHead bool
+	// requests holds the method and the path of every request
+	requests []string
+}
+
+type fakeObject struct {
+	content  []byte
+	header   http.Header
+	version  string
+	modified time.Time
+}
+
+type fakeMultipartUpload struct {
+	key    string
+	header http.Header
+	parts  map[int][]byte
+}
+
+// newFakeS3 starts the fake service with a TLS server, whose certificate is returned as the ca_cert of the destination.
+// The server has to be closed by the caller.
+func newFakeS3(lockEnabled bool) (*fakeS3, httpBodies.DestinationInformation) {
+	s := &fakeS3{objects: make(map[string]fakeObject), uploads: make(map[string]*fakeMultipartUpload), lockEnabled: lockEnabled}
+	s.server = httptest.NewTLSServer(s)
+	destination := httpBodies.DestinationInformation{
+		Type:       Type,
+		Bucket:     testBucket,
+		AuthKey:    testAccessKey,
+		AuthSecret: "secret",
+		Endpoint:   s.server.URL,
+		Path_style: true,
+		Ca_cert:    string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.server.Certificate().Raw})),
+	}
+	return s, destination
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+testAccessKey+"/") {
+		writeError(w, http.StatusForbidden, "InvalidAccessKeyId")
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/"+testBucket) {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+testBucket), "/")
+	query := r.URL.Query()
+	body, _ := ioutil.ReadAll(r.Body)
+
+	switch {
+	case key == "" && r.Method == http.MethodGet && query["object-lock"] != nil:
+		if !s.lockEnabled {
+			writeError(w, http.StatusNotFound, "ObjectLockConfigurationNotFoundError")
+			return
+		}
+		writeXML(w, "<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled></ObjectLockConfiguration>")
+	case key == "" && r.Method == http.MethodGet && query.Get("list-type") == "2":
+		s.list(w, query.Get("prefix"), query.Get("continuation-token"))
+	case key == "":
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	case r.Method == http.MethodPost && query["uploads"] != nil:
+		id := strconv.Itoa(len(s.uploads) + 1)
+		s.uploads[id] = &fakeMultipartUpload{key: key, header: r.Header, parts: make(map[int][]byte)}
+		writeXML(w, "<InitiateMultipartUploadResult><Bucket>"+testBucket+"</Bucket><Key>"+key+"</Key><UploadId>"+id+"</UploadId></InitiateMultipartUploadResult>")
+	case r.Method == http.MethodPut && query.Get("uploadId") != "":
+		upload, exists := s.uploads[query.Get("uploadId")]
+		if !exists {
+			writeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		upload.parts[partNumber] = body
+		w.Header().Set("ETag", getETag(body))
+	case r.Method == http.MethodPost && query.Get("uploadId") != "":
+		s.completeUpload(w, query.Get("uploadId"), body)
+	case r.Method == http.MethodDelete && query.Get("uploadId") != "":
+		delete(s.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		s.store(w, key, body, r.Header)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.get(w, r, key)
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (s *fakeS3) completeUpload(w http.ResponseWriter, id string, body []byte) {
+	upload, exists := s.uploads[id]
+	if !exists {
+		writeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	var request struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(body, &request); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	var content []byte
+	for _, part := range request.Parts {
+		data, exists := upload.parts[part.PartNumber]
+		if !exists || getETag(data) != part.ETag {
+			writeError(w, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		content = append(content, data...)
+	}
+	delete(s.uploads, id)
+	if s.store(w, upload.key, content, upload.header) {
+		writeXML(w, "<CompleteMultipartUploadResult><Bucket>"+testBucket+"</Bucket><Key>"+upload.key+"</Key><ETag>"+getETag(content)+"</ETag></CompleteMultipartUploadResult>")
+	}
+}
+
+// store creates a new version of the object and writes its version and etag as headers of the response.
+func (s *fakeS3) store(w http.ResponseWriter, key string, content []byte, header http.Header) bool {
+	if header.Get("X-Amz-Object-Lock-Mode") != "" && !s.lockEnabled {
+		writeError(w, http.StatusBadRequest, "InvalidRequest")
+		return false
+	}
+	object := fakeObject{content: content, header: http.Header{}, version: "v" + strconv.Itoa(len(s.requests)), modified: time.Now()}
+	for name, values := range header {
+		if strings.HasPrefix(name, "X-Amz-Meta-") {
+			object.header[name] = values
+		}
+	}
+	for _, name := range append(storedHeaders, customerKeyHeader) {
+		if value := header.Get(name); value != "" {
+			object.header.Set(name, value)
+		}
+	}
+	s.objects[key] = object
+
+	w.Header().Set("ETag", getETag(content))
+	w.Header().Set("X-Amz-Version-Id", object.version)
+	return true
+}
+
+func (s *fakeS3) get(w http.ResponseWriter, r *http.Request, key string) {
+	object, exists := s.objects[key]
+	if !exists {
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	if s.failHead && r.Method == http.MethodHead {
+		writeError(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	if r.Header.Get(customerKeyHeader) != object.header.Get(customerKeyHeader) {
+		writeError(w, http.StatusBadRequest, "InvalidRequest")
+		return
+	}
+
+	for name, values := range object.header {
+		if name != customerKeyHeader {
+			w.Header()[name] = values
+		}
+	}
+	w.Header().Set("X-Amz-Version-Id", object.version)
+	w.Header().Set("Last-Modified", object.modified.UTC().Format(http.TimeFormat))
+	w.Header().Set("ETag", getETag(object.content))
+
+	// The downloader of the SDK requests the object in ranges like "bytes=0-5242879"
+	content, status := object.content, http.StatusOK
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		bounds := strings.SplitN(strings.TrimPrefix(rangeHeader, "bytes="), "-", 2)
+		start, _ := strconv.Atoi(bounds[0])
+		end, _ := strconv.Atoi(bounds[1])
+		if end >= len(content) {
+			end = len(content) - 1
+		}
+		w.Header().Set("Content-Range", "bytes "+strconv.Itoa(start)+"-"+strconv.Itoa(end)+"/"+strconv.Itoa(len(content)))
+		content, status = content[start:end+1], http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(content)
+	}
+}
+
+func (s *fakeS3) list(w http.ResponseWriter, prefix, continuationToken string) {
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) && key > continuationToken {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := "<ListBucketResult><Name>" + testBucket + "</Name><Prefix>" + prefix + "</Prefix><MaxKeys>2</MaxKeys>"
+	for i, key := range keys {
+		if i == 2 {
+			result += "<IsTruncated>true</IsTruncated><NextContinuationToken>" + keys[1] + "</NextContinuationToken>"
+			break
+		}
+		object := s.objects[key]
+		result += "<Contents><Key>" + key + "</Key><LastModified>" + object.modified.UTC().Format("2006-01-02T15:04:05.000Z") +
+			"</LastModified><Size>" + strconv.Itoa(len(object.content)) + "</Size></Contents>"
+	}
+	writeXML(w, result+"</ListBucketResult>")
+}
+
+func getETag(content []byte) string {
+	sum := md5.Sum(content)
+	return "\"" + hex.EncodeToString(sum[:]) + "\""
+}
+
+func writeXML(w http.ResponseWriter, content string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header + content))
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header + "<Error><Code>" + code + "</Code><Message>" + code + "</Message><RequestId>1</RequestId></Error>"))
+}
+
+func getTestData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7 % 251)
+	}
+	return data
+}
+
+func (s *fakeS3) countRequests(method string) int {
+	count := 0
+	for _, request := range s.requests {
+		if strings.HasPrefix(request, method+" /"+testBucket+"/") {
+			count++
+		}
+	}
+	return count
+}
+
+func assertDownload(t *testing.T, destination httpBodies.DestinationInformation, name string, expected []byte) {
+	var downloaded bytes.Buffer
+	n, err := Backend{}.Download(context.Background(), destination, name, &downloaded)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if n != int64(len(expected)) || !bytes.Equal(downloaded.Bytes(), expected) {
+		t.Errorf("The downloaded data differs from the uploaded data, got %d bytes", n)
+	}
+}
+
+func TestUploadAndDownload(t *testing.T) {
+	s, destination := newFakeS3(false)
+	defer s.server.Close()
+	destination.Metadata = map[string]string{"Compression": "gzip"}
+	data := getTestData(1000)
+
+	info, err := Backend{}.Upload(context.Background(), destination, "db.tar.gz", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if info.Size != int64(len(data)) || info.Version == "" || info.Metadata["compression"] != "gzip" || info.Lock != nil {
+		t.Errorf("Unexpected stats of the upload: %+v", info)
+	}
+	assertDownload(t, destination, "db.tar.gz", data)
+
+	// Files can be written at arbitrary offsets and receive the parts concurrently
+	file, err := ioutil.TempFile("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if _, err = (Backend{}).Download(context.Background(), destination, "db.tar.gz", file); err != nil {
+		t.Fatalf("The download into a file failed: %v", err)
+	}
+	if downloaded, _ := ioutil.ReadFile(file.Name()); !bytes.Equal(downloaded, data) {
+		t.Error("The file differs from the uploaded data")
+	}
+}
+
+func TestMultipartUploadOfUnknownSize(t *testing.T) {
+	s, destination := newFakeS3(false)
+	defer s.server.Close()
+	data := getTestData(11 * 1024 * 1024)
+
+	info, err := Backend{}.Upload(context.Background(), destination, "db.tar", ioutil.NopCloser(bytes.NewReader(data)), -1)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if info.Size != int64(len(data)) {
+		t.Errorf("Expected an object of %d bytes, got %d", len(data), info.Size)
+	}
+	if parts := s.countRequests(http.MethodPut); parts != 3 {
+		t.Errorf("Expected 3 parts of 5 MiB at most, got %d", parts)
+	}
+	assertDownload(t, destination, "db.tar", data)
+}
+
+func TestObjectLockIsAppliedToTheUpload(t *testing.T) {
+	s, destination := newFakeS3(true)
+	defer s.server.Close()
+	destination.Object_lock_mode = "GOVERNANCE"
+	destination.Object_lock_retention = "24h"
+	destination.Object_lock_legal_hold = true
+
+	info, err := Backend{}.Upload(context.Background(), destination, "db.tar", strings.NewReader("data"), 4)
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	assertLock(t, info.Lock)
+}
+
+func assertLock(t *testing.T, lock *storage.ObjectLock) {
+	if lock == nil || lock.Mode != "GOVERNANCE" || !lock.LegalHold {
+		t.Fatalf("Expected a governance lock with a legal hold, got %+v", lock)
+	}
+	if until := time.Until(lock.RetainUntil); until < 23*time.Hour || until > 24*time.Hour {
+		t.Errorf("Expected the object to be retained for 24 hours, got %v", lock.RetainUntil)
+	}
+}
+
+func TestUploadFailsBeforeSendingDataIfObjectLockIsDisabled(t *testing.T) {
+	s, destination := newFakeS3(false)
+	defer s.server.Close()
+	destination.Object_lock_mode = "COMPLIANCE"
+	destination.Object_lock_retention = "1h"
+
+	_, err := Backend{}.Upload(context.Background(), destination, "db.tar", strings.NewReader("data"), 4)
+	if err == nil || !strings.Contains(err.Error(), "object lock") {
+		t.Errorf("Expected the upload to fail due to the object lock, got %v", err)
+	}
+	if puts := s.countRequests(http.MethodPut) + s.countRequests(http.MethodPost); puts != 0 {
+		t.Errorf("Expected no data to be sent, got %d requests", puts)
+	}
+}
+
+func TestRequestedLockIsReportedWithoutStats(t *testing.T) {
+	s, destination := newFakeS3(true)
+	defer s.server.Close()
+	s.failHead = true
+	destination.Object_lock_mode = "GOVERNANCE"
+	destination.Object_lock_retention = "24h"
+	destination.Object_lock_legal_hold = true
+
+	info, err := Backend{}.Upload(context.Background(), destination, "db.tar", strings.NewReader("data"), 4)
+	if err != nil {
+		t.Fatalf("The upload failed although only the stats are unreadable: %v", err)
+	}
+	if info.Size != 4 || info.Version == "" {
+		t.Errorf("Expected the size and the version of the upload, got %+v", info)
+	}
+	assertLock(t, info.Lock)
+}
+
+func TestUploadOptionsAreSent(t *testing.T) {
+	s, destination := newFakeS3(false)
+	defer s.server.Close()
+	destination.Sse = sseAES256
+	destination.Storage_class = "STANDARD_IA"
+	destination.Tags = map[string]string{"service": "db", "plan": "small"}
+
+	if _, err := (Backend{}).Upload(context.Background(), destination, "db.tar", strings.NewReader("data"), 4); err != nil {
+		t.Fatal(err)
+	}
+	header := s.objects["db.tar"].header
+	if header.Get("X-Amz-Server-Side-Encryption") != sseAES256 || header.Get("X-Amz-Storage-Class") != "STANDARD_IA" ||
+		header.Get("X-Amz-Tagging") != "plan=small&service=db" {
+		t.Errorf("The options of the destination were not sent with the upload: %v", header)
+	}
+}
+
+func TestCustomerKeyIsNeededForTheObject(t *testing.T) {
+	s, destination := newFakeS3(false)
+	defer s.server.Close()
+	destination.Sse_customer_key = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	data := getTestData(1000)
+
+	if _, err := (Backend{}).Upload(context.Background(), destination, "db.tar", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	assertDownload(t, destination, "db.tar", data)
+
+	destination.Sse_customer_key = ""
+	if _, err := (Backend{}).Stat(context.Background(), destination, "db.tar"); err == nil {
+		t.Error("The object was readable without the customer key")
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	s, destination := newFakeS3(false)
+	defer s.server.Close()
+	for _, name := range []string{"db_1.tar", "db_2.tar", "db_3.tar", "other.tar"} {
+		if _, err := (Backend{}).Upload(context.Background(), destination, name, strings.NewReader(name), int64(len(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objects, err := Backend{}.List(context.Background(), destination, "db_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 3 || objects[2].Name != "db_3.tar" || objects[2].Size != int64(len("db_3.tar")) {
+		t.Errorf("Expected the three objects with the prefix over all pages, got %+v", objects)
+	}
+
+	if err = (Backend{}).Delete(context.Background(), destination, "db_1.tar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = (Backend{}).Stat(context.Background(), destination, "db_1.tar"); err == nil {
+		t.Error("The deleted object still exists")
+	}
+}
+
+func TestWrongAccessKeyIsRejected(t *testing.T) {
+	s, destination := newFakeS3(false)
+	defer s.server.Close()
+	destination.AuthKey = "other"
+
+	_, err := Backend{}.Upload(context.Background(), destination, "db.tar", strings.NewReader("data"), 4)
+	if err == nil || !strings.Contains(err.Error(), "InvalidAccessKeyId") {
+		t.Errorf("Expected the upload to be rejected, got %v", err)
+	}
+}
+
+func TestUntrustedCertificateIsRejected(t *testing.T) {
+	s, destination := newFakeS3(false)
+	defer s.server.Close()
+	destination.Ca_cert = ""
+
+	if _, err := (Backend{}).Stat(context.Background(), destination, "db.tar"); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("Expected the certificate of the endpoint to be rejected, got %v", err)
+	}
+
+	destination.Insecure_skip_verify = true
+	if _, err := (Backend{}).Upload(context.Background(), destination, "db.tar", strings.NewReader("data"), 4); err != nil {
+		t.Errorf("Expected the upload to skip the verification of the certificate, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := httpBodies.DestinationInformation{Type: Type, Bucket: testBucket, AuthKey: testAccessKey, AuthSecret: "secret", Region: "eu-central-1", Filename: "db.tar"}
+	tests := []struct {
+		name          string
+		change        func(destination *httpBodies.DestinationInformation)
+		missingFields string
+	}{
+		{"valid", func(d *httpBodies.DestinationInformation) {}, ""},
+		{"endpoint without region", func(d *httpBodies.DestinationInformation) { d.Region, d.Endpoint = "", "https://minio:9000" }, ""},
+		{"missing fields", func(d *httpBodies.DestinationInformation) { *d = httpBodies.DestinationInformation{} }, " authKey authSecret bucket region filename"},
+		{"web identity without role", func(d *httpBodies.DestinationInformation) { d.AuthKey, d.Web_identity_token = "", "token" }, " role_arn"},
+		{"invalid sse", func(d *httpBodies.DestinationInformation) { d.Sse = "DES" }, " valid sse (AES256 or aws:kms)"},
+		{"kms key without kms", func(d *httpBodies.DestinationInformation) { d.Kms_key_id = "key" }, " sse aws:kms for kms_key_id"},
+		{"short customer key", func(d *httpBodies.DestinationInformation) { d.Sse_customer_key = "c2hvcnQ=" }, " valid sse_customer_key"},
+		{"invalid lock mode", func(d *httpBodies.DestinationInformation) {
+			d.Object_lock_mode, d.Object_lock_retention = "FOREVER", "1h"
+		},
+			" valid object_lock_mode (GOVERNANCE or COMPLIANCE)"},
+		{"lock without retention", func(d *httpBodies.DestinationInformation) { d.Object_lock_mode = "GOVERNANCE" }, " valid object_lock_retention"},
+		{"retention without lock", func(d *httpBodies.DestinationInformation) { d.Object_lock_retention = "1h" }, " object_lock_mode"},
+	}
+	for _, test := range tests {
+		destination := valid
+		test.change(&destination)
+		if missingFields := (Backend{}).Validate(destination, false); missingFields != test.missingFields {
+			t.Errorf("%s: expected the missing fields %q, got %q", test.name, test.missingFields, missingFields)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/errorlog"
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/evoila/osb-backup-agent/storage"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Type : Name of the destination type handled by this backend
+const Type = "SFTP"
+
+// DefaultPort : Port used if the destination does not define one
+const DefaultPort = 22
+
+// partialFileSuffix : Suffix of files that are still being uploaded, they are renamed once the upload is complete
+const partialFileSuffix = ".part"
+
+// maxTransferAttempts : Number of attempts of an upload or a download, later attempts resume where the previous one stopped
+const maxTransferAttempts = 3
+
+const connectTimeout = 30 * time.Second
+
+// Backend stores backups in a directory on an SFTP server.
+type Backend struct{}
+
+// connection bundles the ssh connection and the sftp session on top of it.
+type connection struct {
+	ssh  *ssh.Client
+	sftp *sftp.Client
+	stop chan struct{}
+}
+
+func (b Backend) Validate(destination httpBodies.DestinationInformation, fileCanBeMissing bool) string {
+	missingFields := ""
+	if destination.Host == "" {
+		missingFields += " host"
+	}
+	if destination.Port < 0 || destination.Port > 65535 {
+		missingFields += " valid port"
+	}
+	if destination.Username == "" {
+		missingFields += " username"
+	}
+	if destination.Password == "" && destination.Private_key == "" {
+		missingFields += " password/private_key"
+	}
+	if destination.Host_key_fingerprint == "" {
+		missingFields += " host_key_fingerprint"
+	}
+	if destination.Remote_directory == "" {
+		missingFields += " remote_directory"
+	}
+	if destination.Filename == "" && !fileCanBeMissing {
+		missingFields += " filename"
+	} else if destination.Filename != "" && !isValidFileName(destination.Filename) {
+		missingFields += " valid filename"
+	}
+	return missingFields
+}
+
+// Redact returns the fields of the SFTP destination with its credentials replaced.
+func (b Backend) Redact(destination httpBodies.DestinationInformation) httpBodies.DestinationInformation {
+	return httpBodies.DestinationInformation{
+		Type:                 destination.Type,
+		Host:                 destination.Host,
+		Port:                 destination.Port,
+		Filename:             destination.Filename,
+		Username:             destination.Username,
+		Password:             httpBodies.GetRedactedOrEmptyPasswordString(destination.Password),
+		Private_key:          httpBodies.GetRedactedOrEmptyPasswordString(destination.Private_key),
+		Host_key_fingerprint: destination.Host_key_fingerprint,
+		Remote_directory:     destination.Remote_directory,
+	}
+}
+
+// Upload writes the content into a partial file first, which is renamed once it is complete.
+// If the reader is seekable, failed attempts are resumed at the size of the partial file on the server.
+func (b Backend) Upload(ctx context.Context, destination httpBodies.DestinationInformation, name string, reader io.Reader, size int64) (storage.ObjectInfo, error) {
+	remotePath, err := getRemotePath(destination, name)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+
+	_, seekable := reader.(io.Seeker)
+	for attempt := 1; attempt <= maxTransferAttempts; attempt++ {
+		err = upload(ctx, destination, remotePath, reader, attempt > 1)
+		if err == nil || ctx.Err() != nil || !seekable {
+			break
+		}
+		log.Println("Upload attempt", attempt, "of", maxTransferAttempts, "to", destination.Host, "failed due to", err.Error())
+	}
+	if err != nil {
+		return storage.ObjectInfo{}, errorlog.LogError("Failed to upload ", name, " to ", destination.Host, " due to '", err.Error(), "'")
+	}
+	log.Printf("Successfully uploaded %q to %q on %q\n", name, destination.Remote_directory, destination.Host)
+
+	return b.Stat(ctx, destination, name)
+}
+
+// Download resumes failed attempts at the number of bytes already written.
+func (b Backend) Download(ctx context.Context, destination httpBodies.DestinationInformation, name string, writer io.Writer) (int64, error) {
+	remotePath, err := getRemotePath(destination, name)
+	if err != nil {
+		return 0, err
+	}
+
+	var written int64
+	for attempt := 1; attempt <= maxTransferAttempts; attempt++ {
+		var n int64
+		n, err = download(ctx, destination, remotePath, writer, written)
+		written += n
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		log.Println("Download attempt", attempt, "of", maxTransferAttempts, "from", destination.Host, "failed after", written, "bytes due to", err.Error())
+	}
+	if err != nil {
+		return written, errorlog.LogError("Failed to download ", name, " from ", destination.Host, " due to '", err.Error(), "'")
+	}
+	return written, nil
+}
+
+func (b Backend) Stat(ctx context.Context, destination httpBodies.DestinationInformation, name string) (storage.ObjectInfo, error) {
+	remotePath, err := getRemotePath(destination, name)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+
+	c, err := connect(ctx, destination)
+	if err != nil {
+		return storage.ObjectInfo{}, err
+	}
+	defer c.close()
+
+	stat, err := c.sftp.Stat(remotePath)
+	if err != nil {
+		return storage.ObjectInfo{}, errorlog.LogError("Accessing file stats of ", remotePath, " failed due to '", err.Error(), "'")
+	}
+	return storage.ObjectInfo{Name: name, Size: stat.Size(), LastModified: stat.ModTime()}, nil
+}
+
+func (b Backend) List(ctx context.Context, destination httpBodies.DestinationInformation, prefix string) ([]storage.ObjectInfo, error) {
+	c, err := connect(ctx, destination)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	files, err := c.sftp.ReadDir(destination.Remote_directory)
+	if err != nil {
+		return nil, errorlog.LogError("Reading the directory ", destination.Remote_directory, " failed due to '", err.Error(), "'")
+	}
+
+	var objects []storage.ObjectInfo
+	for _, f := range files {
+		if f.IsDir() || strings.HasSuffix(f.Name(), partialFileSuffix) || !strings.HasPrefix(f.Name(), prefix) {
+			continue
+		}
+		objects = append(objects, storage.ObjectInfo{Name: f.Name(), Size: f.Size(), LastModified: f.ModTime()})
+	}
+	return objects, nil
+}
+
+func (b Backend) Delete(ctx context.Context, destination httpBodies.DestinationInformation, name string) error {
+	remotePath, err := getRemotePath(destination, name)
+	if err != nil {
+		return err
+	}
+
+	c, err := connect(ctx, destination)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if err = c.sftp.Remove(remotePath); err != nil {
+		return errorlog.LogError("Removing ", remotePath, " failed due to '", err.Error(), "'")
+	}
+	return nil
+}
+
+func upload(ctx context.Context, destination httpBodies.DestinationInformation, remotePath string, reader io.Reader, resume bool) error {
+	c, err := connect(ctx, destination)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if err = c.sftp.MkdirAll(path.Dir(remotePath)); err != nil {
+		return err
+	}
+
+	var partialPath = remotePath + partialFileSuffix
+	var offset int64
+	if resume {
+		if stat, err := c.sftp.Stat(partialPath); err == nil {
+			offset = stat.Size()
+		}
+		if _, err = reader.(io.Seeker).Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		log.Println("Resuming the upload of", remotePath, "at", offset, "bytes")
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	file, err := c.sftp.OpenFile(partialPath, flags)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err == nil {
+		_, err = file.ReadFrom(reader)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Not every server supports the posix rename extension, which replaces existing files
+	if err = c.sftp.PosixRename(partialPath, remotePath); err != nil {
+		c.sftp.Remove(remotePath)
+		err = c.sftp.Rename(partialPath, remotePath)
+	}
+	return err
+}
+
+func download(ctx context.Context, destination httpBodies.DestinationInformation, remotePath string, writer io.Writer, offset int64) (int64, error) {
+	c, err := connect(ctx, destination)
+	if err != nil {
+		return 0, err
+	}
+	defer c.close()
+
+	file, err := c.sftp.Open(remotePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if offset > 0 {
+		log.Println("Resuming the download of", remotePath, "at", offset, "bytes")
+		if _, err = file.Seek(offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+	return io.Copy(writer, file)
+}
+
+// connect opens an sftp session to the server of the destination. The connection is closed as soon as the context is done,
+// which aborts all running transfers.
+func connect(ctx context.Context, destination httpBodies.DestinationInformation) (*connection, error) {
+	config, err := getClientConfig(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	port := destination.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+	address := net.JoinHostPort(destination.Host, strconv.Itoa(port))
+
+	log.Println("Connecting to", address, "as", destination.Username)
+	dialer := net.Dialer{Timeout: connectTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, errorlog.LogError("Connecting to ", address, " failed due to '", err.Error(), "'")
+	}
+
+	sshConn, channels, requests, err := ssh.NewClientConn(netConn, address, config)
+	if err != nil {
+		netConn.Close()
+		return nil, errorlog.LogError("Establishing an ssh connection to ", address, " failed due to '", err.Error(), "'")
+	}
+	sshClient := ssh.NewClient(sshConn, channels, requests)
+
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, errorlog.LogError("Starting an sftp session on ", address, " failed due to '", err.Error(), "'")
+	}
+
+	// Closing the ssh connection aborts all transfers, when the context is done before the connection is closed
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			sshClient.Close()
+		case <-stop:
+		}
+	}()
+	return &connection{ssh: sshClient, sftp: sftpClient, stop: stop}, nil
+}
+
+func (c *connection) close() {
+	close(c.stop)
+	c.sftp.Close()
+	c.ssh.Close()
+}
+
+func getClientConfig(destination httpBodies.DestinationInformation) (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+	if destination.Private_key != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(destination.Private_key))
+		if err != nil {
+			return nil, errorlog.LogError("Parsing the private key failed due to '", err.Error(), "'")
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if destination.Password != "" {
+		auth = append(auth, ssh.Password(destination.Password))
+	}
+
+	return &ssh.ClientConfig{
+		User:            destination.Username,
+		Auth:            auth,
+		HostKeyCallback: getHostKeyCallback(destination.Host_key_fingerprint),
+		Timeout:         connectTimeout,
+	}, nil
+}
+
+// getHostKeyCallback only accepts the host key with the given fingerprint, either in the SHA256 format of
+// ssh-keygen ("SHA256:...") or in the legacy MD5 format ("aa:bb:...").
+func getHostKeyCallback(fingerprint string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if fingerprint == ssh.FingerprintSHA256(key) || strings.EqualFold(strings.TrimPrefix(fingerprint, "MD5:"), ssh.FingerprintLegacyMD5(key)) {
+			return nil
+		}
+		return errorlog.LogError("The host key of ", hostname, " with the fingerprint ", ssh.FingerprintSHA256(key), " does not match the expected fingerprint")
+	}
+}
+
+// getRemotePath returns the path of the file with the given name within the remote directory of the destination.
+func getRemotePath(destination httpBodies.DestinationInformation, name string) (string, error) {
+	if !isValidFileName(name) {
+		return "", errorlog.LogError("The filename '", name, "' is not a valid name of a file")
+	}
+	return path.Join(destination.Remote_directory, name), nil
+}
+
+// isValidFileName only allows plain names, so a file can not be read or written outside of the remote directory.
+func isValidFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\") && !strings.HasSuffix(name, partialFileSuffix)
+}
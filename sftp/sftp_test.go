@@ -0,0 +1,366 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const testUser = "backup"
+const testPassword = "secret"
+
+// testServer is an in-process SFTP server, which serves the local file system.
+type testServer struct {
+	listener   net.Listener
+	hostKey    ssh.Signer
+	clientKey  string
+	directory  string
+	sessions   int32
+	failWrites bool
+}
+
+func newTestServer(t *testing.T) *testServer {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPublicKey, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKeyBytes, err := x509.MarshalPKCS8PrivateKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizedKey, err := ssh.NewPublicKey(clientPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory, err := ioutil.TempDir("", "sftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{
+		listener:  listener,
+		hostKey:   hostSigner,
+		clientKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: clientKeyBytes})),
+		directory: directory,
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == testUser && string(password) == testPassword {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == testUser && bytes.Equal(key.Marshal(), authorizedKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+	go s.serve(config)
+	return s
+}
+
+func (s *testServer) close() {
+	s.listener.Close()
+	os.RemoveAll(s.directory)
+}
+
+func (s *testServer) serve(config *ssh.ServerConfig) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, channels, requests, err := ssh.NewServerConn(conn, config)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go ssh.DiscardRequests(requests)
+			for newChannel := range channels {
+				if newChannel.ChannelType() != "session" {
+					newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+					continue
+				}
+				channel, channelRequests, err := newChannel.Accept()
+				if err != nil {
+					continue
+				}
+				go func() {
+					for request := range channelRequests {
+						request.Reply(request.Type == "subsystem" && strings.HasSuffix(string(request.Payload), "sftp"), nil)
+					}
+				}()
+				var readWriter io.ReadWriteCloser = channel
+				if atomic.AddInt32(&s.sessions, 1) == 1 && s.failWrites {
+					// The first session breaks after a part of the upload, like a dropped connection
+					readWriter = &breakingChannel{Channel: channel, remaining: 200000}
+				}
+				server, err := sftp.NewServer(readWriter)
+				if err != nil {
+					channel.Close()
+					continue
+				}
+				go func() {
+					server.Serve()
+					server.Close()
+				}()
+			}
+		}()
+	}
+}
+
+// breakingChannel closes the channel once the given number of bytes was received.
+type breakingChannel struct {
+	ssh.Channel
+	remaining int
+}
+
+func (c *breakingChannel) Read(data []byte) (int, error) {
+	if c.remaining <= 0 {
+		c.Channel.Close()
+		return 0, io.EOF
+	}
+	if len(data) > c.remaining {
+		data = data[:c.remaining]
+	}
+	n, err := c.Channel.Read(data)
+	c.remaining -= n
+	return n, err
+}
+
+func (s *testServer) getDestination() httpBodies.DestinationInformation {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	return httpBodies.DestinationInformation{
+		Type:                 Type,
+		Host:                 host,
+		Port:                 portNumber,
+		Username:             testUser,
+		Password:             testPassword,
+		Host_key_fingerprint: ssh.FingerprintSHA256(s.hostKey.PublicKey()),
+		Remote_directory:     filepath.Join(s.directory, "backups"),
+	}
+}
+
+func getTestData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7 % 251)
+	}
+	return data
+}
+
+func TestUploadAndDownload(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+	destination := s.getDestination()
+	data := getTestData(300000)
+
+	info, err := Backend{}.Upload(context.Background(), destination, "backup.tar", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if info.Name != "backup.tar" || info.Size != int64(len(data)) {
+		t.Errorf("Unexpected stats of the upload: %+v", info)
+	}
+	if _, err = os.Stat(filepath.Join(destination.Remote_directory, "backup.tar"+partialFileSuffix)); !os.IsNotExist(err) {
+		t.Error("The partial file was not renamed")
+	}
+
+	var downloaded bytes.Buffer
+	n, err := Backend{}.Download(context.Background(), destination, "backup.tar", &downloaded)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if n != int64(len(data)) || !bytes.Equal(downloaded.Bytes(), data) {
+		t.Errorf("The downloaded data differs from the uploaded data, got %d bytes", n)
+	}
+}
+
+func TestUploadWithPrivateKey(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+	destination := s.getDestination()
+	destination.Password = ""
+	destination.Private_key = s.clientKey
+
+	if _, err := (Backend{}).Upload(context.Background(), destination, "backup.tar", strings.NewReader("data"), 4); err != nil {
+		t.Fatalf("Upload with the private key failed: %v", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(destination.Remote_directory, "backup.tar"))
+	if err != nil || string(content) != "data" {
+		t.Errorf("The uploaded file is missing or wrong: %q, %v", content, err)
+	}
+}
+
+func TestUploadResumesAfterAFailedAttempt(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+	s.failWrites = true
+	destination := s.getDestination()
+	data := getTestData(500000)
+
+	if _, err := (Backend{}).Upload(context.Background(), destination, "backup.tar", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	// The failed attempt, the resumed attempt and the stats of the uploaded file
+	if sessions := atomic.LoadInt32(&s.sessions); sessions != 3 {
+		t.Errorf("Expected one retry of the upload, but %d sessions were opened", sessions)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(destination.Remote_directory, "backup.tar"))
+	if err != nil || !bytes.Equal(content, data) {
+		t.Errorf("The resumed upload differs from the data, got %d bytes: %v", len(content), err)
+	}
+}
+
+// failingWriter fails once after the given number of bytes.
+type failingWriter struct {
+	bytes.Buffer
+	failAfter int
+}
+
+func (w *failingWriter) Write(data []byte) (int, error) {
+	if w.failAfter >= 0 && w.Len()+len(data) > w.failAfter {
+		n, _ := w.Buffer.Write(data[:w.failAfter-w.Len()])
+		w.failAfter = -1
+		return n, errors.New("disk full")
+	}
+	return w.Buffer.Write(data)
+}
+
+func TestDownloadResumesAfterAFailedAttempt(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+	destination := s.getDestination()
+	data := getTestData(300000)
+	os.MkdirAll(destination.Remote_directory, 0700)
+	if err := ioutil.WriteFile(filepath.Join(destination.Remote_directory, "backup.tar"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	writer := &failingWriter{failAfter: 100000}
+	n, err := Backend{}.Download(context.Background(), destination, "backup.tar", writer)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if n != int64(len(data)) || !bytes.Equal(writer.Bytes(), data) {
+		t.Errorf("The resumed download differs from the data, got %d bytes", n)
+	}
+}
+
+func TestStatListAndDelete(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+	destination := s.getDestination()
+	os.MkdirAll(destination.Remote_directory, 0700)
+	for name, content := range map[string]string{"db_1.tar": "first", "db_2.tar": "second", "db_3.tar.part": "partial", "other.tar": "other"} {
+		if err := ioutil.WriteFile(filepath.Join(destination.Remote_directory, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := Backend{}.Stat(context.Background(), destination, "db_2.tar")
+	if err != nil || info.Size != int64(len("second")) {
+		t.Errorf("Unexpected stats %+v: %v", info, err)
+	}
+
+	objects, err := Backend{}.List(context.Background(), destination, "db_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Errorf("Expected the two complete files with the prefix, got %+v", objects)
+	}
+
+	if err = (Backend{}).Delete(context.Background(), destination, "db_1.tar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = (Backend{}).Stat(context.Background(), destination, "db_1.tar"); err == nil {
+		t.Error("The deleted file still exists")
+	}
+}
+
+func TestWrongHostKeyIsRejected(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+	destination := s.getDestination()
+	destination.Host_key_fingerprint = "SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+
+	if _, err := (Backend{}).Upload(context.Background(), destination, "backup.tar", strings.NewReader("data"), 4); err == nil {
+		t.Fatal("The upload to a server with an unexpected host key succeeded")
+	}
+	if _, err := os.Stat(destination.Remote_directory); !os.IsNotExist(err) {
+		t.Error("Data was written to the server with the unexpected host key")
+	}
+}
+
+func TestWrongPasswordIsRejected(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+	destination := s.getDestination()
+	destination.Password = "wrong"
+
+	if _, err := (Backend{}).Stat(context.Background(), destination, "backup.tar"); err == nil {
+		t.Fatal("Connecting with a wrong password succeeded")
+	}
+}
+
+func TestCancelledUploadFails(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+	destination := s.getDestination()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := (Backend{}).Upload(ctx, destination, "backup.tar", strings.NewReader("data"), 4); err == nil {
+		t.Fatal("The cancelled upload succeeded")
+	}
+}
+
+func TestFileNamesOutsideTheRemoteDirectoryAreRejected(t *testing.T) {
+	destination := httpBodies.DestinationInformation{Host: "localhost", Username: testUser, Password: testPassword,
+		Host_key_fingerprint: "SHA256:x", Remote_directory: "/backups"}
+	for _, name := range []string{"../etc/passwd", "a/b", "..", "backup.tar.part"} {
+		destination.Filename = name
+		if missingFields := (Backend{}).Validate(destination, false); missingFields != " valid filename" {
+			t.Errorf("The filename %q was not rejected: %q", name, missingFields)
+		}
+	}
+	destination.Filename = "backup.tar"
+	if missingFields := (Backend{}).Validate(destination, false); missingFields != "" {
+		t.Errorf("A valid destination was rejected: %q", missingFields)
+	}
+}
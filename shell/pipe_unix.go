@@ -0,0 +1,45 @@
+//go:build !windows
+// +build !windows
+
+package shell
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// openNamedPipe creates a named pipe at the given path and opens both of its ends. Opening the reading end without blocking succeeds
+// without a writer, and the writing end does not block as the reading end is open, so neither the agent nor the script waits for the other
+// one to open the pipe.
+func openNamedPipe(path string) (reader *os.File, writer *os.File, err error) {
+	if err = syscall.Mkfifo(path, 0600); err != nil {
+		return nil, nil, err
+	}
+	if reader, err = os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0); err != nil {
+		os.Remove(path)
+		return nil, nil, err
+	}
+	if writer, err = os.OpenFile(path, os.O_WRONLY, 0); err != nil {
+		reader.Close()
+		os.Remove(path)
+		return nil, nil, err
+	}
+	return reader, writer, nil
+}
+
+// waitForNamedPipeReader returns once another process opened the named pipe for reading or the script is done.
+func waitForNamedPipeReader(path string, scriptDone <-chan struct{}) {
+	for {
+		// Opening the writing end without blocking fails as long as there is no reader
+		if probe, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+			probe.Close()
+			return
+		}
+		select {
+		case <-scriptDone:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
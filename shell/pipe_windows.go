@@ -0,0 +1,15 @@
+package shell
+
+import (
+	"errors"
+	"os"
+)
+
+// openNamedPipe fails, as named pipes in the file system are not available on windows.
+func openNamedPipe(path string) (reader *os.File, writer *os.File, err error) {
+	return nil, nil, errors.New("named pipes are not supported on windows")
+}
+
+// waitForNamedPipeReader returns at once, as there are no named pipes on windows.
+func waitForNamedPipeReader(path string, scriptDone <-chan struct{}) {
+}
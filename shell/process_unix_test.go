@@ -0,0 +1,106 @@
+//go:build !windows
+// +build !windows
+
+package shell
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// getTickingScript returns a script, whose child process appends to the file at the path until it is killed.
+// The script itself only waits, so the child would outlive it, if only the script was killed.
+func getTickingScript(path string) string {
+	return "( while true; do echo tick >> '" + path + "'; sleep 0.05; done ) &\nsleep 60\n"
+}
+
+func waitForTicks(t *testing.T, path string) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if data, err := ioutil.ReadFile(path); err == nil && len(data) > 0 {
+			return
+		}
+	}
+	t.Fatal("The child process of the script did not start")
+}
+
+func assertTicksStopped(t *testing.T, path string) {
+	time.Sleep(200 * time.Millisecond)
+	before, _ := ioutil.ReadFile(path)
+	time.Sleep(300 * time.Millisecond)
+	after, _ := ioutil.ReadFile(path)
+	if len(after) != len(before) {
+		t.Error("The child process of the script is still running")
+	}
+}
+
+// runTickingScript runs the ticking script as pre-backup-lock stage and returns the path of its file and the error of the script.
+func runTickingScript(t *testing.T, ctx context.Context, directory string, stop func()) (string, error) {
+	ticks := filepath.Join(directory, "ticks")
+	if err := ioutil.WriteFile(filepath.Join(directory, "pre-backup-lock.sh"), []byte(getTickingScript(ticks)), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	finished := make(chan error, 1)
+	go func() {
+		_, _, _, err := ExecuteScriptForStage(ctx, "pre-backup-lock", nil, "db")
+		finished <- err
+	}()
+	waitForTicks(t, ticks)
+	stop()
+
+	select {
+	case err := <-finished:
+		return ticks, err
+	case <-time.After(10 * time.Second):
+		t.Fatal("The script was not killed")
+	}
+	return ticks, nil
+}
+
+func TestCancelKillsTheProcessGroup(t *testing.T) {
+	directory := setUpScripts(t, nil)
+	defer os.RemoveAll(directory)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ticks, err := runTickingScript(t, ctx, directory, cancel)
+	if err != context.Canceled {
+		t.Errorf("Expected the script to be cancelled, got %v", err)
+	}
+	assertTicksStopped(t, ticks)
+}
+
+func TestCancelledContextDoesNotStartTheScript(t *testing.T) {
+	directory := setUpScripts(t, nil)
+	defer os.RemoveAll(directory)
+	started := filepath.Join(directory, "started")
+	if err := ioutil.WriteFile(filepath.Join(directory, "pre-backup-lock.sh"), []byte("touch '"+started+"'\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, _, err := ExecuteScriptForStage(ctx, "pre-backup-lock", nil, "db"); err != context.Canceled {
+		t.Errorf("Expected the script to be cancelled, got %v", err)
+	}
+	if _, err := os.Stat(started); !os.IsNotExist(err) {
+		t.Error("The script was started with a cancelled context")
+	}
+}
+
+func TestTimeoutKillsTheProcessGroup(t *testing.T) {
+	directory := setUpScripts(t, nil)
+	defer os.RemoveAll(directory)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ticks, err := runTickingScript(t, ctx, directory, func() {})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected the script to exceed the deadline, got %v", err)
+	}
+	assertTicksStopped(t, ticks)
+}
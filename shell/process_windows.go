@@ -0,0 +1,16 @@
+package shell
+
+import (
+	"os/exec"
+)
+
+// startInOwnProcessGroup is a no-op, as process groups are not available on windows.
+func startInOwnProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup only kills the script itself, as process groups are not available on windows.
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
@@ -2,7 +2,9 @@ package shell
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -12,18 +14,35 @@ import (
 
 	"github.com/evoila/osb-backup-agent/configuration"
 	"github.com/evoila/osb-backup-agent/errorlog"
+	"github.com/evoila/osb-backup-agent/logstream"
 )
 
 var Directory = configuration.GetScriptsPath()
 
-func ExecuteScriptForStage(stageName string, jsonParams []string, params ...string) (found bool, logs string, errlogs string, err error) {
+// ExecuteScriptForStage runs the script of the given stage. Cancelling the context kills the script and all of its child processes.
+// If the context carries a log stream, the script's output is additionally published to it while the script runs.
+func ExecuteScriptForStage(ctx context.Context, stageName string, jsonParams []string, params ...string) (found bool, logs string, errlogs string, err error) {
+	return executeScriptForStage(ctx, stageName, jsonParams, nil, nil, params...)
+}
+
+// executeScriptForStage connects the script's stdin to the given reader and its stdout to the given writer, if they are not nil.
+// A script whose stdout is connected to a writer only logs its stderr.
+func executeScriptForStage(ctx context.Context, stageName string, jsonParams []string, stdin io.Reader, stdout io.Writer, params ...string) (found bool, logs string, errlogs string, err error) {
 	var fileName string
 	found, fileName = CheckForBothExistingFiles(Directory, stageName)
 	if !found {
 		return found, "", "", errors.New(errorlog.Concat([]string{"No script found for the ", stageName, " stage."}, ""))
 	}
 
-	out, errOut, err := ExecShellScript(GetPathToFile(Directory, fileName), jsonParams, params)
+	var stdoutWriter, stderrWriter io.Writer
+	if stream, exists := logstream.FromContext(ctx); exists {
+		stdoutLines, stderrLines := stream.Writer(stageName, "stdout"), stream.Writer(stageName, "stderr")
+		defer stdoutLines.Flush()
+		defer stderrLines.Flush()
+		stdoutWriter, stderrWriter = stdoutLines, stderrLines
+	}
+
+	out, errOut, err := execShellScript(ctx, GetPathToFile(Directory, fileName), jsonParams, params, stdin, stdout, stdoutWriter, stderrWriter)
 
 	if err != nil {
 		errorlog.LogError("Calling the shell script ", fileName,
@@ -37,7 +56,13 @@ func ExecuteScriptForStage(stageName string, jsonParams []string, params ...stri
 	return true, out.String(), errOut.String(), err
 }
 
-func ExecShellScript(path string, jsonParams []string, params []string) (bytes.Buffer, bytes.Buffer, error) {
+func ExecShellScript(ctx context.Context, path string, jsonParams []string, params []string) (bytes.Buffer, bytes.Buffer, error) {
+	return execShellScript(ctx, path, jsonParams, params, nil, nil, nil, nil)
+}
+
+// execShellScript additionally copies the script's output into the given writers, if they are not nil.
+// If data is not nil, the script's stdout is written into it instead and neither buffered nor copied.
+func execShellScript(ctx context.Context, path string, jsonParams []string, params []string, stdin io.Reader, data io.Writer, stdoutWriter, stderrWriter io.Writer) (bytes.Buffer, bytes.Buffer, error) {
 	log.Println("Executing the", path, "script.")
 
 	var cmd *exec.Cmd
@@ -65,14 +90,75 @@ func ExecShellScript(path string, jsonParams []string, params []string) (bytes.B
 	log.Println("Adding following environment variables to the execution environment:", jsonParams)
 	addEnvVars(jsonParams, cmd)
 	cmd.Stdin = strings.NewReader("")
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
 	var out bytes.Buffer
 	var errOut bytes.Buffer
 	cmd.Stdout = &out
 	cmd.Stderr = &errOut
-	err := cmd.Run()
+	if data != nil {
+		log.Println("Streaming the script's stdout, only its stderr is logged.")
+		cmd.Stdout = data
+	} else if stdoutWriter != nil {
+		cmd.Stdout = io.MultiWriter(&out, stdoutWriter)
+	}
+	if stderrWriter != nil {
+		cmd.Stderr = io.MultiWriter(&errOut, stderrWriter)
+	}
+	err := runCancellable(ctx, cmd)
 	return out, errOut, err
 }
 
+// runCancellable runs the command and kills its whole process group as soon as the context is done.
+func runCancellable(ctx context.Context, cmd *exec.Cmd) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	startInOwnProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	finished := make(chan error, 1)
+	go func() {
+		finished <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-finished:
+		return err
+	case <-ctx.Done():
+		log.Println("Killing the script and its child processes due to '", ctx.Err().Error(), "'")
+		if err := killProcessGroup(cmd); err != nil {
+			errorlog.LogError("Killing the process group of the script failed due to '", err.Error(), "'")
+		}
+		<-finished
+		return ctx.Err()
+	}
+}
+
+// GetExitCode returns the exit code of a script based on the error of its execution.
+// It returns -1 if the script could not be started or was killed.
+func GetExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// JobFailingEnvVar : Name of the environment variable that tells the cleanup and unlock scripts whether the job is failing
+const JobFailingEnvVar = "BACKUP_AGENT_JOB_FAILING"
+
+// GetJobFailingEnvVar returns the environment variable that tells a script whether the job is failing.
+func GetJobFailingEnvVar(failing bool) string {
+	return errorlog.Concat([]string{JobFailingEnvVar, strconv.FormatBool(failing)}, "=")
+}
+
 func CheckForExistingFile(directory, fileName string) bool {
 	var path = GetPathToFile(directory, fileName)
 	log.Println("Looking for file at", path)
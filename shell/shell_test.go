@@ -0,0 +1,50 @@
+package shell
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setUpScripts writes the scripts into a new directory, which is used as script directory. The caller has to remove the directory.
+func setUpScripts(t *testing.T, scripts map[string]string) string {
+	directory, err := ioutil.TempDir("", "scripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range scripts {
+		if err = ioutil.WriteFile(filepath.Join(directory, name+".sh"), []byte(content), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	Directory = directory
+	return directory
+}
+
+func TestScriptOutputAndExitCode(t *testing.T) {
+	directory := setUpScripts(t, map[string]string{
+		"pre-backup-lock":  "echo \"locking $1 with $BACKUP_AGENT_JOB_FAILING\"\necho warning >&2\n",
+		"pre-backup-check": "echo checking\nexit 3\n",
+	})
+	defer os.RemoveAll(directory)
+
+	found, logs, errlogs, err := ExecuteScriptForStage(context.Background(), "pre-backup-lock", []string{GetJobFailingEnvVar(false)}, "db")
+	if !found || err != nil {
+		t.Fatalf("The script failed: %v", err)
+	}
+	if strings.TrimSpace(logs) != "locking db with false" || strings.TrimSpace(errlogs) != "warning" {
+		t.Errorf("Unexpected output of the script: %q, %q", logs, errlogs)
+	}
+
+	found, logs, _, err = ExecuteScriptForStage(context.Background(), "pre-backup-check", nil, "db")
+	if !found || GetExitCode(err) != 3 || strings.TrimSpace(logs) != "checking" {
+		t.Errorf("Expected the exit code 3 and the output of the failed script, got %d, %q", GetExitCode(err), logs)
+	}
+
+	if found, _, _, err = ExecuteScriptForStage(context.Background(), "backup-cleanup", nil, "db", "id"); found || err == nil {
+		t.Error("A missing script was reported as found")
+	}
+}
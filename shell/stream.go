@@ -0,0 +1,176 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/evoila/osb-backup-agent/errorlog"
+	"github.com/evoila/osb-backup-agent/httpBodies"
+)
+
+// StreamingEnvVar : Name of the environment variable that tells the backup and restore scripts the streaming mode of the job
+const StreamingEnvVar = "BACKUP_AGENT_STREAMING"
+
+// PipeEnvVar : Name of the environment variable that holds the path of the named pipe in the pipe streaming mode
+const PipeEnvVar = "BACKUP_AGENT_PIPE"
+
+// ExecuteScriptForStageWithOutputStream runs the script of the given stage, while upload reads the data the script writes to its stdout
+// or, if pipePath is not empty, into a named pipe created at this path. The script is killed if the upload fails. If the script fails,
+// the upload reads its error instead of the end of the data, so a partial backup is never completed.
+// The returned error is the error of the script or, if the script did not fail on its own, the error of the upload.
+func ExecuteScriptForStageWithOutputStream(ctx context.Context, stageName string, jsonParams []string, pipePath string,
+	upload func(ctx context.Context, reader io.Reader) error, params ...string) (found bool, logs string, errlogs string, err error) {
+	var agentEnd, keepAlive *os.File
+	var stdout io.Writer
+	if pipePath == "" {
+		agentEnd, keepAlive, err = os.Pipe()
+		stdout = keepAlive
+	} else {
+		agentEnd, keepAlive, err = createNamedPipe(pipePath, true)
+	}
+	jsonParams = getStreamEnvVars(jsonParams, pipePath)
+	if err != nil {
+		return true, "", "", errorlog.LogError("Creating the pipe for the output of the ", stageName, " script failed due to '", err.Error(), "'")
+	}
+	defer removeNamedPipe(pipePath)
+
+	return executeScriptWithStream(ctx, stageName, jsonParams, nil, stdout, agentEnd, keepAlive, func(ctx context.Context, _ <-chan struct{}, waitForScript func() error) error {
+		return upload(ctx, &scriptOutputReader{reader: agentEnd, waitForScript: waitForScript})
+	}, params...)
+}
+
+// ExecuteScriptForStageWithInputStream runs the script of the given stage, while download writes the data the script reads from its stdin
+// or, if pipePath is not empty, from a named pipe created at this path. The script is killed before it reads the end of the data,
+// if the download fails. A script that exits before it read all of the data fails the download.
+// The returned error is the error of the script or, if the script did not fail on its own, the error of the download.
+func ExecuteScriptForStageWithInputStream(ctx context.Context, stageName string, jsonParams []string, pipePath string,
+	download func(ctx context.Context, writer io.Writer) error, params ...string) (found bool, logs string, errlogs string, err error) {
+	var agentEnd, keepAlive *os.File
+	var stdin io.Reader
+	if pipePath == "" {
+		keepAlive, agentEnd, err = os.Pipe()
+		stdin = keepAlive
+	} else {
+		agentEnd, keepAlive, err = createNamedPipe(pipePath, false)
+	}
+	jsonParams = getStreamEnvVars(jsonParams, pipePath)
+	if err != nil {
+		return true, "", "", errorlog.LogError("Creating the pipe for the input of the ", stageName, " script failed due to '", err.Error(), "'")
+	}
+	defer removeNamedPipe(pipePath)
+
+	return executeScriptWithStream(ctx, stageName, jsonParams, stdin, nil, agentEnd, keepAlive, func(ctx context.Context, scriptDone <-chan struct{}, _ func() error) error {
+		if err := download(ctx, agentEnd); err != nil || pipePath == "" {
+			return err
+		}
+		// Opening a named pipe for reading blocks until there is a writer, so the writing end is only closed once the script opened the pipe.
+		// The reading end of the agent is closed first, as it would count as reader as well.
+		keepAlive.Close()
+		waitForNamedPipeReader(pipePath, scriptDone)
+		return nil
+	}, params...)
+}
+
+// executeScriptWithStream runs the script and the transfer concurrently. agentEnd is the end of the pipe the transfer uses, it is closed once
+// the transfer is done. keepAlive is the other end, which keeps the pipe open while the script runs, it is closed once the script exited.
+// The script is killed if the transfer fails, before the pipe is closed, so the script never sees the end of incomplete data.
+func executeScriptWithStream(ctx context.Context, stageName string, jsonParams []string, stdin io.Reader, stdout io.Writer, agentEnd *os.File, keepAlive *os.File,
+	transfer func(ctx context.Context, scriptDone <-chan struct{}, waitForScript func() error) error, params ...string) (found bool, logs string, errlogs string, err error) {
+	if found, _ = CheckForBothExistingFiles(Directory, stageName); !found {
+		agentEnd.Close()
+		keepAlive.Close()
+		return found, "", "", errors.New(errorlog.Concat([]string{"No script found for the ", stageName, " stage."}, ""))
+	}
+
+	scriptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var scriptErr error
+	var scriptDone = make(chan struct{})
+	var waitForScript = func() error {
+		<-scriptDone
+		return scriptErr
+	}
+
+	var transferred = make(chan error, 1)
+	go func() {
+		err := transfer(scriptCtx, scriptDone, waitForScript)
+		if err != nil {
+			cancel()
+			<-scriptDone
+		}
+		agentEnd.Close()
+		transferred <- err
+	}()
+
+	log.Println("Streaming the data of the", stageName, "script.")
+	found, logs, errlogs, scriptErr = executeScriptForStage(scriptCtx, stageName, jsonParams, stdin, stdout, params...)
+	close(scriptDone)
+	keepAlive.Close()
+
+	var transferErr = <-transferred
+	err = scriptErr
+	// The script was killed because of the failed transfer, unless the job itself was cancelled
+	if transferErr != nil && (err == nil || (err == context.Canceled && ctx.Err() == nil)) {
+		err = transferErr
+	}
+	return found, logs, errlogs, err
+}
+
+// scriptOutputReader reads the output of a script. At the end of the output, it returns the error of the script instead, if it failed.
+type scriptOutputReader struct {
+	reader        io.Reader
+	waitForScript func() error
+}
+
+func (r *scriptOutputReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err == io.EOF {
+		// The end of the output is only read once the script exited
+		if scriptErr := r.waitForScript(); scriptErr != nil {
+			return n, errorlog.LogError("The script failed due to '", scriptErr.Error(), "'")
+		}
+	}
+	return n, err
+}
+
+// getStreamEnvVars adds the streaming mode and the path of the named pipe to the environment variables of the script.
+func getStreamEnvVars(jsonParams []string, pipePath string) []string {
+	var env = append([]string{}, jsonParams...)
+	if pipePath == "" {
+		return append(env, errorlog.Concat([]string{StreamingEnvVar, httpBodies.Streaming_stdout}, "="))
+	}
+	return append(env,
+		errorlog.Concat([]string{StreamingEnvVar, httpBodies.Streaming_pipe}, "="),
+		errorlog.Concat([]string{PipeEnvVar, pipePath}, "="))
+}
+
+// createNamedPipe creates the directory of the named pipe and the pipe itself.
+// If the agent reads from the pipe, agentEnd is the reading end and keepAlive the writing end, otherwise it is the other way around.
+func createNamedPipe(path string, agentReads bool) (agentEnd *os.File, keepAlive *os.File, err error) {
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, nil, err
+	}
+	log.Println("Creating named pipe at", path)
+	reader, writer, err := openNamedPipe(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if agentReads {
+		return reader, writer, nil
+	}
+	return writer, reader, nil
+}
+
+func removeNamedPipe(path string) {
+	if path == "" {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		errorlog.LogError("Removing the named pipe at ", path, " failed due to '", err.Error(), "'")
+	}
+}
@@ -0,0 +1,225 @@
+//go:build !windows
+// +build !windows
+
+package shell
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errTransfer = errors.New("transfer failed")
+
+// getPipePath returns the path of the named pipe in the directory for the pipe streaming mode and an empty path otherwise.
+func getPipePath(directory string, namedPipe bool) string {
+	if !namedPipe {
+		return ""
+	}
+	return filepath.Join(directory, "pipes", "stream")
+}
+
+// getOutput returns the redirection of the output of a script to the stream.
+func getOutput(namedPipe bool) string {
+	if namedPipe {
+		return " > \"$" + PipeEnvVar + "\""
+	}
+	return ""
+}
+
+// getInput returns the redirection of the input of a script from the stream.
+func getInput(namedPipe bool) string {
+	if namedPipe {
+		return " < \"$" + PipeEnvVar + "\""
+	}
+	return ""
+}
+
+// runWithTimeout fails the test if the streaming stage does not end in time, which happens if one side waits for the other forever.
+func runWithTimeout(t *testing.T, run func() error) error {
+	finished := make(chan error, 1)
+	go func() {
+		finished <- run()
+	}()
+	select {
+	case err := <-finished:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatal("The streaming stage did not end")
+	}
+	return nil
+}
+
+func TestOutputStream(t *testing.T) {
+	for _, namedPipe := range []bool{false, true} {
+		directory := setUpScripts(t, map[string]string{"backup": "printf 'line 1\\nline 2\\n'" + getOutput(namedPipe) + "\n"})
+
+		var uploaded []byte
+		err := runWithTimeout(t, func() error {
+			_, _, _, err := ExecuteScriptForStageWithOutputStream(context.Background(), "backup", nil, getPipePath(directory, namedPipe),
+				func(ctx context.Context, reader io.Reader) (err error) {
+					uploaded, err = ioutil.ReadAll(reader)
+					return err
+				}, "db")
+			return err
+		})
+		if err != nil || string(uploaded) != "line 1\nline 2\n" {
+			t.Errorf("named pipe %t: expected the output to be uploaded, got %q and %v", namedPipe, uploaded, err)
+		}
+		os.RemoveAll(directory)
+	}
+}
+
+func TestScriptFailureDuringTheUploadFailsTheUpload(t *testing.T) {
+	for _, namedPipe := range []bool{false, true} {
+		directory := setUpScripts(t, map[string]string{"backup": "( printf 'partial\\n'; sleep 0.2 )" + getOutput(namedPipe) + "\nexit 3\n"})
+
+		var uploaded []byte
+		var uploadErr error
+		err := runWithTimeout(t, func() error {
+			_, _, _, err := ExecuteScriptForStageWithOutputStream(context.Background(), "backup", nil, getPipePath(directory, namedPipe),
+				func(ctx context.Context, reader io.Reader) error {
+					uploaded, uploadErr = ioutil.ReadAll(reader)
+					return uploadErr
+				}, "db")
+			return err
+		})
+		if uploadErr == nil || string(uploaded) != "partial\n" {
+			t.Errorf("named pipe %t: expected the upload to read the failure of the script instead of the end of the data, got %q and %v", namedPipe, uploaded, uploadErr)
+		}
+		if GetExitCode(err) != 3 {
+			t.Errorf("named pipe %t: expected the error of the script, got %v", namedPipe, err)
+		}
+		os.RemoveAll(directory)
+	}
+}
+
+func TestUploadFailureKillsTheScript(t *testing.T) {
+	for _, namedPipe := range []bool{false, true} {
+		directory := setUpScripts(t, nil)
+		ticks := filepath.Join(directory, "ticks")
+		script := "while true; do echo data; echo tick >> '" + ticks + "'; sleep 0.05; done" + getOutput(namedPipe) + "\n"
+		if err := ioutil.WriteFile(filepath.Join(directory, "backup.sh"), []byte(script), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		err := runWithTimeout(t, func() error {
+			_, _, _, err := ExecuteScriptForStageWithOutputStream(context.Background(), "backup", nil, getPipePath(directory, namedPipe),
+				func(ctx context.Context, reader io.Reader) error {
+					if _, err := io.ReadFull(reader, make([]byte, 10)); err != nil {
+						return err
+					}
+					return errTransfer
+				}, "db")
+			return err
+		})
+		if err != errTransfer {
+			t.Errorf("named pipe %t: expected the error of the upload, got %v", namedPipe, err)
+		}
+		assertTicksStopped(t, ticks)
+		os.RemoveAll(directory)
+	}
+}
+
+func TestInputStream(t *testing.T) {
+	for _, namedPipe := range []bool{false, true} {
+		directory := setUpScripts(t, nil)
+		restored := filepath.Join(directory, "restored")
+		if err := ioutil.WriteFile(filepath.Join(directory, "restore.sh"), []byte("cat"+getInput(namedPipe)+" > '"+restored+"'\n"), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		err := runWithTimeout(t, func() error {
+			_, _, _, err := ExecuteScriptForStageWithInputStream(context.Background(), "restore", nil, getPipePath(directory, namedPipe),
+				func(ctx context.Context, writer io.Writer) error {
+					_, err := writer.Write([]byte("line 1\nline 2\n"))
+					return err
+				}, "db")
+			return err
+		})
+		if data, _ := ioutil.ReadFile(restored); err != nil || string(data) != "line 1\nline 2\n" {
+			t.Errorf("named pipe %t: expected the script to read the downloaded data, got %q and %v", namedPipe, data, err)
+		}
+		os.RemoveAll(directory)
+	}
+}
+
+func TestDownloadFailureKillsTheScriptBeforeTheEndOfTheData(t *testing.T) {
+	for _, namedPipe := range []bool{false, true} {
+		directory := setUpScripts(t, nil)
+		completed := filepath.Join(directory, "completed")
+		script := "cat" + getInput(namedPipe) + " > /dev/null && touch '" + completed + "'\n"
+		if err := ioutil.WriteFile(filepath.Join(directory, "restore.sh"), []byte(script), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		err := runWithTimeout(t, func() error {
+			_, _, _, err := ExecuteScriptForStageWithInputStream(context.Background(), "restore", nil, getPipePath(directory, namedPipe),
+				func(ctx context.Context, writer io.Writer) error {
+					if _, err := writer.Write([]byte("partial")); err != nil {
+						return err
+					}
+					// Gives the script the chance to read the partial data
+					time.Sleep(200 * time.Millisecond)
+					return errTransfer
+				}, "db")
+			return err
+		})
+		if err != errTransfer {
+			t.Errorf("named pipe %t: expected the error of the download, got %v", namedPipe, err)
+		}
+		if _, statErr := os.Stat(completed); !os.IsNotExist(statErr) {
+			t.Errorf("named pipe %t: the script read the end of the incomplete data", namedPipe)
+		}
+		os.RemoveAll(directory)
+	}
+}
+
+func TestScriptFailureDuringTheDownloadFailsTheRestore(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		exitCode int
+	}{
+		{"failing script", "head -c 5 > /dev/null; exit 4", 4},
+		// A script that does not read all of the data can not have restored it
+		{"script that stops reading", "head -c 5 > /dev/null", 0},
+	}
+	for _, test := range tests {
+		for _, namedPipe := range []bool{false, true} {
+			directory := setUpScripts(t, nil)
+			script := "(" + test.script + ")" + getInput(namedPipe) + "\n"
+			if err := ioutil.WriteFile(filepath.Join(directory, "restore.sh"), []byte(script), 0700); err != nil {
+				t.Fatal(err)
+			}
+
+			var downloadErr error
+			err := runWithTimeout(t, func() error {
+				_, _, _, err := ExecuteScriptForStageWithInputStream(context.Background(), "restore", nil, getPipePath(directory, namedPipe),
+					func(ctx context.Context, writer io.Writer) error {
+						// More data than fits into the buffer of the pipe
+						_, downloadErr = io.Copy(writer, bytes.NewReader(make([]byte, 1024*1024)))
+						return downloadErr
+					}, "db")
+				return err
+			})
+			if downloadErr == nil {
+				t.Errorf("%s, named pipe %t: expected the download to fail once the script exited", test.name, namedPipe)
+			}
+			if err == nil || (test.exitCode != 0 && GetExitCode(err) != test.exitCode) {
+				t.Errorf("%s, named pipe %t: expected the restore to fail with the exit code %d, got %v", test.name, namedPipe, test.exitCode, err)
+			}
+			if test.exitCode == 0 && err != nil && !strings.Contains(err.Error(), "pipe") {
+				t.Errorf("%s, named pipe %t: expected the error of the download, got %v", test.name, namedPipe, err)
+			}
+			os.RemoveAll(directory)
+		}
+	}
+}
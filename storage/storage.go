@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/errorlog"
+	"github.com/evoila/osb-backup-agent/httpBodies"
+)
+
+// UnknownSize : Size to pass to Upload, if the size of the stream is not known in advance
+const UnknownSize int64 = -1
+
+// Backend is a destination type that backups can be uploaded to and downloaded from.
+// Every method gets the destination of the request, which holds the location and the credentials.
+type Backend interface {
+	// Validate returns the names of the missing or invalid fields of the destination, each preceded by a space.
+	// The filename is only checked if fileCanBeMissing is false.
+	Validate(destination httpBodies.DestinationInformation, fileCanBeMissing bool) string
+	// Redact returns a copy of the destination for the logs, which only holds the fields the backend uses and whose credentials are replaced.
+	Redact(destination httpBodies.DestinationInformation) httpBodies.DestinationInformation
+	// Upload stores the content of the reader as an object with the given name. Pass UnknownSize if the size is not known.
+	// Backends that support user metadata store the metadata of the destination with the object.
+	Upload(ctx context.Context, destination httpBodies.DestinationInformation, name string, reader io.Reader, size int64) (ObjectInfo, error)
+	// Download writes the content of the object with the given name into the writer and returns the number of written bytes.
+	Download(ctx context.Context, destination httpBodies.DestinationInformation, name string, writer io.Writer) (int64, error)
+	// Stat returns the information about the object with the given name.
+	Stat(ctx context.Context, destination httpBodies.DestinationInformation, name string) (ObjectInfo, error)
+	// List returns the information about all objects whose names start with the prefix.
+	List(ctx context.Context, destination httpBodies.DestinationInformation, prefix string) ([]ObjectInfo, error)
+	// Delete removes the object with the given name.
+	Delete(ctx context.Context, destination httpBodies.DestinationInformation, name string) error
+}
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Name         string
+	Size         int64
+	LastModified time.Time
+	// Version identifies the stored revision of the object, if the backend supports versioning, for example the generation in GCS
+	Version string
+	// Lock is the retention of the object, if the backend supports write-once storage, for example the object lock of S3
+	Lock *ObjectLock
+	// Metadata holds the user metadata of the object with lower case keys, if the backend supports metadata
+	Metadata map[string]string
+}
+
+// ObjectLock describes until when and how an object is protected against being deleted or overwritten.
+type ObjectLock struct {
+	Mode        string
+	RetainUntil time.Time
+	LegalHold   bool
+}
+
+var backends = make(map[string]Backend)
+
+// Register makes a backend available for the given destination type. It has to be called before the agent accepts requests.
+func Register(destinationType string, backend Backend) {
+	log.Println("Registering storage backend", destinationType)
+	backends[destinationType] = backend
+	httpBodies.RegisterDestinationValidator(destinationType, backend.Validate)
+	httpBodies.RegisterDestinationRedactor(destinationType, backend.Redact)
+}
+
+// Get returns the backend of the given destination type.
+func Get(destinationType string) (Backend, bool) {
+	backend, exists := backends[destinationType]
+	return backend, exists
+}
+
+// IsSupported returns true if a backend is registered for the given destination type.
+func IsSupported(destinationType string) bool {
+	_, exists := backends[destinationType]
+	return exists
+}
+
+// GetSupportedTypes returns the sorted names of all registered destination types.
+func GetSupportedTypes() []string {
+	var types []string
+	for destinationType := range backends {
+		types = append(types, destinationType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// UploadFile uploads the file at the given path to the destination of the request under the given name.
+func UploadFile(ctx context.Context, destination httpBodies.DestinationInformation, name, path string) (ObjectInfo, error) {
+	backend, exists := Get(destination.Type)
+	if !exists {
+		return ObjectInfo{}, errorlog.LogError("No storage backend registered for type ", destination.Type)
+	}
+
+	log.Println("Opening file at", path)
+	file, err := os.Open(path)
+	if err != nil {
+		return ObjectInfo{}, errorlog.LogError("Failed to open file ", path, " due to '", err.Error(), "'")
+	}
+	defer file.Close()
+	log.Println("Successfully opened file at", path)
+
+	stat, err := file.Stat()
+	if err != nil {
+		return ObjectInfo{}, errorlog.LogError("Accessing file stats of ", path, " failed due to '", err.Error(), "'")
+	}
+
+	log.Println("Uploading", name, "to", destination.Type)
+	return backend.Upload(ctx, destination, name, file, stat.Size())
+}
+
+// DownloadFile downloads the object with the given name from the destination of the request into a new file at the given path.
+func DownloadFile(ctx context.Context, destination httpBodies.DestinationInformation, name, path string) (int64, error) {
+	backend, exists := Get(destination.Type)
+	if !exists {
+		return 0, errorlog.LogError("No storage backend registered for type ", destination.Type)
+	}
+
+	log.Println("Creating file at", path)
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, errorlog.LogError("Failed to create file ", path, " due to '", err.Error(), "'")
+	}
+	defer file.Close()
+
+	log.Println("Downloading", name, "from", destination.Type)
+	size, err := backend.Download(ctx, destination, name, file)
+	if err != nil {
+		return size, err
+	}
+	log.Println("Successfully downloaded", file.Name(), "(", size, "bytes )")
+	return size, nil
+}
+
+// UploadStream uploads the content of the reader, whose size is not known, to the destination of the request under the given name.
+func UploadStream(ctx context.Context, destination httpBodies.DestinationInformation, name string, reader io.Reader) (ObjectInfo, error) {
+	backend, exists := Get(destination.Type)
+	if !exists {
+		return ObjectInfo{}, errorlog.LogError("No storage backend registered for type ", destination.Type)
+	}
+
+	log.Println("Uploading", name, "to", destination.Type, "while it is being written")
+	counter := &countingReader{reader: reader}
+	info, err := backend.Upload(ctx, destination, name, counter, UnknownSize)
+	// Not every backend reports the size of the stored object
+	if err == nil && info.Size <= 0 {
+		info.Size = counter.count
+	}
+	return info, err
+}
+
+// DownloadStream writes the object with the given name from the destination of the request into the writer.
+func DownloadStream(ctx context.Context, destination httpBodies.DestinationInformation, name string, writer io.Writer) (int64, error) {
+	backend, exists := Get(destination.Type)
+	if !exists {
+		return 0, errorlog.LogError("No storage backend registered for type ", destination.Type)
+	}
+
+	log.Println("Downloading", name, "from", destination.Type, "while it is being read")
+	size, err := backend.Download(ctx, destination, name, writer)
+	if err != nil {
+		return size, err
+	}
+	log.Println("Successfully downloaded", name, "(", size, "bytes )")
+	return size, nil
+}
+
+// GetMetadataWithLowerCaseKeys returns a copy of the metadata, whose keys are converted to lower case.
+func GetMetadataWithLowerCaseKeys(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	var result = make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		result[strings.ToLower(key)] = value
+	}
+	return result
+}
+
+// AddToMetadata returns a copy of the metadata, which additionally holds the given key and value.
+func AddToMetadata(metadata map[string]string, key, value string) map[string]string {
+	var result = map[string]string{key: value}
+	for k, v := range metadata {
+		if k != key {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// NewContextReader returns a reader that fails as soon as the context is done.
+// It allows to abort transfers of clients that do not support contexts themselves.
+func NewContextReader(ctx context.Context, reader io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, reader: reader}
+}
+
+// NewContextWriter returns a writer that fails as soon as the context is done.
+func NewContextWriter(ctx context.Context, writer io.Writer) io.Writer {
+	return &contextWriter{ctx: ctx, writer: writer}
+}
+
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
+type contextWriter struct {
+	ctx    context.Context
+	writer io.Writer
+}
+
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.writer.Write(p)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
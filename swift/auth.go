@@ -0,0 +1,111 @@
+package swift
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/evoila/osb-backup-agent/mutex"
+	"github.com/ncw/swift"
+)
+
+// tokenExpiryMargin : Cached tokens are not used anymore this long before they expire, so they do not expire during a transfer
+const tokenExpiryMargin = 5 * time.Minute
+
+// maxCachedTokens : Number of cached tokens, every distinct set of credentials adds one, even if its token never expires
+const maxCachedTokens = 100
+
+// cachedToken holds the result of an authentication, which is shared by all jobs with the same credentials.
+type cachedToken struct {
+	storageUrl string
+	authToken  string
+	expires    time.Time
+}
+
+var tokens = make(map[string]cachedToken)
+var tokensMutex = newReleasedMutex()
+
+func newReleasedMutex() mutex.Mutex {
+	m := make(mutex.Mutex, 1)
+	m.Release()
+	return m
+}
+
+// getTokenCacheKey identifies the auth url, project and user of the destination. The secrets are part of the key as well,
+// so a request with a wrong password never gets the token of an earlier request.
+func getTokenCacheKey(destination httpBodies.DestinationInformation) string {
+	hash := sha256.Sum256([]byte(strings.Join([]string{
+		destination.AuthUrl,
+		destination.Domain,
+		destination.Project_name,
+		destination.Username,
+		destination.Password,
+		destination.Application_credential_id,
+		destination.Application_credential_secret,
+		destination.Auth_token,
+	}, "\x00")))
+	return hex.EncodeToString(hash[:])
+}
+
+// useCachedToken sets the cached token of the destination on the connection, if there is one that does not expire soon.
+func useCachedToken(destination httpBodies.DestinationInformation, c *swift.Connection) bool {
+	key := getTokenCacheKey(destination)
+	tokensMutex.Acquire()
+	token, exists := tokens[key]
+	if exists && token.isExpiring(time.Now()) {
+		delete(tokens, key)
+		exists = false
+	}
+	tokensMutex.Release()
+
+	if !exists {
+		return false
+	}
+	c.StorageUrl, c.AuthToken, c.Expires = token.storageUrl, token.authToken, token.expires
+	// Authenticated also prepares the connection for requests without calling Authenticate
+	return c.Authenticated()
+}
+
+// cacheToken stores the current token of the connection for later jobs. Pre-issued tokens are not cached, as they come with every request.
+func cacheToken(destination httpBodies.DestinationInformation, c *swift.Connection) {
+	if (destination.Auth_token != "" && destination.Storage_url != "") || !c.Authenticated() {
+		return
+	}
+
+	key := getTokenCacheKey(destination)
+	tokensMutex.Acquire()
+	removeExpiringTokens(time.Now())
+	if _, exists := tokens[key]; !exists && len(tokens) >= maxCachedTokens {
+		removeFirstExpiringToken()
+	}
+	tokens[key] = cachedToken{storageUrl: c.StorageUrl, authToken: c.AuthToken, expires: c.Expires}
+	tokensMutex.Release()
+}
+
+// isExpiring returns true if the token expires within the margin, tokens without expiry never do.
+func (t cachedToken) isExpiring(now time.Time) bool {
+	return !t.expires.IsZero() && now.Add(tokenExpiryMargin).After(t.expires)
+}
+
+// removeExpiringTokens removes all tokens that are not used anymore. Call it with tokensMutex acquired.
+func removeExpiringTokens(now time.Time) {
+	for key, token := range tokens {
+		if token.isExpiring(now) {
+			delete(tokens, key)
+		}
+	}
+}
+
+// removeFirstExpiringToken makes room for another token, tokens without expiry are removed last. Call it with tokensMutex acquired.
+func removeFirstExpiringToken() {
+	var firstKey string
+	var firstExpires time.Time
+	for key, token := range tokens {
+		if firstKey == "" || (!token.expires.IsZero() && (firstExpires.IsZero() || token.expires.Before(firstExpires))) {
+			firstKey, firstExpires = key, token.expires
+		}
+	}
+	delete(tokens, firstKey)
+}
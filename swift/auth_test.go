@@ -0,0 +1,72 @@
+package swift
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/ncw/swift"
+)
+
+func getUserDestination(user string) httpBodies.DestinationInformation {
+	return httpBodies.DestinationInformation{AuthUrl: "url", Domain: "domain", Project_name: "project", Username: user, Password: "password"}
+}
+
+func cacheTestToken(user string, expires time.Time) {
+	cacheToken(getUserDestination(user), &swift.Connection{StorageUrl: "storage", AuthToken: "token-" + user, Expires: expires})
+}
+
+func TestExpiringTokensAreEvicted(t *testing.T) {
+	tokens = make(map[string]cachedToken)
+	cacheTestToken("expiring", time.Now().Add(time.Minute))
+	cacheTestToken("valid", time.Now().Add(time.Hour))
+
+	if useCachedToken(getUserDestination("expiring"), &swift.Connection{}) {
+		t.Error("A token that expires within the margin was used")
+	}
+	if len(tokens) != 1 {
+		t.Errorf("Expected the expiring token to be evicted, got %d tokens", len(tokens))
+	}
+
+	c := &swift.Connection{}
+	if !useCachedToken(getUserDestination("valid"), c) || c.AuthToken != "token-valid" {
+		t.Error("The valid token was not used")
+	}
+
+	tokens[getTokenCacheKey(getUserDestination("expired"))] = cachedToken{storageUrl: "storage", authToken: "token", expires: time.Now().Add(-time.Hour)}
+	cacheTestToken("other", time.Now().Add(time.Hour))
+	if _, exists := tokens[getTokenCacheKey(getUserDestination("expired"))]; exists || len(tokens) != 2 {
+		t.Errorf("Expected the expired token to be evicted when another one is cached, got %d tokens", len(tokens))
+	}
+}
+
+func TestTokenCacheIsBounded(t *testing.T) {
+	tokens = make(map[string]cachedToken)
+	now := time.Now()
+	for i := 0; i < maxCachedTokens; i++ {
+		cacheTestToken("user"+strconv.Itoa(i), now.Add(time.Hour+time.Duration(i)*time.Minute))
+	}
+	cacheTestToken("without expiry", time.Time{})
+	cacheTestToken("new", now.Add(2*time.Hour))
+
+	if len(tokens) != maxCachedTokens {
+		t.Errorf("Expected at most %d cached tokens, got %d", maxCachedTokens, len(tokens))
+	}
+	for _, user := range []string{"user0", "user1"} {
+		if _, exists := tokens[getTokenCacheKey(getUserDestination(user))]; exists {
+			t.Errorf("Expected the token of %s, which expires first, to be evicted", user)
+		}
+	}
+	for _, user := range []string{"user2", "without expiry", "new"} {
+		if _, exists := tokens[getTokenCacheKey(getUserDestination(user))]; !exists {
+			t.Errorf("Expected the token of %s to be kept", user)
+		}
+	}
+
+	// Renewing a cached token does not evict another one
+	cacheTestToken("new", now.Add(3*time.Hour))
+	if _, exists := tokens[getTokenCacheKey(getUserDestination("user2"))]; !exists || len(tokens) != maxCachedTokens {
+		t.Error("Renewing a cached token evicted another token")
+	}
+}
@@ -0,0 +1,210 @@
+package swift
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/errorlog"
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/ncw/swift"
+)
+
+// DefaultSegmentSize : Size of the segments of a large object, if the destination does not define one.
+// Swift allows 1000 segments per object by default, so larger backups need a larger segment size.
+const DefaultSegmentSize = 128 * 1024 * 1024
+
+// MaxSegmentSize : Largest size of a segment, which is the largest object Swift accepts by default
+const MaxSegmentSize = 5 * 1024 * 1024 * 1024
+
+// segmentContainerSuffix : Suffix of the container name, which is used as segment container if the destination does not define one
+const segmentContainerSuffix = "_segments"
+
+// sloSegment is an entry of the manifest of a Static Large Object.
+type sloSegment struct {
+	Path string `json:"path"`
+	Etag string `json:"etag"`
+	Size int64  `json:"size_bytes"`
+}
+
+func getSegmentSize(destination httpBodies.DestinationInformation) int64 {
+	if destination.Segment_size > 0 {
+		return destination.Segment_size
+	}
+	return DefaultSegmentSize
+}
+
+func getSegmentContainer(destination httpBodies.DestinationInformation) string {
+	if destination.Segment_container != "" {
+		return destination.Segment_container
+	}
+	return destination.Container_name + segmentContainerSuffix
+}
+
+// getSegmentPrefix returns the common prefix of all segments of the object with the given name.
+func getSegmentPrefix(name string) string {
+	return name + "/slo/"
+}
+
+// uploadLargeObject streams the reader segment by segment into the segment container and puts the manifest afterwards.
+// Every segment is passed on while it is read, so the memory of an upload does not depend on the segment size.
+// The uploaded segments are deleted again if the upload fails. An empty reader is put as plain object.
+func uploadLargeObject(c *swift.Connection, destination httpBodies.DestinationInformation, name string, reader io.Reader, segmentSize int64) (int64, error) {
+	// A large object needs at least one segment and Swift does not accept empty ones
+	buffered := bufio.NewReader(reader)
+	if _, err := buffered.Peek(1); err == io.EOF {
+		_, err = c.ObjectPut(destination.Container_name, name, buffered, true, "", "", getMetadataHeaders(destination))
+		return 0, err
+	} else if err != nil {
+		return 0, err
+	}
+
+	segmentContainer := getSegmentContainer(destination)
+	if err := c.ContainerCreate(segmentContainer, nil); err != nil {
+		return 0, errorlog.LogError("Failed to create the segment container ", segmentContainer, " due to '", err.Error(), "'")
+	}
+	uploadPrefix := getSegmentPrefix(name) + strconv.FormatInt(time.Now().UnixNano(), 10) + "/"
+
+	var segments []*sloSegment
+	var total int64
+	var err error
+	for index := 0; ; index++ {
+		objectName := uploadPrefix + fmt.Sprintf("%08d", index)
+		counter := &countingReader{reader: io.LimitReader(buffered, segmentSize)}
+		var headers swift.Headers
+		if headers, err = c.ObjectPut(segmentContainer, objectName, counter, true, "", "", nil); err != nil {
+			err = errorlog.LogError("Failed to put segment ", objectName, " due to '", err.Error(), "'")
+			break
+		}
+		segments = append(segments, &sloSegment{Path: segmentContainer + "/" + objectName, Etag: headers["Etag"], Size: counter.count})
+		total += counter.count
+
+		// A full segment is only followed by another one if there is data left
+		if counter.count < segmentSize {
+			break
+		}
+		if _, err = buffered.Peek(1); err == io.EOF {
+			err = nil
+			break
+		} else if err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		log.Println("Uploaded", len(segments), "segments of", name, "-> putting the manifest")
+		err = putManifest(c, destination, name, segments)
+	}
+	if err != nil {
+		deleteSegments(c, destination, name, uploadPrefix)
+		return total, err
+	}
+	return total, nil
+}
+
+// putManifest puts the manifest, which joins the segments to the object and holds the metadata of the object.
+func putManifest(c *swift.Connection, destination httpBodies.DestinationInformation, name string, segments []*sloSegment) error {
+	manifest, err := json.Marshal(segments)
+	if err != nil {
+		return err
+	}
+	headers := getMetadataHeaders(destination)
+	headers["Content-Length"] = strconv.Itoa(len(manifest))
+	_, _, err = c.Call(c.StorageUrl, swift.RequestOpts{
+		Container:  destination.Container_name,
+		ObjectName: name,
+		Operation:  "PUT",
+		Parameters: url.Values{"multipart-manifest": {"put"}},
+		Headers:    headers,
+		Body:       bytes.NewReader(manifest),
+		NoResponse: true,
+	})
+	if err != nil {
+		return errorlog.LogError("Failed to put the manifest of ", name, " due to '", err.Error(), "'")
+	}
+	return nil
+}
+
+// deleteSegments removes orphaned segments of the object with the given name: with an upload prefix, the segments of this failed upload,
+// otherwise all segments that are not referenced by the current manifest of the object. Failures are only logged.
+func deleteSegments(c *swift.Connection, destination httpBodies.DestinationInformation, name, uploadPrefix string) {
+	segmentContainer := getSegmentContainer(destination)
+	prefix := uploadPrefix
+	if prefix == "" {
+		prefix = getSegmentPrefix(name)
+	}
+
+	names, err := c.ObjectNamesAll(segmentContainer, &swift.ObjectsOpts{Prefix: prefix})
+	if err == swift.ContainerNotFound {
+		return
+	} else if err != nil {
+		log.Println("Listing the segments of", name, "failed due to", err.Error())
+		return
+	}
+
+	var keep = make(map[string]bool)
+	if uploadPrefix == "" {
+		referenced, err := getReferencedSegments(c, destination.Container_name, name)
+		if err != nil {
+			log.Println("Reading the manifest of", name, "failed due to", err.Error(), "-> keeping all segments")
+			return
+		}
+		for _, path := range referenced {
+			keep[path] = true
+		}
+	}
+
+	var deleted int
+	for _, segmentName := range names {
+		if keep[segmentContainer+"/"+segmentName] {
+			continue
+		}
+		if err = c.ObjectDelete(segmentContainer, segmentName); err != nil && err != swift.ObjectNotFound {
+			log.Println("Deleting the segment", segmentName, "failed due to", err.Error())
+			continue
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		log.Println("Deleted", deleted, "orphaned segments of", name)
+	}
+}
+
+// getReferencedSegments returns the paths of all segments of the manifest of the object, or none for a plain object.
+func getReferencedSegments(c *swift.Connection, container, name string) ([]string, error) {
+	_, headers, err := c.Object(container, name)
+	if err != nil || !headers.IsLargeObjectSLO() {
+		return nil, err
+	}
+
+	response, _, err := c.Call(c.StorageUrl, swift.RequestOpts{
+		Container:  container,
+		ObjectName: name,
+		Operation:  "GET",
+		Parameters: url.Values{"multipart-manifest": {"get"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	// The manifest lists the segments as "/container/object"
+	var manifest []struct {
+		Name string `json:"name"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&manifest); err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, segment := range manifest {
+		paths = append(paths, strings.TrimPrefix(segment.Name, "/"))
+	}
+	return paths, nil
+}
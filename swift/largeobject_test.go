@@ -0,0 +1,295 @@
+package swift
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/evoila/osb-backup-agent/mutex"
+	"github.com/evoila/osb-backup-agent/storage"
+)
+
+const testAccountPath = "/v1/AUTH_test/"
+
+// fakeSwift is a minimal object store for the requests of uploads, downloads and deletions of large objects.
+// Like Swift, it rejects manifests whose segments do not exist or do not match their size and etag.
+type fakeSwift struct {
+	lock       mutex.Mutex
+	containers map[string]bool
+	objects    map[string][]byte
+	manifests  map[string][]sloSegment
+	// failingSegment is the number of the segment upload that fails, starting at 1, or 0 if none fails
+	failingSegment int
+	segmentPuts    int
+}
+
+func newFakeSwift() (*fakeSwift, *httptest.Server) {
+	fake := &fakeSwift{lock: newReleasedMutex(), containers: make(map[string]bool), objects: make(map[string][]byte), manifests: make(map[string][]sloSegment)}
+	return fake, httptest.NewServer(fake)
+}
+
+func getEtag(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (f *fakeSwift) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Acquire()
+	defer f.lock.Release()
+
+	path := strings.TrimPrefix(r.URL.Path, testAccountPath)
+	parts := strings.SplitN(path, "/", 2)
+	switch {
+	case len(parts) == 1 && r.Method == "PUT":
+		f.containers[parts[0]] = true
+		w.WriteHeader(201)
+	case len(parts) == 1 && r.Method == "GET":
+		f.serveListing(w, r, parts[0])
+	case r.Method == "PUT":
+		f.servePut(w, r, path)
+	case r.Method == "GET" || r.Method == "HEAD":
+		f.serveGet(w, r, path)
+	case r.Method == "DELETE":
+		if _, exists := f.objects[path]; !exists {
+			w.WriteHeader(404)
+			return
+		}
+		delete(f.objects, path)
+		delete(f.manifests, path)
+		w.WriteHeader(204)
+	default:
+		w.WriteHeader(405)
+	}
+}
+
+func (f *fakeSwift) serveListing(w http.ResponseWriter, r *http.Request, container string) {
+	if !f.containers[container] {
+		w.WriteHeader(404)
+		return
+	}
+	var names []string
+	for path := range f.objects {
+		name := strings.TrimPrefix(path, container+"/")
+		if name != path && strings.HasPrefix(name, r.URL.Query().Get("prefix")) && name > r.URL.Query().Get("marker") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	w.WriteHeader(200)
+	for _, name := range names {
+		w.Write([]byte(name + "\n"))
+	}
+}
+
+func (f *fakeSwift) servePut(w http.ResponseWriter, r *http.Request, path string) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	if r.URL.Query().Get("multipart-manifest") != "put" {
+		if strings.Contains(path, "/slo/") {
+			if f.segmentPuts++; f.segmentPuts == f.failingSegment {
+				w.WriteHeader(503)
+				return
+			}
+		}
+		f.objects[path] = data
+		w.Header().Set("Etag", getEtag(data))
+		w.WriteHeader(201)
+		return
+	}
+
+	var segments []sloSegment
+	if err = json.Unmarshal(data, &segments); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	for _, segment := range segments {
+		if content, exists := f.objects[segment.Path]; !exists || int64(len(content)) != segment.Size || getEtag(content) != segment.Etag {
+			w.WriteHeader(400)
+			return
+		}
+	}
+	f.objects[path] = data
+	f.manifests[path] = segments
+	w.WriteHeader(201)
+}
+
+func (f *fakeSwift) serveGet(w http.ResponseWriter, r *http.Request, path string) {
+	data, exists := f.objects[path]
+	if !exists {
+		w.WriteHeader(404)
+		return
+	}
+	if segments, isManifest := f.manifests[path]; isManifest {
+		w.Header().Set("X-Static-Large-Object", "True")
+		if r.URL.Query().Get("multipart-manifest") == "get" {
+			var manifest []map[string]interface{}
+			for _, segment := range segments {
+				manifest = append(manifest, map[string]interface{}{"name": "/" + segment.Path, "bytes": segment.Size, "hash": segment.Etag})
+			}
+			data, _ = json.Marshal(manifest)
+		} else {
+			var joined []byte
+			for _, segment := range segments {
+				joined = append(joined, f.objects[segment.Path]...)
+			}
+			data = joined
+		}
+	} else {
+		w.Header().Set("Etag", getEtag(data))
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(200)
+	if r.Method == "GET" {
+		w.Write(data)
+	}
+}
+
+// getSegmentPaths returns the paths of all stored segments.
+func (f *fakeSwift) getSegmentPaths() []string {
+	f.lock.Acquire()
+	defer f.lock.Release()
+	var paths []string
+	for path := range f.objects {
+		if strings.Contains(path, "/slo/") {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func getTestDestination(server *httptest.Server, segmentSize int64) httpBodies.DestinationInformation {
+	return httpBodies.DestinationInformation{
+		Type:           Type,
+		Auth_token:     "token",
+		Storage_url:    server.URL + strings.TrimSuffix(testAccountPath, "/"),
+		Container_name: "backups",
+		Segment_size:   segmentSize,
+	}
+}
+
+func getTestData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte('a' + i%26)
+	}
+	return data
+}
+
+func TestUploadSplitsLargeObjectsIntoSegments(t *testing.T) {
+	tests := []struct {
+		name     string
+		dataSize int
+		size     int64
+		// segments are the sizes of the expected segments, none for a plain object
+		segments []int64
+	}{
+		{"known size within a segment", 7, 7, nil},
+		{"known size of a segment", 10, 10, nil},
+		{"known size of several segments", 25, 25, []int64{10, 10, 5}},
+		{"unknown size of several segments", 25, storage.UnknownSize, []int64{10, 10, 5}},
+		{"unknown size of exactly two segments", 20, storage.UnknownSize, []int64{10, 10}},
+		{"unknown size within a segment", 7, storage.UnknownSize, []int64{7}},
+		{"unknown size without data", 0, storage.UnknownSize, nil},
+	}
+	for _, test := range tests {
+		fake, server := newFakeSwift()
+		destination := getTestDestination(server, 10)
+		data := getTestData(test.dataSize)
+
+		info, err := Backend{}.Upload(context.Background(), destination, "backup", bytes.NewReader(data), test.size)
+		if err != nil {
+			t.Errorf("%s: the upload failed: %v", test.name, err)
+			server.Close()
+			continue
+		}
+		if info.Size != int64(test.dataSize) {
+			t.Errorf("%s: expected the size %d, got %d", test.name, test.dataSize, info.Size)
+		}
+
+		segments, isManifest := fake.manifests["backups/backup"]
+		if isManifest != (test.segments != nil) || len(segments) != len(test.segments) {
+			t.Errorf("%s: expected the segments %v, got %+v", test.name, test.segments, segments)
+		}
+		var offset int64
+		for i := 0; i < len(segments) && i < len(test.segments); i++ {
+			part := data[offset : offset+test.segments[i]]
+			offset += test.segments[i]
+			if segments[i].Size != test.segments[i] || segments[i].Etag != getEtag(part) || !strings.HasPrefix(segments[i].Path, "backups_segments/backup/slo/") {
+				t.Errorf("%s: unexpected segment %d in the manifest: %+v", test.name, i, segments[i])
+			}
+		}
+		if paths := fake.getSegmentPaths(); len(paths) != len(test.segments) {
+			t.Errorf("%s: expected only the segments of the manifest to be stored, got %v", test.name, paths)
+		}
+
+		var downloaded bytes.Buffer
+		if size, err := (Backend{}).Download(context.Background(), destination, "backup", &downloaded); err != nil || size != int64(len(data)) || !bytes.Equal(downloaded.Bytes(), data) {
+			t.Errorf("%s: expected to download the uploaded data, got %d bytes and %v", test.name, size, err)
+		}
+		server.Close()
+	}
+}
+
+func TestFailedSegmentUploadDeletesTheSegments(t *testing.T) {
+	fake, server := newFakeSwift()
+	defer server.Close()
+	fake.failingSegment = 3
+
+	if _, err := (Backend{}).Upload(context.Background(), getTestDestination(server, 10), "backup", bytes.NewReader(getTestData(45)), storage.UnknownSize); err == nil {
+		t.Fatal("Expected the upload to fail")
+	}
+	if _, exists := fake.objects["backups/backup"]; exists {
+		t.Error("The manifest of the failed upload was put")
+	}
+	if paths := fake.getSegmentPaths(); len(paths) != 0 {
+		t.Errorf("Expected the segments of the failed upload to be deleted, got %v", paths)
+	}
+}
+
+func TestReplacedLargeObjectLeavesNoSegments(t *testing.T) {
+	fake, server := newFakeSwift()
+	defer server.Close()
+	destination := getTestDestination(server, 10)
+
+	for _, size := range []int{35, 15} {
+		if _, err := (Backend{}).Upload(context.Background(), destination, "backup", bytes.NewReader(getTestData(size)), storage.UnknownSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if paths := fake.getSegmentPaths(); len(paths) != 2 {
+		t.Errorf("Expected only the two segments of the new upload, got %v", paths)
+	}
+}
+
+func TestSegmentSizeIsValidated(t *testing.T) {
+	tests := []struct {
+		segmentSize int64
+		valid       bool
+	}{
+		{0, true},
+		{DefaultSegmentSize, true},
+		{MaxSegmentSize, true},
+		{MaxSegmentSize + 1, false},
+		{-1, false},
+	}
+	for _, test := range tests {
+		destination := httpBodies.DestinationInformation{AuthUrl: "url", Container_name: "backups", Auth_token: "token", Project_name: "project", Segment_size: test.segmentSize}
+		if missingFields := (Backend{}).Validate(destination, true); (missingFields == "") != test.valid {
+			t.Errorf("Expected the segment size %d to be valid: %t, got the missing fields %q", test.segmentSize, test.valid, missingFields)
+		}
+	}
+}
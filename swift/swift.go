@@ -1,81 +1,256 @@
 package swift
 
 import (
+	"context"
+	"io"
 	"log"
-	"os"
 
 	"github.com/evoila/osb-backup-agent/errorlog"
 	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/evoila/osb-backup-agent/storage"
 	"github.com/ncw/swift"
 )
 
-func UploadFile(filename, path string, body httpBodies.BackupBody) error {
+// Type : Name of the destination type handled by this backend
+const Type = "SWIFT"
 
-	log.Println("Opening file at", path)
-	file, err := os.Open(path)
-	if err != nil {
-		return errorlog.LogError("Failed to open file ", path, " due to '", err.Error(), "'")
+// Backend stores backups in a container of an OpenStack Swift object store.
+// The swift client does not support contexts, so transfers are aborted by failing their reader or writer once the context is done.
+type Backend struct{}
+
+func (b Backend) Validate(destination httpBodies.DestinationInformation, fileCanBeMissing bool) string {
+	missingFields := ""
+	// A pre-issued token together with the storage url needs no authentication at all
+	if destination.AuthUrl == "" && (destination.Auth_token == "" || destination.Storage_url == "") {
+		missingFields += " authUrl"
+	}
+	if destination.Container_name == "" {
+		missingFields += " container_name"
+	}
+	switch {
+	case destination.Auth_token != "":
+		if destination.Storage_url == "" && destination.Project_name == "" {
+			missingFields += " project_name"
+		}
+	case destination.Application_credential_id != "" || destination.Application_credential_secret != "":
+		if destination.Application_credential_id == "" {
+			missingFields += " application_credential_id"
+		}
+		if destination.Application_credential_secret == "" {
+			missingFields += " application_credential_secret"
+		}
+	default:
+		if destination.Domain == "" {
+			missingFields += " domain"
+		}
+		if destination.Project_name == "" {
+			missingFields += " project_name"
+		}
+		if destination.Username == "" {
+			missingFields += " username"
+		}
+		if destination.Password == "" {
+			missingFields += " password"
+		}
+	}
+	if destination.Segment_size < 0 || destination.Segment_size > MaxSegmentSize {
+		missingFields += " valid segment_size (at most 5 GiB)"
+	}
+	if destination.Filename == "" && !fileCanBeMissing {
+		missingFields += " filename"
 	}
-	defer file.Close()
-	log.Println("Successfully opened file at", path)
+	return missingFields
+}
+
+// Redact returns the fields of the Swift destination with its credentials replaced.
+func (b Backend) Redact(destination httpBodies.DestinationInformation) httpBodies.DestinationInformation {
+	return httpBodies.DestinationInformation{
+		Type:                          destination.Type,
+		AuthUrl:                       destination.AuthUrl,
+		Domain:                        destination.Domain,
+		Filename:                      destination.Filename,
+		Container_name:                destination.Container_name,
+		Project_name:                  destination.Project_name,
+		Username:                      destination.Username,
+		Password:                      httpBodies.GetRedactedOrEmptyPasswordString(destination.Password),
+		Application_credential_id:     destination.Application_credential_id,
+		Application_credential_secret: httpBodies.GetRedactedOrEmptyPasswordString(destination.Application_credential_secret),
+		Auth_token:                    httpBodies.GetRedactedOrEmptyPasswordString(destination.Auth_token),
+		Storage_url:                   destination.Storage_url,
+		Segment_size:                  destination.Segment_size,
+		Segment_container:             destination.Segment_container,
+		Metadata:                      destination.Metadata,
+	}
+}
 
-	c, err := createSwiftConnection(body.Destination)
+// Upload puts files up to the segment size as a single object. Larger files and files of unknown size are uploaded
+// as Static Large Object, whose segments are streamed one after another into the segment container.
+func (b Backend) Upload(ctx context.Context, destination httpBodies.DestinationInformation, name string, reader io.Reader, size int64) (storage.ObjectInfo, error) {
+	c, err := createSwiftConnection(ctx, destination)
 	if err != nil {
-		return errorlog.LogError("Failed to create a authenticated connection to swift due to '", err.Error(), "'")
+		return storage.ObjectInfo{}, err
 	}
+	defer cacheToken(destination, c)
 
-	log.Println("Putting file to swift...")
-	_, err = c.ObjectPut(body.Destination.Container_name, filename, file, true, "", "", nil)
+	reader = storage.NewContextReader(ctx, reader)
+	segmentSize := getSegmentSize(destination)
+	if size >= 0 && size <= segmentSize {
+		log.Println("Putting file to swift...")
+		_, err = c.ObjectPut(destination.Container_name, name, reader, true, "", "", getMetadataHeaders(destination))
+	} else {
+		log.Println("Putting file to swift in segments of", segmentSize, "bytes...")
+		size, err = uploadLargeObject(c, destination, name, reader, segmentSize)
+	}
 	if err != nil {
-		return errorlog.LogError("Failed to put file to swift due to '", err.Error(), "'")
+		return storage.ObjectInfo{}, errorlog.LogError("Failed to put file to swift due to '", err.Error(), "'")
 	}
-	log.Printf("Successfully uploaded %q to %q at %q\n", filename, body.Destination.Container_name, body.Destination.Project_name)
+	log.Printf("Successfully uploaded %q to %q at %q\n", name, destination.Container_name, destination.Project_name)
 
-	return nil
+	// Segments of a large object that was replaced by this upload are not referenced anymore
+	deleteSegments(c, destination, name, "")
+
+	return storage.ObjectInfo{Name: name, Size: size}, nil
 }
 
-func DownloadFile(filename, path string, body httpBodies.RestoreBody) error {
-	log.Println("Creating file at", path)
-	file, err := os.Create(path)
+func (b Backend) Download(ctx context.Context, destination httpBodies.DestinationInformation, name string, writer io.Writer) (int64, error) {
+	c, err := createSwiftConnection(ctx, destination)
+	if err != nil {
+		return 0, err
+	}
+	defer cacheToken(destination, c)
+
+	// Swift joins the segments of large objects itself
+	log.Println("Getting file from swift...")
+	counter := &countingWriter{writer: storage.NewContextWriter(ctx, writer)}
+	_, err = c.ObjectGet(destination.Container_name, name, counter, true, nil)
 	if err != nil {
-		return errorlog.LogError("Failed to create file ", path, " due to '", err.Error(), "'")
+		return counter.count, errorlog.LogError("Failed to download the file ", name, "  due to '", err.Error(), "'")
 	}
-	defer file.Close()
+	log.Println("Successfully downloaded", name, "from swift.")
 
-	log.Println("Not implemented but found swift restore order")
-	c, err := createSwiftConnection(body.Destination)
+	return counter.count, nil
+}
 
+func (b Backend) Stat(ctx context.Context, destination httpBodies.DestinationInformation, name string) (storage.ObjectInfo, error) {
+	c, err := createSwiftConnection(ctx, destination)
 	if err != nil {
-		return errorlog.LogError("Failed to create a authenticated connection to swift due to '", err.Error(), "'")
+		return storage.ObjectInfo{}, err
 	}
+	defer cacheToken(destination, c)
 
-	log.Println("Getting file from swift...")
-	_, err = c.ObjectGet(body.Destination.Container_name, filename, file, true, nil)
+	object, headers, err := c.Object(destination.Container_name, name)
+	if err != nil {
+		return storage.ObjectInfo{}, errorlog.LogError("Failed to get the stats of ", name, " due to '", err.Error(), "'")
+	}
+	return storage.ObjectInfo{Name: object.Name, Size: object.Bytes, LastModified: object.LastModified,
+		Metadata: storage.GetMetadataWithLowerCaseKeys(headers.ObjectMetadata())}, nil
+}
+
+func (b Backend) List(ctx context.Context, destination httpBodies.DestinationInformation, prefix string) ([]storage.ObjectInfo, error) {
+	c, err := createSwiftConnection(ctx, destination)
+	if err != nil {
+		return nil, err
+	}
+	defer cacheToken(destination, c)
 
+	objects, err := c.ObjectsAll(destination.Container_name, &swift.ObjectsOpts{Prefix: prefix})
 	if err != nil {
-		return errorlog.LogError("Failed to download the file ", filename, "  due to '", err.Error(), "'")
+		return nil, errorlog.LogError("Failed to list the objects of container ", destination.Container_name, " due to '", err.Error(), "'")
+	}
+
+	var infos []storage.ObjectInfo
+	for _, object := range objects {
+		infos = append(infos, storage.ObjectInfo{Name: object.Name, Size: object.Bytes, LastModified: object.LastModified})
 	}
+	return infos, nil
+}
 
-	log.Println("Successfully downloaded", file.Name(), "from swift.")
+func (b Backend) Delete(ctx context.Context, destination httpBodies.DestinationInformation, name string) error {
+	c, err := createSwiftConnection(ctx, destination)
+	if err != nil {
+		return err
+	}
+	defer cacheToken(destination, c)
 
+	// Deletes the segments of large objects as well
+	if err = c.LargeObjectDelete(destination.Container_name, name); err != nil {
+		return errorlog.LogError("Failed to delete ", name, " due to '", err.Error(), "'")
+	}
 	return nil
 }
 
-func createSwiftConnection(destination httpBodies.DestinationInformation) (swift.Connection, error) {
+// createSwiftConnection returns a connection, which uses a pre-issued or cached token if possible and authenticates otherwise.
+// The swift client authenticates again if the token is rejected, the caller should pass the connection to cacheToken once
+// it is done, so renewed tokens are reused by later jobs.
+func createSwiftConnection(ctx context.Context, destination httpBodies.DestinationInformation) (*swift.Connection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// Create a connection
-	c := swift.Connection{
+	c := &swift.Connection{
 		UserName: destination.Username,
 		ApiKey:   destination.Password,
 		AuthUrl:  destination.AuthUrl,
 		Domain:   destination.Domain,
 		Tenant:   destination.Project_name, // Tenant is equal to the project name in this connection
 	}
+	if destination.Auth_token != "" {
+		if destination.Storage_url != "" {
+			c.StorageUrl, c.AuthToken = destination.Storage_url, destination.Auth_token
+			// Authenticated also prepares the connection for requests without calling Authenticate
+			c.Authenticated()
+			log.Println("Using the pre-issued token for the swift connection.")
+			return c, nil
+		}
+		// Keystone exchanges the token for a token scoped to the project
+		c.UserName, c.ApiKey, c.AuthVersion = "", destination.Auth_token, 3
+	} else if destination.Application_credential_id != "" {
+		c.UserName, c.ApiKey, c.AuthVersion = "", "", 3
+		c.ApplicationCredentialId = destination.Application_credential_id
+		c.ApplicationCredentialSecret = destination.Application_credential_secret
+	}
+
+	if useCachedToken(destination, c) {
+		log.Println("Using a cached token for the swift connection.")
+		return c, nil
+	}
 
 	// Authenticate
 	err := c.Authenticate()
 	if err != nil {
-		return c, err
+		return nil, errorlog.LogError("Failed to create a authenticated connection to swift due to '", err.Error(), "'")
 	}
 	log.Println("Successfully authenticated swift connection.")
+	cacheToken(destination, c)
 	return c, nil
 }
+
+// getMetadataHeaders returns the headers, which store the metadata of the destination with the object.
+func getMetadataHeaders(destination httpBodies.DestinationInformation) swift.Headers {
+	return swift.Metadata(destination.Metadata).ObjectHeaders()
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.count += int64(n)
+	return n, err
+}
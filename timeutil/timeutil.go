@@ -5,10 +5,17 @@ import (
 	"time"
 )
 
-func GetTimestamp(time *time.Time) string {
+// GetTimestamp returns the time in UTC, as the timestamp always carries the +00:00 offset.
+func GetTimestamp(t *time.Time) string {
+	time := t.UTC()
 	return fmt.Sprintf("%v-%02v-%02vT%02v:%02v:%02v+00:00", time.Year(), int(time.Month()), time.Day(), time.Hour(), time.Minute(), time.Second())
 }
 
+// ParseTimestamp parses timestamps in the format of GetTimestamp as well as any other RFC 3339 timestamp.
+func ParseTimestamp(timestamp string) (time.Time, error) {
+	return time.Parse(time.RFC3339, timestamp)
+}
+
 func GetTimeDifferenceInMilliseconds(startTime, endTime int64) (ms int64) {
 	return (endTime - startTime) / 1000 / 1000 //convert ns to ms
 }
@@ -4,13 +4,28 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/evoila/osb-backup-agent/configuration"
 	"github.com/evoila/osb-backup-agent/errorlog"
 	"github.com/evoila/osb-backup-agent/httpBodies"
 	"github.com/evoila/osb-backup-agent/jobs"
+	"github.com/evoila/osb-backup-agent/storage"
+	"github.com/evoila/osb-backup-agent/timeutil"
 )
 
-var supportedTypes = []string{"S3", "SWIFT"}
+// DefaultJobListLimit : Number of jobs returned by a listing request without a limit
+const DefaultJobListLimit = 100
+
+// JobListQuery holds the filters, the pagination and the options of a listing request.
+type JobListQuery struct {
+	Filter jobs.Filter
+	Offset int
+	Limit  int
+	// Logs decides whether the script logs are part of the listed jobs
+	Logs bool
+}
 
 func UnmarshallIntoBackupBody(w http.ResponseWriter, r *http.Request) (httpBodies.BackupBody, error) {
 	decoder := json.NewDecoder(r.Body)
@@ -94,7 +109,7 @@ func IsAllowedToSpawnNewJob(w http.ResponseWriter, r *http.Request) bool {
 }
 
 func IsSupportedType(w http.ResponseWriter, r *http.Request, body httpBodies.DestinationInformation, action string) bool {
-	if !contains(supportedTypes, body.Type) {
+	if !storage.IsSupported(body.Type) {
 		err := errorlog.LogError(action, " failed during body deserialization due to '", "type not supported", "'")
 		var response = httpBodies.RestoreResponse{Status: httpBodies.Status_failed, Message: action + " failed.", State: "Body Deserialization", ErrorMessage: err.Error(),
 			StartTime: "", EndTime: "", ExecutionTime: 0,
@@ -107,11 +122,123 @@ func IsSupportedType(w http.ResponseWriter, r *http.Request, body httpBodies.Des
 	return true
 }
 
-func contains(s []string, e string) bool {
-	for _, a := range s {
-		if a == e {
-			return true
+// AreTimeoutsValid checks whether all durations in the given timeouts can be parsed and writes a 400 response otherwise.
+func AreTimeoutsValid(w http.ResponseWriter, r *http.Request, timeouts httpBodies.TimeoutInformation, action string) bool {
+	var durations = []string{timeouts.Job}
+	for _, duration := range timeouts.Stages {
+		durations = append(durations, duration)
+	}
+
+	for _, duration := range durations {
+		if duration == "" {
+			continue
+		}
+		if value, err := time.ParseDuration(duration); err != nil || value < 0 {
+			err := errorlog.LogError(action, " failed during body deserialization due to '", "invalid timeout ", duration, "'")
+			var response = httpBodies.ErrorResponse{Message: action + " failed.", State: "Body Deserialization", ErrorMessage: err.Error()}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(response)
+			return false
 		}
 	}
-	return false
+	return true
+}
+
+// GetJobTimeout returns the timeout of the request if given, otherwise the configured job timeout.
+func GetJobTimeout(timeouts httpBodies.TimeoutInformation) time.Duration {
+	if value, err := time.ParseDuration(timeouts.Job); err == nil {
+		return value
+	}
+	return configuration.GetJobTimeout()
+}
+
+// GetStageTimeout returns the timeout for the stage from the request if given, otherwise the configured one.
+func GetStageTimeout(timeouts httpBodies.TimeoutInformation, stageName string) time.Duration {
+	if value, err := time.ParseDuration(timeouts.Stages[stageName]); err == nil {
+		return value
+	}
+	return configuration.GetStageTimeouts()[stageName]
+}
+
+// ParseJobListQuery reads the query parameters of a listing request and writes a 400 response if they are invalid.
+// Supported parameters are status, state, type, database, from, to, offset, limit and logs.
+func ParseJobListQuery(w http.ResponseWriter, r *http.Request) (JobListQuery, bool) {
+	values := r.URL.Query()
+	query := JobListQuery{
+		Filter: jobs.Filter{
+			Status:   values.Get("status"),
+			State:    values.Get("state"),
+			Type:     values.Get("type"),
+			Database: values.Get("database"),
+		},
+		Limit: DefaultJobListLimit,
+		Logs:  true,
+	}
+
+	var err error
+	if value := values.Get("from"); value != "" {
+		query.Filter.From, err = timeutil.ParseTimestamp(value)
+	}
+	if value := values.Get("to"); value != "" && err == nil {
+		query.Filter.To, err = timeutil.ParseTimestamp(value)
+	}
+	if value := values.Get("offset"); value != "" && err == nil {
+		query.Offset, err = strconv.Atoi(value)
+		if err == nil && query.Offset < 0 {
+			err = errors.New("offset is smaller than 0")
+		}
+	}
+	if value := values.Get("limit"); value != "" && err == nil {
+		query.Limit, err = strconv.Atoi(value)
+		if err == nil && query.Limit < 1 {
+			err = errors.New("limit is smaller than 1")
+		}
+	}
+	if value := values.Get("logs"); value != "" && err == nil {
+		query.Logs, err = strconv.ParseBool(value)
+	}
+
+	if err != nil {
+		err = errorlog.LogError("Listing jobs failed due to invalid query parameters: '", err.Error(), "'")
+		var response = httpBodies.ErrorResponse{Message: "Listing jobs failed.", State: "Query Deserialization", ErrorMessage: err.Error()}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(response)
+		return query, false
+	}
+	return query, true
+}
+
+// ParseFollowParameter reads the follow query parameter of a log request and responds with 400 if it is invalid.
+func ParseFollowParameter(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	value := r.URL.Query().Get("follow")
+	if value == "" {
+		return false, true
+	}
+
+	follow, err := strconv.ParseBool(value)
+	if err != nil {
+		err = errorlog.LogError("Streaming the logs failed due to an invalid follow parameter: '", err.Error(), "'")
+		var response = httpBodies.ErrorResponse{Message: "Streaming the logs failed.", State: "Query Deserialization", ErrorMessage: err.Error()}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(response)
+		return false, false
+	}
+	return follow, true
+}
+
+// GetPageBounds returns the start and end index of the requested page within the given number of jobs.
+func (query JobListQuery) GetPageBounds(total int) (int, int) {
+	start := query.Offset
+	if start > total {
+		start = total
+	}
+	// Comparing with the remaining jobs instead of adding the limit to the start can not overflow
+	end := total
+	if query.Limit < total-start {
+		end = start + query.Limit
+	}
+	return start, end
 }
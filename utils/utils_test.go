@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/jobs"
+)
+
+// maxInt : Largest value of an int on the platform
+const maxInt = int(^uint(0) >> 1)
+
+func TestGetPageBounds(t *testing.T) {
+	tests := []struct {
+		name          string
+		offset, limit int
+		total         int
+		start, end    int
+	}{
+		{"first page", 0, 10, 25, 0, 10},
+		{"middle page", 10, 10, 25, 10, 20},
+		{"last page", 20, 10, 25, 20, 25},
+		{"offset at the end", 25, 10, 25, 25, 25},
+		{"offset behind the end", 30, 10, 25, 25, 25},
+		{"no jobs", 0, 10, 0, 0, 0},
+		{"limit of all jobs", 0, 25, 25, 0, 25},
+		{"large limit", 5, 1 << 30, 25, 5, 25},
+		{"largest limit", 5, maxInt, 25, 5, 25},
+		{"largest offset and limit", maxInt, maxInt, 25, 25, 25},
+	}
+	for _, test := range tests {
+		query := JobListQuery{Offset: test.offset, Limit: test.limit}
+		if start, end := query.GetPageBounds(test.total); start != test.start || end != test.end {
+			t.Errorf("%s: expected the bounds %d:%d, got %d:%d", test.name, test.start, test.end, start, end)
+		}
+	}
+}
+
+func TestParseJobListQuery(t *testing.T) {
+	from, _ := time.Parse(time.RFC3339, "2024-03-01T10:00:00+00:00")
+	to, _ := time.Parse(time.RFC3339, "2024-03-02T12:30:00+01:00")
+	tests := []struct {
+		name     string
+		query    string
+		valid    bool
+		expected JobListQuery
+	}{
+		{"defaults", "", true, JobListQuery{Limit: DefaultJobListLimit, Logs: true}},
+		{"filter", "status=FAILED&state=finished&type=S3&database=db", true,
+			JobListQuery{Filter: jobs.Filter{Status: "FAILED", State: "finished", Type: "S3", Database: "db"}, Limit: DefaultJobListLimit, Logs: true}},
+		{"time range", "from=2024-03-01T10:00:00%2B00:00&to=2024-03-02T12:30:00%2B01:00", true,
+			JobListQuery{Filter: jobs.Filter{From: from, To: to}, Limit: DefaultJobListLimit, Logs: true}},
+		{"paging without logs", "offset=20&limit=10&logs=false", true, JobListQuery{Offset: 20, Limit: 10}},
+		{"large paging", "offset=" + strconv.Itoa(maxInt) + "&limit=" + strconv.Itoa(maxInt), true,
+			JobListQuery{Offset: maxInt, Limit: maxInt, Logs: true}},
+		{"offset out of range", "offset=99999999999999999999", false, JobListQuery{}},
+		{"negative offset", "offset=-1", false, JobListQuery{}},
+		{"limit of 0", "limit=0", false, JobListQuery{}},
+		{"limit no number", "limit=ten", false, JobListQuery{}},
+		{"invalid logs", "logs=maybe", false, JobListQuery{}},
+		{"from without time zone", "from=2024-03-01T10:00:00", false, JobListQuery{}},
+		{"invalid to", "to=yesterday", false, JobListQuery{}},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		query, valid := ParseJobListQuery(recorder, httptest.NewRequest("GET", "/v2/backup?"+test.query, nil))
+		if valid != test.valid {
+			t.Errorf("%s: expected the query to be valid: %t, got %t", test.name, test.valid, valid)
+			continue
+		}
+		if !valid {
+			if recorder.Code != 400 {
+				t.Errorf("%s: expected the status 400 for an invalid query, got %d", test.name, recorder.Code)
+			}
+			continue
+		}
+		if query.Offset != test.expected.Offset || query.Limit != test.expected.Limit || query.Logs != test.expected.Logs {
+			t.Errorf("%s: unexpected paging %+v", test.name, query)
+		}
+		filter, expected := query.Filter, test.expected.Filter
+		if filter.Status != expected.Status || filter.State != expected.State || filter.Type != expected.Type || filter.Database != expected.Database ||
+			!filter.From.Equal(expected.From) || !filter.To.Equal(expected.To) {
+			t.Errorf("%s: expected the filter %+v, got %+v", test.name, expected, filter)
+		}
+	}
+}
@@ -7,12 +7,20 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/evoila/osb-backup-agent/azure"
 	"github.com/evoila/osb-backup-agent/backup"
 	"github.com/evoila/osb-backup-agent/configuration"
+	"github.com/evoila/osb-backup-agent/gcs"
 	"github.com/evoila/osb-backup-agent/health"
 	"github.com/evoila/osb-backup-agent/jobs"
+	"github.com/evoila/osb-backup-agent/local"
+	"github.com/evoila/osb-backup-agent/logstream"
+	"github.com/evoila/osb-backup-agent/recovery"
 	"github.com/evoila/osb-backup-agent/restore"
 	"github.com/evoila/osb-backup-agent/s3"
+	"github.com/evoila/osb-backup-agent/sftp"
+	"github.com/evoila/osb-backup-agent/storage"
+	"github.com/evoila/osb-backup-agent/swift"
 	"github.com/gorilla/mux"
 )
 
@@ -30,32 +38,86 @@ func StartWebAgent() {
 		os.Exit(1)
 	}
 	var portAsString = strings.Join([]string{":", strconv.Itoa(port)}, "")
-	jobs.SetUpJobStructure()
-	s3.SetUpS3()
+	if err := jobs.SetUpJobStructure(); err != nil {
+		log.Println("[ERROR]", "Setting up the job store failed. Stopping the agent.")
+		os.Exit(1)
+	}
+	logstream.SetUpLogStreams()
+	recovery.RecoverInterruptedJobs(configuration.GetRecoveryPolicy())
+	jobs.StartJanitor()
+	setUpStorageBackends()
 	log.Println("Successfully prepared the web client")
 
 	log.Println("Starting and running web client on port", GetUsedPort())
 	log.Fatal(http.ListenAndServe(portAsString, router))
 }
 
+// setUpStorageBackends registers all destination types the agent supports.
+func setUpStorageBackends() {
+	storage.Register(s3.Type, s3.Backend{})
+	storage.Register(swift.Type, swift.Backend{})
+	storage.Register(local.Type, local.Backend{})
+	storage.Register(sftp.Type, sftp.Backend{})
+	storage.Register(azure.Type, azure.Backend{})
+	storage.Register(gcs.Type, gcs.Backend{})
+}
+
 func setUpEndpoints(router *mux.Router) {
 	log.Println("Setting up endpoints:")
 	log.Println("GET /status")
 	router.HandleFunc("/status", health.HealthCheck).Methods("GET")
 
+	log.Println("GET /backup")
+	router.HandleFunc("/backup", backup.HandleListing).Methods("GET")
 	log.Println("GET /backup/{id}")
 	router.HandleFunc("/backup/{id}", backup.HandlePolling).Methods("GET")
 	log.Println("POST /backup")
 	router.HandleFunc("/backup", backup.HandleAsyncRequest).Methods("POST")
+	log.Println("POST /backup/{id}/cancel")
+	router.HandleFunc("/backup/{id}/cancel", backup.HandleCancel).Methods("POST")
+	log.Println("GET /backup/{id}/logs")
+	router.HandleFunc("/backup/{id}/logs", backup.HandleLogs).Methods("GET")
 	log.Println("DELETE /backup")
 	router.HandleFunc("/backup", backup.RemoveJob).Methods("DELETE")
 
+	log.Println("GET /restore")
+	router.HandleFunc("/restore", restore.HandleListing).Methods("GET")
 	log.Println("GET /restire/{id}")
 	router.HandleFunc("/restore/{id}", restore.HandlePolling).Methods("GET")
 	log.Println("PUT /restore")
 	router.HandleFunc("/restore", restore.HandleAsyncRequest).Methods("PUT")
+	log.Println("POST /restore/{id}/cancel")
+	router.HandleFunc("/restore/{id}/cancel", restore.HandleCancel).Methods("POST")
+	log.Println("GET /restore/{id}/logs")
+	router.HandleFunc("/restore/{id}/logs", restore.HandleLogs).Methods("GET")
 	log.Println("DELETE /restore")
 	router.HandleFunc("/restore", restore.RemoveJob).Methods("DELETE")
+
+	log.Println("GET /v2/backup")
+	router.HandleFunc("/v2/backup", backup.HandleListingV2).Methods("GET")
+	log.Println("GET /v2/backup/{id}")
+	router.HandleFunc("/v2/backup/{id}", backup.HandlePollingV2).Methods("GET")
+	log.Println("POST /v2/backup")
+	router.HandleFunc("/v2/backup", backup.HandleAsyncRequestV2).Methods("POST")
+	log.Println("POST /v2/backup/{id}/cancel")
+	router.HandleFunc("/v2/backup/{id}/cancel", backup.HandleCancelV2).Methods("POST")
+	log.Println("GET /v2/backup/{id}/logs")
+	router.HandleFunc("/v2/backup/{id}/logs", backup.HandleLogs).Methods("GET")
+	log.Println("DELETE /v2/backup")
+	router.HandleFunc("/v2/backup", backup.RemoveJob).Methods("DELETE")
+
+	log.Println("GET /v2/restore")
+	router.HandleFunc("/v2/restore", restore.HandleListingV2).Methods("GET")
+	log.Println("GET /v2/restore/{id}")
+	router.HandleFunc("/v2/restore/{id}", restore.HandlePollingV2).Methods("GET")
+	log.Println("PUT /v2/restore")
+	router.HandleFunc("/v2/restore", restore.HandleAsyncRequestV2).Methods("PUT")
+	log.Println("POST /v2/restore/{id}/cancel")
+	router.HandleFunc("/v2/restore/{id}/cancel", restore.HandleCancelV2).Methods("POST")
+	log.Println("GET /v2/restore/{id}/logs")
+	router.HandleFunc("/v2/restore/{id}/logs", restore.HandleLogs).Methods("GET")
+	log.Println("DELETE /v2/restore")
+	router.HandleFunc("/v2/restore", restore.RemoveJob).Methods("DELETE")
 	log.Println("End points are set up.")
 }
 
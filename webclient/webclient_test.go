@@ -0,0 +1,329 @@
+package webclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evoila/osb-backup-agent/httpBodies"
+	"github.com/evoila/osb-backup-agent/jobs"
+	"github.com/evoila/osb-backup-agent/logstream"
+	"github.com/evoila/osb-backup-agent/pipeline"
+	"github.com/evoila/osb-backup-agent/shell"
+	"github.com/evoila/osb-backup-agent/storage"
+	"github.com/gorilla/mux"
+)
+
+const testUsername = "admin"
+const testPassword = "secret"
+
+// testAgent serves the endpoints of the agent with scripts and a LOCAL destination in a temporary directory.
+type testAgent struct {
+	server    *httptest.Server
+	directory string
+}
+
+func newTestAgent(t *testing.T, scripts map[string]string) *testAgent {
+	directory, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, subDirectory := range []string{"scripts", "destination", "backup", "restore"} {
+		if err = os.Mkdir(filepath.Join(directory, subDirectory), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range scripts {
+		if err = ioutil.WriteFile(filepath.Join(directory, "scripts", name+".sh"), []byte(content), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	os.Setenv("client_username", testUsername)
+	os.Setenv("client_password", testPassword)
+	os.Setenv("directory_local_destination", filepath.Join(directory, "destination"))
+	os.Setenv("directory_backup", filepath.Join(directory, "backup"))
+	os.Setenv("directory_restore", filepath.Join(directory, "restore"))
+	shell.Directory = filepath.Join(directory, "scripts")
+
+	if err = jobs.SetUpJobStructure(); err != nil {
+		t.Fatal(err)
+	}
+	logstream.SetUpLogStreams()
+	setUpStorageBackends()
+
+	router := mux.NewRouter()
+	setUpEndpoints(router)
+	return &testAgent{server: httptest.NewServer(router), directory: directory}
+}
+
+func (a *testAgent) close() {
+	a.server.Close()
+	os.RemoveAll(a.directory)
+}
+
+// request sends the body as json and decodes the response into result, if result is not nil.
+func (a *testAgent) request(t *testing.T, method, path string, body interface{}, result interface{}) int {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	request, err := http.NewRequest(method, a.server.URL+path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.SetBasicAuth(testUsername, testPassword)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if result != nil {
+		if err = json.NewDecoder(response.Body).Decode(result); err != nil {
+			t.Fatalf("Decoding the response of %s %s failed: %v", method, path, err)
+		}
+	}
+	return response.StatusCode
+}
+
+// waitForJob polls the v2 job until the condition is met.
+func (a *testAgent) waitForJob(t *testing.T, path string, condition func(job map[string]interface{}) bool) map[string]interface{} {
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		var job map[string]interface{}
+		if a.request(t, "GET", path, nil, &job) == 200 && condition(job) {
+			return job
+		}
+	}
+	t.Fatalf("The job at %s did not reach the expected state", path)
+	return nil
+}
+
+func getStageStatus(job map[string]interface{}, name string) string {
+	stages, _ := job["stages"].([]interface{})
+	for _, stage := range stages {
+		if stage, ok := stage.(map[string]interface{}); ok && stage["name"] == name {
+			status, _ := stage["status"].(string)
+			return status
+		}
+	}
+	return ""
+}
+
+func getBackupBody(id string) httpBodies.BackupBody {
+	return httpBodies.BackupBody{
+		Id:             id,
+		Encryption_key: "key",
+		Destination:    httpBodies.DestinationInformation{Type: "LOCAL", Path: "backups"},
+		Backup:         httpBodies.DbInformation{Host: "localhost", Username: "user", Password: "password", Database: "db"},
+	}
+}
+
+func getRestoreBody(id string) httpBodies.RestoreBody {
+	return httpBodies.RestoreBody{
+		Id:             id,
+		Encryption_key: "key",
+		Destination:    httpBodies.DestinationInformation{Type: "LOCAL", Path: "backups", Filename: "backup.sql"},
+		Restore:        httpBodies.DbInformation{Host: "localhost", Username: "user", Password: "password", Database: "db"},
+	}
+}
+
+// getTickingScript returns a script, whose child process appends to the file at the path until it is killed.
+func getTickingScript(path string) string {
+	return "( while true; do echo tick >> '" + path + "'; sleep 0.05; done ) &\nsleep 60\n"
+}
+
+func assertTicksStopped(t *testing.T, path string) {
+	time.Sleep(200 * time.Millisecond)
+	before, _ := ioutil.ReadFile(path)
+	time.Sleep(300 * time.Millisecond)
+	after, _ := ioutil.ReadFile(path)
+	if len(before) == 0 || len(after) != len(before) {
+		t.Errorf("The child process of the script did not run or is still running: %d and %d bytes", len(before), len(after))
+	}
+}
+
+func hasTicks(path string) bool {
+	data, err := ioutil.ReadFile(path)
+	return err == nil && len(data) > 0
+}
+
+// waitForJobToEnd waits until the job released its context, which it does last, so later tests can set up the agent again.
+func waitForJobToEnd(t *testing.T, cancel func(UUID string) bool, UUID string) {
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if !cancel(UUID) {
+			return
+		}
+	}
+	t.Fatalf("The job %s did not end", UUID)
+}
+
+func TestCancelRunningBackup(t *testing.T) {
+	ticks := filepath.Join(os.TempDir(), "cancel-backup-ticks")
+	os.Remove(ticks)
+	defer os.Remove(ticks)
+	agent := newTestAgent(t, map[string]string{"pre-backup-lock": getTickingScript(ticks)})
+	defer agent.close()
+
+	if status := agent.request(t, "POST", "/backup", getBackupBody("running"), nil); status != 201 {
+		t.Fatalf("Expected the backup to start, got %d", status)
+	}
+	agent.waitForJob(t, "/v2/backup/running", func(job map[string]interface{}) bool {
+		return getStageStatus(job, "pre-backup-lock") == pipeline.StageStatusRunning && hasTicks(ticks)
+	})
+
+	var response map[string]interface{}
+	if status := agent.request(t, "POST", "/backup/running/cancel", nil, &response); status != 202 {
+		t.Fatalf("Expected the cancellation to be accepted, got %d", status)
+	}
+	if _, hasStages := response["stages"]; hasStages {
+		t.Error("The v1 cancel endpoint returned the stages of the v2 api")
+	}
+
+	job := agent.waitForJob(t, "/v2/backup/running", func(job map[string]interface{}) bool {
+		return job["status"] == httpBodies.Status_cancelled
+	})
+	waitForJobToEnd(t, jobs.CancelBackupJob, "running")
+	assertTicksStopped(t, ticks)
+	if status := getStageStatus(job, "pre-backup-lock"); status != pipeline.StageStatusFailed {
+		t.Errorf("Expected the killed script to fail, got %s", status)
+	}
+	if status := getStageStatus(job, "backup"); status != pipeline.StageStatusSkipped {
+		t.Errorf("Expected the backup stage to be skipped, got %s", status)
+	}
+
+	if status := agent.request(t, "POST", "/v2/backup/running/cancel", nil, &response); status != 409 || response["status"] != httpBodies.Status_cancelled {
+		t.Errorf("Expected the finished job to be reported with 409, got %d and %v", status, response["status"])
+	}
+	if status := agent.request(t, "POST", "/v2/backup/unknown/cancel", nil, nil); status != 404 {
+		t.Errorf("Expected an unknown job to be reported with 404, got %d", status)
+	}
+}
+
+func TestCancelRunningRestore(t *testing.T) {
+	ticks := filepath.Join(os.TempDir(), "cancel-restore-ticks")
+	os.Remove(ticks)
+	defer os.Remove(ticks)
+	agent := newTestAgent(t, map[string]string{"pre-restore-lock": getTickingScript(ticks)})
+	defer agent.close()
+
+	if status := agent.request(t, "PUT", "/v2/restore", getRestoreBody("running"), nil); status != 201 {
+		t.Fatalf("Expected the restore to start, got %d", status)
+	}
+	agent.waitForJob(t, "/v2/restore/running", func(job map[string]interface{}) bool {
+		return getStageStatus(job, "pre-restore-lock") == pipeline.StageStatusRunning && hasTicks(ticks)
+	})
+
+	var response map[string]interface{}
+	if status := agent.request(t, "POST", "/v2/restore/running/cancel", nil, &response); status != 202 {
+		t.Fatalf("Expected the cancellation to be accepted, got %d", status)
+	}
+	job := agent.waitForJob(t, "/v2/restore/running", func(job map[string]interface{}) bool {
+		return job["status"] == httpBodies.Status_cancelled
+	})
+	waitForJobToEnd(t, jobs.CancelRestoreJob, "running")
+	assertTicksStopped(t, ticks)
+	if status := getStageStatus(job, "download"); status != pipeline.StageStatusSkipped {
+		t.Errorf("Expected the download to be skipped, got %s", status)
+	}
+}
+
+// addListedBackupJobs adds finished backup jobs, which started one hour apart in the order of the ids.
+func addListedBackupJobs(t *testing.T, ids []string, status, destinationType string) {
+	for i, id := range ids {
+		job, err := jobs.AddNewBackupJob(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job.Status, job.State, job.Type, job.Database = status, "finished", destinationType, "db"
+		job.StartTime = fmt.Sprintf("2024-03-01T%02d:00:00+00:00", i)
+		if err = jobs.UpdateBackupJob(id, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func getListedIds(response httpBodies.JobListResponse) string {
+	var ids []string
+	for _, job := range response.Jobs {
+		ids = append(ids, job.Id)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestBackupListingFiltersAndPages(t *testing.T) {
+	agent := newTestAgent(t, nil)
+	defer agent.close()
+	addListedBackupJobs(t, []string{"s3-a", "s3-b", "s3-c"}, httpBodies.Status_success, "S3")
+	addListedBackupJobs(t, []string{"local-a", "local-b"}, httpBodies.Status_failed, "LOCAL")
+
+	largest := strconv.Itoa(int(^uint(0) >> 1))
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		total  int
+		ids    string
+	}{
+		{"all jobs", "", 200, 5, "s3-c,local-b,s3-b,local-a,s3-a"},
+		{"by type", "?type=S3", 200, 3, "s3-c,s3-b,s3-a"},
+		{"by status and type", "?status=" + httpBodies.Status_failed + "&type=LOCAL", 200, 2, "local-b,local-a"},
+		{"by time range", "?from=2024-03-01T01:00:00%2B00:00&to=2024-03-01T02:00:00%2B00:00", 200, 3, "s3-c,local-b,s3-b"},
+		{"page", "?offset=1&limit=2", 200, 5, "local-b,s3-b"},
+		{"largest limit", "?offset=3&limit=" + largest, 200, 5, "local-a,s3-a"},
+		{"largest offset", "?offset=" + largest + "&limit=" + largest, 200, 5, ""},
+		{"invalid limit", "?limit=0", 400, 0, ""},
+		{"invalid time", "?from=yesterday", 400, 0, ""},
+	}
+	for _, test := range tests {
+		var response httpBodies.JobListResponse
+		var result interface{}
+		if test.status == 200 {
+			result = &response
+		}
+		if status := agent.request(t, "GET", "/v2/backup"+test.query, nil, result); status != test.status {
+			t.Errorf("%s: expected the status %d, got %d", test.name, test.status, status)
+			continue
+		}
+		if test.status == 200 && (response.Total != test.total || getListedIds(response) != test.ids) {
+			t.Errorf("%s: expected %d jobs and the page %q, got %d and %q", test.name, test.total, test.ids, response.Total, getListedIds(response))
+		}
+	}
+}
+
+func TestRegisteredBackendsRedactTheCredentials(t *testing.T) {
+	setUpStorageBackends()
+	const secret = "very-secret"
+	destination := httpBodies.DestinationInformation{
+		AuthSecret: secret, Session_token: secret, Web_identity_token: secret, Sse_customer_key: secret,
+		Password: secret, Application_credential_secret: secret, Auth_token: secret,
+		Private_key: secret, Account_key: secret, Sas_token: secret, Service_account_json: secret,
+	}
+
+	for _, destinationType := range storage.GetSupportedTypes() {
+		backend, _ := storage.Get(destinationType)
+		destination.Type = destinationType
+		redacted := backend.Redact(destination)
+		if redacted.Type != destinationType {
+			t.Errorf("%s: the type is missing in the redacted destination", destinationType)
+		}
+		value := reflect.ValueOf(redacted)
+		for i := 0; i < value.NumField(); i++ {
+			if value.Field(i).Kind() == reflect.String && value.Field(i).String() == secret {
+				t.Errorf("%s: the field %s is not redacted", destinationType, value.Type().Field(i).Name)
+			}
+		}
+	}
+}